@@ -1,5 +1,10 @@
 package api
 
+import (
+	"github.com/xp/shorttext-db/entities"
+	"time"
+)
+
 //分片选择器
 type Chooser interface {
 	// 设置分片的桶
@@ -8,6 +13,44 @@ type Chooser interface {
 	Choose(key uint64) (string, uint64)
 	// 获取分片的桶
 	Buckets() []string
+	//每个分片上的分库数量,Choose返回的分库序号从1开始
+	DBCount() uint64
+}
+
+//可以为数据键选择多个副本分片的分片选择器
+type ReplicaChooser interface {
+	Chooser
+	//获取数据键的n个副本所在的分片,第一个为主副本,分片数量不足时返回所有分片
+	ChooseReplicas(key uint64, n int) []string
+}
+
+//能够按Get的方式解析存储的文本的存储,有多个副本时KVStore.Get直接解析多数副本的文本,不再重新读取
+type Decoder interface {
+	Decode(text string, item interface{}) (interface{}, error)
+}
+
+//主键范围及其所在的分片
+type KeyRange struct {
+	Shard string
+	Start uint64
+	End   uint64
+}
+
+//按主键范围连续分区的分片选择器
+type OrderedChooser interface {
+	Chooser
+	//按主键顺序获得[start,end]范围内各段主键所在的分片
+	ChooseRange(start uint64, end uint64) []KeyRange
+}
+
+//按主键顺序遍历记录的迭代器
+type KVIterator interface {
+	//移动到下一条记录,没有更多记录或出错时返回false
+	Next() bool
+	Key() uint64
+	Value() string
+	//遍历过程中的错误
+	Err() error
 }
 
 //存储接口
@@ -26,6 +69,34 @@ type Storage interface {
 
 	SetText(key string, value string, index uint64) error
 
+	//保存文本并创建索引,ttl之后记录及其索引自动删除
+	SetTextWithTTL(key string, value string, index uint64, ttl time.Duration) error
+
+	//查找节点上数据库的所有分库,返回按评分排序的记录
+	Find(text string, opts *entities.FindOptions) ([]entities.Record, error)
+
+	//获得查询文本的关键字在节点所有分库中的BM25统计信息
+	TermStats(text string, opts *entities.FindOptions) (*entities.TermStats, error)
+
+	//按主键顺序分页导出分库中after之后的最多limit条记录
+	Export(index uint64, after string, limit int) (*entities.ExportPage, error)
+
+	//按主键数值顺序扫描分库中[start,end]范围内的最多limit条记录
+	Scan(index uint64, start uint64, end uint64, limit int) (*entities.ScanPage, error)
+
+	//批量读取文本,所有记录作为一个批量请求发送给节点,结果保存在各记录的Value和Err中,
+	//记录不存在时Value为空,整批失败时返回错误
+	MultiGetText(items []*entities.BatchItem) error
+
+	//批量保存文本并创建索引,各记录的结果保存在Err中,整批失败时返回错误
+	MultiSetText(items []*entities.BatchItem) error
+
+	//批量保存文本,不创建索引,各记录的结果保存在Err中,整批失败时返回错误
+	MultiSet(items []*entities.BatchItem) error
+
+	//批量删除,各记录的结果保存在Err中,整批失败时返回错误
+	MultiDelete(items []*entities.BatchItem) error
+
 	Close() error
 }
 
@@ -35,4 +106,22 @@ type IKVStoreClient interface {
 
 	SetText(nKey uint64, val string) error
 	GetText(nKey uint64) string
+	//保存文本并创建索引,ttl之后记录及其索引自动删除,ttl以秒为精度
+	SetTextWithTTL(nKey uint64, val string, ttl time.Duration) error
+
+	//在所有节点上查找文本命中的记录,合并后按评分排序
+	Find(text string, opts *entities.FindOptions) (*entities.FindResult, error)
+
+	//批量读取文本,每个节点发送一个批量请求。返回读取到的文本,记录不存在的主键不在结果中,
+	//读取失败的主键及原因在errs中
+	MultiGet(nKeys []uint64) (values map[uint64]string, errs map[uint64]error)
+	//批量保存文本并创建索引,每个节点发送一个批量请求,返回保存失败的主键及原因
+	MultiSet(values map[uint64]string) map[uint64]error
+	//批量删除,每个节点发送一个批量请求,返回删除失败的主键及原因
+	MultiDelete(nKeys []uint64) map[uint64]error
+
+	//按主键顺序扫描[start,end]范围内的最多limit条记录,返回的Next为下一页的起始主键,为0时没有更多记录
+	Scan(start uint64, end uint64, limit int) (*entities.ScanPage, error)
+	//按主键顺序遍历[start,end]范围内的记录,每次扫描pageSize条
+	Iterator(start uint64, end uint64, pageSize int) KVIterator
 }
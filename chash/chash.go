@@ -2,25 +2,43 @@ package chash
 
 import "github.com/xp/shorttext-db/chash/internal/consistenthash"
 
+// Default number of virtual nodes per bucket
+const DefaultReplicas = 160
+
 type CHash struct {
-	m *consistenthash.Map
-	s []string
+	m        *consistenthash.Map
+	s        []string
+	replicas int
+	weights  map[string]int
 }
 
 func New() *CHash {
+	return NewWeighted(DefaultReplicas, nil)
+}
 
+// NewWeighted creates a CHash with the given number of virtual nodes per
+// bucket. A bucket with weight w gets w times as many virtual nodes;
+// buckets missing from weights have weight 1.
+func NewWeighted(replicas int, weights map[string]int) *CHash {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
 	c := &CHash{
-		m: nil,
-		s: nil,
+		m:        nil,
+		s:        nil,
+		replicas: replicas,
+		weights:  weights,
 	}
 
 	return c
 }
 
 func (c *CHash) SetBuckets(buckets []string) error {
-	c.m = consistenthash.New(160, leveldbHash)
+	c.m = consistenthash.New(c.replicas, leveldbHash)
 	c.s = buckets
-	c.m.Add(buckets...)
+	for _, b := range buckets {
+		c.m.AddWeighted(b, c.weights[b])
+	}
 	return nil
 }
 
@@ -12,6 +12,7 @@ type Map struct {
 	replicas int
 	keys     []int // Sorted
 	hashMap  map[int]string
+	nodes    map[string]struct{}
 }
 
 func New(replicas int, fn Hash) *Map {
@@ -19,6 +20,7 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[int]string),
+		nodes:    make(map[string]struct{}),
 	}
 	return m
 }
@@ -31,15 +33,31 @@ func (m *Map) IsEmpty() bool {
 // Adds some keys to the hash.
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
-		}
+		m.addReplicas(key, m.replicas)
+	}
+	sort.Ints(m.keys)
+}
+
+// Adds a key with weight times the configured number of replicas.
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight <= 0 {
+		weight = 1
 	}
+	m.addReplicas(key, m.replicas*weight)
 	sort.Ints(m.keys)
 }
 
+func (m *Map) addReplicas(key string, replicas int) {
+	if _, ok := m.nodes[key]; !ok {
+		m.nodes[key] = struct{}{}
+	}
+	for i := 0; i < replicas; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = key
+	}
+}
+
 // Gets the closest item in the hash to the provided key.
 func (m *Map) Get(key string) string {
 	if m.IsEmpty() {
@@ -75,6 +93,10 @@ func (m *Map) GetMulti(key string, n int) []string {
 		idx = 0
 	}
 
+	// There are never more unique servers than added keys.
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
 	seen := make(map[string]struct{})
 	result := make([]string, 0, n)
 
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/shardeddb"
+	"os"
+)
+
+/*
+批量导入JSON Lines或CSV文件,例如:
+ bulkload -cpath /opt/gopath/bin/case.txt -db testdb -file data.jsonl -batch 1000
+*/
+var (
+	dbName    = flag.String("db", "", "导入的数据库名字,为空时使用第一个数据库")
+	filePath  = flag.String("file", "", "导入的文件,扩展名为.csv时按CSV格式导入,否则按JSON Lines格式导入")
+	batchSize = flag.Int("batch", shardeddb.DEFAULT_LOAD_BATCH_SIZE, "每批导入的记录数")
+)
+
+func main() {
+	config.LoadSettings("", nil)
+	if len(*filePath) == 0 {
+		fmt.Println("请使用-file指定导入的文件")
+		os.Exit(1)
+	}
+	db := *dbName
+	if len(db) == 0 {
+		db = config.GetConfig().KVDBNames[0]
+	}
+	loader, err := shardeddb.NewBulkLoader(db)
+	if err != nil {
+		fmt.Println("创建批量导入器失败:", err)
+		os.Exit(1)
+	}
+	loader.BatchSize = *batchSize
+	loader.Progress = func(result *shardeddb.LoadResult) {
+		fmt.Printf("已读取:%d 成功:%d 失败:%d\n", result.Read, result.Loaded, result.Failed)
+	}
+	result, err := loader.LoadFile(*filePath)
+	if result != nil {
+		for _, e := range result.Errors {
+			fmt.Printf("第%d行[主键:%d]导入失败:%s\n", e.Line, e.Key, e.Message)
+		}
+		if result.Failed > len(result.Errors) {
+			fmt.Printf("另有%d条失败记录未列出\n", result.Failed-len(result.Errors))
+		}
+		fmt.Printf("导入完成,读取:%d 成功:%d 失败:%d\n", result.Read, result.Loaded, result.Failed)
+	}
+	if err != nil {
+		fmt.Println("导入中断:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/shardeddb"
+	"os"
+)
+
+/*
+以JSON Lines格式导出或导入集群中的数据库,例如:
+ dbdump -cpath /opt/gopath/bin/case.txt -db testdb -export testdb.jsonl -terms
+ dbdump -cpath /opt/gopath/bin/case.txt -db testdb -import testdb.jsonl
+*/
+var (
+	dbName     = flag.String("db", "", "数据库名字,为空时使用第一个数据库")
+	exportPath = flag.String("export", "", "导出的文件")
+	importPath = flag.String("import", "", "导入的文件,导入时保留原有主键")
+	withTerms  = flag.Bool("terms", false, "导出时是否包含索引关键字")
+	pageSize   = flag.Int("page", shardeddb.DEFAULT_EXPORT_PAGE_SIZE, "导出时每页读取的记录数")
+	batchSize  = flag.Int("batch", shardeddb.DEFAULT_LOAD_BATCH_SIZE, "导入时每批发送的记录数")
+)
+
+func main() {
+	config.LoadSettings("", nil)
+	if len(*exportPath) == 0 && len(*importPath) == 0 {
+		fmt.Println("请使用-export或-import指定文件")
+		os.Exit(1)
+	}
+	db := *dbName
+	if len(db) == 0 {
+		db = config.GetConfig().KVDBNames[0]
+	}
+	if len(*exportPath) > 0 {
+		exportDB(db)
+	} else {
+		importDB(db)
+	}
+}
+
+func exportDB(db string) {
+	exporter, err := shardeddb.NewExporter(db)
+	if err != nil {
+		fmt.Println("创建导出器失败:", err)
+		os.Exit(1)
+	}
+	exporter.PageSize = *pageSize
+	exporter.WithTerms = *withTerms
+	exporter.Progress = func(count int) {
+		fmt.Printf("已导出:%d\n", count)
+	}
+	file, err := os.Create(*exportPath)
+	if err != nil {
+		fmt.Println("创建导出文件失败:", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	count, err := exporter.Export(w)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Println("导出中断:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("导出完成,记录数:%d\n", count)
+}
+
+func importDB(db string) {
+	loader, err := shardeddb.NewBulkLoader(db)
+	if err != nil {
+		fmt.Println("创建导入器失败:", err)
+		os.Exit(1)
+	}
+	loader.BatchSize = *batchSize
+	loader.Progress = func(result *shardeddb.LoadResult) {
+		fmt.Printf("已读取:%d 成功:%d 失败:%d\n", result.Read, result.Loaded, result.Failed)
+	}
+	file, err := os.Open(*importPath)
+	if err != nil {
+		fmt.Println("打开导入文件失败:", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	result, err := loader.Import(file)
+	if result != nil {
+		for _, e := range result.Errors {
+			fmt.Printf("第%d行[主键:%d]导入失败:%s\n", e.Line, e.Key, e.Message)
+		}
+		fmt.Printf("导入完成,读取:%d 成功:%d 失败:%d\n", result.Read, result.Loaded, result.Failed)
+	}
+	if err != nil {
+		fmt.Println("导入中断:", err)
+		os.Exit(1)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"github.com/xp/shorttext-db/glogger"
 	"io/ioutil"
+	"strings"
 	"sync"
 )
 
@@ -69,9 +70,30 @@ type Config struct {
 	//KV数据库文件路径
 	KVDBFilePath string `json:"KVDBFilePath"`
 
+	//运行时用户词典的保存路径,为空时保存在KVDBFilePath下节点目录的user_dict.json
+	KVUserDictPath string `json:"KVUserDictPath"`
+
+	//同义词词典的路径,每行一组以逗号分隔的同义词,为空时保存在KVDBFilePath下节点目录的synonyms.txt
+	KVSynonymPath string `json:"KVSynonymPath"`
+
+	//同义词命中时计入命中占比和评分的权重,取值(0,1],未配置时为DEFAULT_SYNONYM_WEIGHT
+	KVSynonymWeight float32 `json:"KVSynonymWeight"`
+
 	//KV数据库名字
 	KVDBNames []string `json:"KVDBNames"`
 
+	//KV数据库的索引字段,键为数据库名字,未配置的数据库只索引desc字段
+	KVDBIndexFields map[string][]IndexField `json:"KVDBIndexFields"`
+
+	//KV数据库用于过滤的JSON字段,键为数据库名字,值为gjson路径,为这些字段创建二级索引,加速等值过滤
+	KVDBFilterIndexes map[string][]string `json:"KVDBFilterIndexes"`
+
+	//KV数据库追加日志的同步策略,可选never、everysecond、always,为空时为everysecond
+	KVDBSyncPolicy string `json:"KVDBSyncPolicy"`
+
+	//KV数据库快照的时间间隔,单位秒,快照把追加日志压缩为每条记录一条命令,未配置时为DEFAULT_SNAPSHOT_INTERVAL
+	KVDBSnapshotInterval int64 `json:"KVDBSnapshotInterval"`
+
 	//同一个KV数据库的分库数量
 	KVDBMaxRange int64 `json:"KVDBMaxRange"`
 
@@ -81,12 +103,34 @@ type Config struct {
 	//KV数据节点范围分区起始值
 	KVDRowStart int64 `json:"KVDRowStart"`
 
+	//KV数据库的分片选择器,可选range、hash,为空时为range。更换选择器后需要重新平衡已有数据
+	KVDBChooser string `json:"KVDBChooser"`
+
+	//一致性哈希每个节点的虚拟节点数量,未配置时为chash.DefaultReplicas
+	KVDBHashReplicas int `json:"KVDBHashReplicas"`
+
+	//一致性哈希的节点权重,键为节点名字,未配置的节点权重为1
+	KVDBNodeWeights map[string]int `json:"KVDBNodeWeights"`
+
+	//KV数据库每条记录的副本数量,包含主副本,未配置或为1时不复制
+	KVDBReplicas int `json:"KVDBReplicas"`
+
+	//写入成功需要的副本数量,可选one、majority、all,为空时为majority
+	KVDBWriteQuorum string `json:"KVDBWriteQuorum"`
+
+	//有多个副本时删除记录写入的墓碑的保留时间,单位秒,之后自动删除,未配置时为DEFAULT_TOMBSTONE_TTL。
+	//副本不可用的时间超过该时间时,恢复后可能使已删除的记录重新出现
+	KVDBTombstoneTTL int64 `json:"KVDBTombstoneTTL"`
+
 	//序列服务器地址
 	SequenceServer string `json:"SequenceServer"`
 
 	//日志级别
 	LogLevel string `json:"LogLevel"`
 
+	//分词器名字,可选jieba、ngram,为空时优先使用jieba
+	Cutter string `json:"Cutter"`
+
 	DictPath string `json:"DictPath"`
 
 	HmmPath string `json:"HmmPath"`
@@ -97,9 +141,124 @@ type Config struct {
 
 	StopWordsPath string `json:"StopWordsPath"`
 
+	//繁简对照表路径,每行为"繁体字 简体字",补充内置的对照表
+	T2SDictPath string `json:"T2SDictPath"`
+
+	//拼音字典路径,每行为"汉字 拼音",多音字的读音以逗号分隔,补充或覆盖内置的常用汉字拼音表
+	PinyinDictPath string `json:"PinyinDictPath"`
+
+	//创建索引时是否同时索引中文关键字的全拼和拼音首字母
+	PinyinIndex bool `json:"PinyinIndex"`
+
 	WorkerPerMaster int
 }
 
+//索引字段,Path为gjson路径,Weight为评分权重
+type IndexField struct {
+	Path   string  `json:"Path"`
+	Weight float32 `json:"Weight"`
+}
+
+/*
+获得数据库的索引字段,未配置时返回desc字段
+*/
+func (c *Config) GetIndexFields(dbName string) []IndexField {
+	fields, ok := c.KVDBIndexFields[dbName]
+	if !ok || len(fields) == 0 {
+		return []IndexField{{Path: GJSON_FIELD_DESC, Weight: 1}}
+	}
+	return fields
+}
+
+/*
+获得数据库用于过滤的二级索引字段
+*/
+func (c *Config) GetFilterIndexes(dbName string) []string {
+	return c.KVDBFilterIndexes[dbName]
+}
+
+/*
+获得同义词命中的权重
+*/
+func (c *Config) GetSynonymWeight() float32 {
+	if c == nil || c.KVSynonymWeight <= 0 || c.KVSynonymWeight > 1 {
+		return DEFAULT_SYNONYM_WEIGHT
+	}
+	return c.KVSynonymWeight
+}
+
+/*
+获得KV数据库追加日志的同步策略
+*/
+func (c *Config) GetSyncPolicy() string {
+	if c == nil {
+		return SYNC_POLICY_EVERY_SECOND
+	}
+	switch strings.ToLower(c.KVDBSyncPolicy) {
+	case SYNC_POLICY_NEVER:
+		return SYNC_POLICY_NEVER
+	case SYNC_POLICY_ALWAYS:
+		return SYNC_POLICY_ALWAYS
+	}
+	return SYNC_POLICY_EVERY_SECOND
+}
+
+/*
+获得KV数据库的分片选择器
+*/
+func (c *Config) GetChooser() string {
+	if c != nil && strings.ToLower(c.KVDBChooser) == CHOOSER_HASH {
+		return CHOOSER_HASH
+	}
+	return CHOOSER_RANGE
+}
+
+/*
+获得KV数据库每条记录的副本数量
+*/
+func (c *Config) GetReplicas() int {
+	if c == nil || c.KVDBReplicas < 1 {
+		return 1
+	}
+	return c.KVDBReplicas
+}
+
+/*
+获得KV数据库写入成功需要的副本数量
+*/
+func (c *Config) GetWriteQuorum() string {
+	if c == nil {
+		return QUORUM_MAJORITY
+	}
+	switch strings.ToLower(c.KVDBWriteQuorum) {
+	case QUORUM_ONE:
+		return QUORUM_ONE
+	case QUORUM_ALL:
+		return QUORUM_ALL
+	}
+	return QUORUM_MAJORITY
+}
+
+/*
+获得删除记录写入的墓碑的保留时间,单位秒
+*/
+func (c *Config) GetTombstoneTTL() int64 {
+	if c == nil || c.KVDBTombstoneTTL <= 0 {
+		return DEFAULT_TOMBSTONE_TTL
+	}
+	return c.KVDBTombstoneTTL
+}
+
+/*
+获得KV数据库快照的时间间隔,单位秒
+*/
+func (c *Config) GetSnapshotInterval() int64 {
+	if c == nil || c.KVDBSnapshotInterval <= 0 {
+		return DEFAULT_SNAPSHOT_INTERVAL
+	}
+	return c.KVDBSnapshotInterval
+}
+
 func GetConfig() *Config {
 
 	return configInfo
@@ -18,6 +18,17 @@ const (
 	MSG_KV_RESULT_FAILURE = 3002
 
 	MSG_MR_CONSUME = 1008
+
+	//更新运行时用户词典
+	MSG_KV_DICT = 1009
+	//分页导出分库的记录
+	MSG_KV_EXPORT = 1010
+	//获取节点所有分库的统计信息
+	MSG_KV_STATS = 1011
+	//按主键数值顺序扫描分库中一个范围内的记录
+	MSG_KV_SCAN = 1012
+	//统计查询关键字在节点所有分库中的BM25统计信息
+	MSG_KV_TERMSTATS = 1013
 )
 
 const (
@@ -27,3 +38,40 @@ const (
 	GJSON_FIELD_ID   = "id"
 	GJSON_FIELD_DESC = "desc"
 )
+
+//同义词命中的缺省权重
+const DEFAULT_SYNONYM_WEIGHT float32 = 0.8
+
+//KV数据库追加日志的同步策略
+const (
+	//由操作系统决定何时写入磁盘
+	SYNC_POLICY_NEVER = "never"
+	//每秒同步一次,最多丢失1秒的写入
+	SYNC_POLICY_EVERY_SECOND = "everysecond"
+	//每次写入后同步
+	SYNC_POLICY_ALWAYS = "always"
+)
+
+//KV数据库的分片选择器
+const (
+	//按主键范围依次分配到各节点
+	CHOOSER_RANGE = "range"
+	//按主键的一致性哈希分配到各节点
+	CHOOSER_HASH = "hash"
+)
+
+//KV数据库写入成功需要的副本数量
+const (
+	//任意一个副本写入成功
+	QUORUM_ONE = "one"
+	//多数副本写入成功
+	QUORUM_MAJORITY = "majority"
+	//所有副本写入成功
+	QUORUM_ALL = "all"
+)
+
+//KV数据库快照(压缩追加日志)的缺省时间间隔,单位秒
+const DEFAULT_SNAPSHOT_INTERVAL int64 = 3000
+
+//删除记录写入的墓碑的缺省保留时间,单位秒,为7天
+const DEFAULT_TOMBSTONE_TTL int64 = 7 * 24 * 3600
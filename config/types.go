@@ -3,3 +3,33 @@ package config
 type Text []rune
 type TextSet map[string]int
 type RatioSet map[string]float32
+
+//命中记录的相关度,Ratio为命中关键字的字符占比,Score为BM25评分,Fields为命中的索引字段,Terms为命中的关键字
+type RankItem struct {
+	Key    string
+	Ratio  float32
+	Score  float32
+	Fields []string
+	Terms  []string
+}
+
+//按评分从高到低排序的命中记录
+type RankList []RankItem
+
+func (r RankList) Len() int {
+	return len(r)
+}
+
+func (r RankList) Less(i, j int) bool {
+	if r[i].Score != r[j].Score {
+		return r[i].Score > r[j].Score
+	}
+	if r[i].Ratio != r[j].Ratio {
+		return r[i].Ratio > r[j].Ratio
+	}
+	return r[i].Key < r[j].Key
+}
+
+func (r RankList) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
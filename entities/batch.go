@@ -0,0 +1,12 @@
+package entities
+
+/*
+批量操作的一条记录,Index为分库序号。读取时Value为读取到的文本,记录不存在时为空;
+操作失败时Err为失败原因
+*/
+type BatchItem struct {
+	Key   string
+	Index uint64
+	Value string
+	Err   error
+}
@@ -0,0 +1,25 @@
+package entities
+
+/*
+用户词典的更新操作
+*/
+const (
+	DICT_ACTION_ADD    = 1
+	DICT_ACTION_REMOVE = 2
+)
+
+/*
+用户词典更新请求，由DBNode.UpdateDictionary执行，或通过MSG_KV_DICT消息发送给各个节点
+*/
+type DictRequest struct {
+	//见DICT_ACTION_*
+	Action int
+	//自定义词
+	Words []string
+	//停用词
+	StopWords []string
+	//同义词组,增加时每组中的词互为同义词,删除时从所在的组中删除组内的每个词
+	Synonyms [][]string
+	//是否对包含自定义词和停用词的记录重建索引,同义词变化时总是对包含变化同义词的记录重建索引
+	Reindex bool
+}
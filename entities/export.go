@@ -0,0 +1,22 @@
+package entities
+
+import "encoding/json"
+
+/*
+导出的记录,每条记录为JSON Lines文件中的一行。Value为存储的JSON文本,不是JSON时为JSON字符串,
+Terms为记录索引字段的关键字,导出时指定才包含,TTL为导出时记录剩余的生存时间(毫秒),没有过期时间时为0
+*/
+type ExportRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	Terms []string        `json:"terms,omitempty"`
+	TTL   int64           `json:"ttl,omitempty"`
+}
+
+/*
+分页导出的一页记录,Next为下一页的起始主键(不包含),为空表示已导出全部记录
+*/
+type ExportPage struct {
+	Records []ExportRecord `json:"records"`
+	Next    string         `json:"next"`
+}
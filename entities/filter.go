@@ -0,0 +1,30 @@
+package entities
+
+/*
+结构化过滤的操作
+*/
+const (
+	//等于
+	FILTER_EQ = "eq"
+	//大于
+	FILTER_GT = "gt"
+	//大于等于
+	FILTER_GTE = "gte"
+	//小于
+	FILTER_LT = "lt"
+	//小于等于
+	FILTER_LTE = "lte"
+	//等于Values中的任意一个值
+	FILTER_IN = "in"
+)
+
+/*
+记录JSON字段的过滤条件,Path为gjson路径,多个过滤条件之间为并且关系,
+范围查找使用同一路径的两个条件,例如gte和lt。字符串比较忽略大小写,范围比较要求类型相同
+*/
+type Filter struct {
+	Path   string        `json:"path"`
+	Op     string        `json:"op"`
+	Value  interface{}   `json:"value,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
@@ -0,0 +1,145 @@
+package entities
+
+/*
+关键字匹配模式
+*/
+const (
+	//命中关键字的字符占比不低于MinRatio
+	MATCH_RATIO = 0
+	//所有关键字都必须命中
+	MATCH_ALL = 1
+	//命中任意一个关键字
+	MATCH_ANY = 2
+	//记录描述中包含完整的查询文本
+	MATCH_PHRASE = 3
+)
+
+//缺省的命中关键字字符占比
+const DEFAULT_MIN_RATIO float32 = 0.5
+
+//模糊匹配允许的最大编辑距离
+const MAX_FUZZY_DISTANCE = 2
+
+//分页查找的缺省每页记录数
+const DEFAULT_PAGE_SIZE = 100
+
+/*
+查找选项，由DBNode.Find传递给各个分库
+*/
+type FindOptions struct {
+	//返回记录的最大数量,小于等于0时返回全部
+	Limit int
+	//MATCH_RATIO模式下命中关键字的最小字符占比,小于等于0时使用DEFAULT_MIN_RATIO
+	MinRatio float32
+	//匹配模式
+	Mode int
+	//精确关键字未命中时，模糊匹配允许的最大编辑距离,0表示不进行模糊匹配,最大为MAX_FUZZY_DISTANCE
+	Fuzzy int
+	//是否返回命中的关键字及其在描述中的位置
+	Highlight bool
+	//记录JSON字段的过滤条件,由各分库在读取命中记录时过滤
+	Filters []Filter
+	//所有分库汇总的BM25统计信息,为空时各分库按本分库的统计评分
+	Stats *TermStats
+}
+
+func NewFindOptions() *FindOptions {
+	return &FindOptions{MinRatio: DEFAULT_MIN_RATIO, Mode: MATCH_RATIO}
+}
+
+/*
+获得实际使用的最小字符占比
+*/
+func (o *FindOptions) GetMinRatio() float32 {
+	if o == nil || o.MinRatio <= 0 {
+		return DEFAULT_MIN_RATIO
+	}
+	return o.MinRatio
+}
+
+func (o *FindOptions) GetMode() int {
+	if o == nil {
+		return MATCH_RATIO
+	}
+	return o.Mode
+}
+
+func (o *FindOptions) GetLimit() int {
+	if o == nil {
+		return 0
+	}
+	return o.Limit
+}
+
+func (o *FindOptions) GetHighlight() bool {
+	return o != nil && o.Highlight
+}
+
+func (o *FindOptions) GetFilters() []Filter {
+	if o == nil {
+		return nil
+	}
+	return o.Filters
+}
+
+func (o *FindOptions) GetStats() *TermStats {
+	if o == nil {
+		return nil
+	}
+	return o.Stats
+}
+
+func (o *FindOptions) GetFuzzy() int {
+	if o == nil || o.Fuzzy <= 0 {
+		return 0
+	}
+	if o.Fuzzy > MAX_FUZZY_DISTANCE {
+		return MAX_FUZZY_DISTANCE
+	}
+	return o.Fuzzy
+}
+
+/*
+BM25评分使用的统计信息,键为索引字段的gjson路径:Docs为字段中的记录数,Lens为字段中的关键字总数,
+DF为查询关键字在字段中命中的记录数。查找多个分库时汇总各分库的统计后按相同的IDF和平均长度评分,
+各分库的评分才能直接合并排序
+*/
+type TermStats struct {
+	Docs map[string]int            `json:"docs"`
+	Lens map[string]int            `json:"lens"`
+	DF   map[string]map[string]int `json:"df"`
+}
+
+func NewTermStats() *TermStats {
+	return &TermStats{Docs: make(map[string]int), Lens: make(map[string]int), DF: make(map[string]map[string]int)}
+}
+
+/*
+累加关键字在字段中命中的记录数
+*/
+func (s *TermStats) AddDF(field string, term string, df int) {
+	if s.DF[field] == nil {
+		s.DF[field] = make(map[string]int)
+	}
+	s.DF[field][term] = s.DF[field][term] + df
+}
+
+/*
+累加其他分库或节点的统计信息
+*/
+func (s *TermStats) Add(other *TermStats) {
+	if other == nil {
+		return
+	}
+	for field, docs := range other.Docs {
+		s.Docs[field] = s.Docs[field] + docs
+	}
+	for field, lens := range other.Lens {
+		s.Lens[field] = s.Lens[field] + lens
+	}
+	for field, terms := range other.DF {
+		for term, df := range terms {
+			s.AddDF(field, term, df)
+		}
+	}
+}
@@ -1,7 +1,92 @@
 package entities
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 type Record struct {
 	Id          string  `json:"id"`
 	Desc        string  `json:"desc"`
 	PrefixRatio float32 `json:"-"`
+	Score       float32 `json:"-"`
+	//存储的完整JSON文本
+	Value string `json:"-"`
+	//命中的索引字段
+	Fields []string `json:"-"`
+	//命中的关键字,FindOptions.Highlight为true时返回
+	Terms []string `json:"terms,omitempty"`
+	//命中的关键字在描述中的位置,FindOptions.Highlight为true时返回
+	Matches []Match `json:"matches,omitempty"`
+}
+
+/*
+分页查找的结果,Cursor用于查找下一页,没有更多记录时为空。
+Failures为查找失败或结果不完整的分库及原因,不为空时Records只包含其余分库的记录
+*/
+type Page struct {
+	Records  []Record          `json:"records"`
+	Cursor   string            `json:"cursor"`
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+//部分分库查找失败时返回true
+func (p *Page) Partial() bool {
+	return len(p.Failures) > 0
+}
+
+/*
+集群查找的结果,Failures为查找失败或结果不完整的分库及原因,不为空时Records只包含其余分库的记录
+*/
+type FindResult struct {
+	Records  []Record          `json:"records"`
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+//部分分库查找失败时返回true
+func (r *FindResult) Partial() bool {
+	return len(r.Failures) > 0
+}
+
+/*
+部分分库查找失败或索引尚未重建完成时返回的错误,同时返回的记录只包含其余分库的记录,
+Failures为结果不完整的分库及原因
+*/
+type PartialError struct {
+	Failures map[string]string
+}
+
+func (e *PartialError) Error() string {
+	items := make([]string, 0, len(e.Failures))
+	for name, msg := range e.Failures {
+		items = append(items, name+":"+msg)
+	}
+	sort.Strings(items)
+	return fmt.Sprintf("查找结果不完整:%s", strings.Join(items, ";"))
+}
+
+//关键字在描述中的位置,Start和End为字符(rune)下标,不包含End
+type Match struct {
+	Term  string `json:"term"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+//按评分从高到低比较两条记录
+func (r *Record) Before(other *Record) bool {
+	if r.Score != other.Score {
+		return r.Score > other.Score
+	}
+	if r.PrefixRatio != other.PrefixRatio {
+		return r.PrefixRatio > other.PrefixRatio
+	}
+	return r.Id < other.Id
+}
+
+//近似重复的记录,Similarity为关键字集合的相似度,取值[0,1]
+type SimilarRecord struct {
+	Id         string  `json:"id"`
+	Desc       string  `json:"desc"`
+	Similarity float32 `json:"similarity"`
 }
@@ -0,0 +1,17 @@
+package entities
+
+/*
+范围扫描的一条记录
+*/
+type KeyValue struct {
+	Key   uint64 `json:"key"`
+	Value string `json:"value"`
+}
+
+/*
+按主键顺序范围扫描的一页记录,Next为下一页的起始主键,为0时没有更多记录
+*/
+type ScanPage struct {
+	Records []KeyValue `json:"records"`
+	Next    uint64     `json:"next"`
+}
@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+/*
+分库的统计信息。MemoryBytes为记录主键和文本的字节数,不包含索引;
+FileBytes为追加日志文件的大小;LastSave为最近一次生成快照的时间,未生成过快照时为零值;
+TombstoneCount为有多个副本时删除记录写入的墓碑数量,不计入KeyCount
+*/
+type DBStats struct {
+	Name           string    `json:"name"`
+	KeyCount       int       `json:"keyCount"`
+	TombstoneCount int       `json:"tombstoneCount"`
+	TermCount      int       `json:"termCount"`
+	MemoryBytes    int64     `json:"memoryBytes"`
+	FileBytes      int64     `json:"fileBytes"`
+	IndexStatus    int       `json:"indexStatus"`
+	LastSave       time.Time `json:"lastSave"`
+}
+
+/*
+节点的统计信息,获取失败时Error为错误信息
+*/
+type NodeStats struct {
+	Node      string    `json:"node"`
+	Databases []DBStats `json:"databases"`
+	Error     string    `json:"error,omitempty"`
+}
@@ -2,8 +2,9 @@ package filedb
 
 import (
 	"encoding/binary"
+	"errors"
 	"github.com/xp/shorttext-db/glogger"
-	"sync/atomic"
+	"sync"
 )
 
 var logger = glogger.MustGetLogger("filedb")
@@ -13,6 +14,8 @@ const seq = "seq"
 type SequenceSvc interface {
 	Next(name string) uint64
 	SetStart(name string, start uint64) error
+	//预留count个连续的序列值,返回第一个序列值
+	Reserve(name string, count uint64) (uint64, error)
 }
 
 type Sequence struct {
@@ -21,6 +24,7 @@ type Sequence struct {
 	next     uint64
 	//key      []byte
 	bucket []byte
+	mu     sync.Mutex
 }
 
 func NewSequence(start uint64) *Sequence {
@@ -50,25 +54,37 @@ func (s *Sequence) SetStart(name string, start uint64) error {
 }
 
 func (s *Sequence) Next(name string) uint64 {
-	//key := "sequence"
+	next, err := s.Reserve(name, 1)
+	if err != nil {
+		return 0
+	}
+	return next
+}
+
+/*
+预留count个连续的序列值,返回第一个序列值,批量导入时一次获取一批主键
+*/
+func (s *Sequence) Reserve(name string, count uint64) (uint64, error) {
 	var bytes []byte
-	var next uint64
+	var current uint64
+	if count == 0 {
+		return 0, errors.New("预留的序列数量必须大于0")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	key := []byte(name)
 	bytes = Get(s.bucket, key)
-	if len(bytes) == 0 {
-		next = 0
-	} else {
-		next = binary.LittleEndian.Uint64(bytes)
+	if len(bytes) > 0 {
+		current = binary.LittleEndian.Uint64(bytes)
 	}
-
-	s.next = atomic.AddUint64(&next, 1)
 	bytes = make([]byte, 8)
-	binary.LittleEndian.PutUint64(bytes, s.next)
+	binary.LittleEndian.PutUint64(bytes, current+count)
 	err := Put(s.bucket, key, bytes)
 	if err != nil {
-		return 0
+		return 0, err
 	}
-	return s.next
+	s.next = current + count
+	return current + 1, nil
 }
 
 func (s *Sequence) Close() {
@@ -24,6 +24,7 @@ type SequenceMsg struct {
 	Start                uint64   `protobuf:"varint,1,opt,name=Start,proto3" json:"Start,omitempty"`
 	Next                 uint64   `protobuf:"varint,2,opt,name=Next,proto3" json:"Next,omitempty"`
 	Name                 string   `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	Count                uint64   `protobuf:"varint,4,opt,name=Count,proto3" json:"Count,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -75,6 +76,13 @@ func (m *SequenceMsg) GetName() string {
 	return ""
 }
 
+func (m *SequenceMsg) GetCount() uint64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*SequenceMsg)(nil), "filedb.SequenceMsg")
 }
@@ -82,12 +90,13 @@ func init() {
 func init() { proto.RegisterFile("sequence.proto", fileDescriptor_e97b888ecada2421) }
 
 var fileDescriptor_e97b888ecada2421 = []byte{
-	// 108 bytes of a gzipped FileDescriptorProto
+	// 118 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2b, 0x4e, 0x2d, 0x2c,
 	0x4d, 0xcd, 0x4b, 0x4e, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x4b, 0xcb, 0xcc, 0x49,
-	0x4d, 0x49, 0x52, 0xf2, 0xe6, 0xe2, 0x0e, 0x86, 0xca, 0xf8, 0x16, 0xa7, 0x0b, 0x89, 0x70, 0xb1,
+	0x4d, 0x49, 0x52, 0x4a, 0xe4, 0xe2, 0x0e, 0x86, 0xca, 0xf8, 0x16, 0xa7, 0x0b, 0x89, 0x70, 0xb1,
 	0x06, 0x97, 0x24, 0x16, 0x95, 0x48, 0x30, 0x2a, 0x30, 0x6a, 0xb0, 0x04, 0x41, 0x38, 0x42, 0x42,
 	0x5c, 0x2c, 0x7e, 0xa9, 0x15, 0x25, 0x12, 0x4c, 0x60, 0x41, 0x30, 0x1b, 0x2c, 0x96, 0x98, 0x9b,
-	0x2a, 0xc1, 0xac, 0xc0, 0xa8, 0xc1, 0x19, 0x04, 0x66, 0x27, 0xb1, 0x81, 0xcd, 0x36, 0x06, 0x04,
-	0x00, 0x00, 0xff, 0xff, 0x45, 0x09, 0x1c, 0xfa, 0x6d, 0x00, 0x00, 0x00,
+	0x2a, 0xc1, 0xac, 0xc0, 0xa8, 0xc1, 0x19, 0x04, 0x66, 0x83, 0x74, 0x3b, 0xe7, 0x97, 0xe6, 0x95,
+	0x48, 0xb0, 0x40, 0x74, 0x83, 0x39, 0x49, 0x6c, 0x60, 0x1b, 0x8d, 0x01, 0x03, 0x00, 0x07, 0x78,
+	0x9b, 0x2c, 0x83, 0x00, 0x00, 0x00,
 }
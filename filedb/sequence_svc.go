@@ -2,6 +2,8 @@ package filedb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"google.golang.org/grpc"
 	"net"
 	"time"
@@ -29,10 +31,20 @@ func StartSequenceService() {
 
 }
 
+/*
+生成序列值,请求的Count大于0时预留Count个连续的序列值,返回第一个序列值和实际预留的数量
+*/
 func (s *SequenceService) Next(ctx context.Context, msg *SequenceMsg) (*SequenceMsg, error) {
-	next := s.seq.Next(msg.Name)
-	logger.Info("生成序列值:", next)
-	return &SequenceMsg{Next: next}, nil
+	count := msg.Count
+	if count == 0 {
+		count = 1
+	}
+	next, err := s.seq.Reserve(msg.Name, count)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("生成序列值:", next, "数量:", count)
+	return &SequenceMsg{Next: next, Count: count}, nil
 
 }
 
@@ -70,6 +82,26 @@ func (s *SequenceProxy) Next(name string) uint64 {
 	return result.Next
 }
 
+/*
+预留count个连续的序列值,返回第一个序列值。
+应答中的数量与请求不一致时(例如不支持批量预留的旧版本服务)返回错误
+*/
+func (s *SequenceProxy) Reserve(name string, count uint64) (uint64, error) {
+	if count == 0 {
+		return 0, errors.New("预留的序列数量必须大于0")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	result, err := s.client.Next(ctx, &SequenceMsg{Name: name, Count: count})
+	if err != nil {
+		return 0, err
+	}
+	if result.Count != count || result.Next == 0 {
+		return 0, errors.New(fmt.Sprintf("序列服务没有确认预留的序列值[%s],请求数量:%d,确认数量:%d", name, count, result.Count))
+	}
+	return result.Next, nil
+}
+
 func (s *SequenceProxy) SetStart(name string, val uint64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
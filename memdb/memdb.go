@@ -239,7 +239,8 @@ func (db *DB) Load(rd io.Reader) error {
 		// cannot load into databases that persist to disk
 		return ErrPersistenceActive
 	}
-	return db.readLoad(rd, time.Now())
+	_, err := db.readLoad(rd, time.Now())
+	return err
 }
 
 // index represents a b-tree or r-tree index and also acts as the
@@ -699,41 +700,53 @@ var errValidEOF = errors.New("valid eof")
 // readLoad reads from the reader and loads commands into the database.
 // modTime is the modified time of the reader, should be no greater than
 // the current time.Now().
-func (db *DB) readLoad(rd io.Reader, modTime time.Time) error {
+// It returns the number of bytes of the last complete command read, so that
+// a file which ended mid-command can be truncated to a valid state.
+func (db *DB) readLoad(rd io.Reader, modTime time.Time) (size int64, err error) {
+	var totalSize int64
 	data := make([]byte, 4096)
 	parts := make([]string, 0, 8)
 	r := bufio.NewReader(rd)
+	defer func() {
+		// an eof in the middle of a command means that the last write was
+		// interrupted, which is reported as an unexpected eof.
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
 	for {
 		// read a single command.
 		// first we should read the number of parts that the of the command
+		cmdByteSize := int64(0)
 		line, err := r.ReadBytes('\n')
 		if err != nil {
 			if len(line) > 0 {
 				// got an eof but also data. this should be an unexpected eof.
-				return io.ErrUnexpectedEOF
+				return totalSize, io.ErrUnexpectedEOF
 			}
 			if err == io.EOF {
 				break
 			}
-			return err
+			return totalSize, err
 		}
 		if line[0] != '*' {
-			return ErrInvalid
+			return totalSize, ErrInvalid
 		}
+		cmdByteSize += int64(len(line))
 		// convert the string number to and int
 		var n int
 		if len(line) == 4 && line[len(line)-2] == '\r' {
 			if line[1] < '0' || line[1] > '9' {
-				return ErrInvalid
+				return totalSize, ErrInvalid
 			}
 			n = int(line[1] - '0')
 		} else {
 			if len(line) < 5 || line[len(line)-2] != '\r' {
-				return ErrInvalid
+				return totalSize, ErrInvalid
 			}
 			for i := 1; i < len(line)-2; i++ {
 				if line[i] < '0' || line[i] > '9' {
-					return ErrInvalid
+					return totalSize, ErrInvalid
 				}
 				n = n*10 + int(line[i]-'0')
 			}
@@ -744,25 +757,26 @@ func (db *DB) readLoad(rd io.Reader, modTime time.Time) error {
 			// read the number of bytes of the part.
 			line, err := r.ReadBytes('\n')
 			if err != nil {
-				return err
+				return totalSize, err
 			}
+			cmdByteSize += int64(len(line))
 			if line[0] != '$' {
-				return ErrInvalid
+				return totalSize, ErrInvalid
 			}
 			// convert the string number to and int
 			var n int
 			if len(line) == 4 && line[len(line)-2] == '\r' {
 				if line[1] < '0' || line[1] > '9' {
-					return ErrInvalid
+					return totalSize, ErrInvalid
 				}
 				n = int(line[1] - '0')
 			} else {
 				if len(line) < 5 || line[len(line)-2] != '\r' {
-					return ErrInvalid
+					return totalSize, ErrInvalid
 				}
 				for i := 1; i < len(line)-2; i++ {
 					if line[i] < '0' || line[i] > '9' {
-						return ErrInvalid
+						return totalSize, ErrInvalid
 					}
 					n = n*10 + int(line[i]-'0')
 				}
@@ -776,15 +790,17 @@ func (db *DB) readLoad(rd io.Reader, modTime time.Time) error {
 				data = make([]byte, dataln)
 			}
 			if _, err = io.ReadFull(r, data[:n+2]); err != nil {
-				return err
+				return totalSize, err
 			}
 			if data[n] != '\r' || data[n+1] != '\n' {
-				return ErrInvalid
+				return totalSize, ErrInvalid
 			}
+			cmdByteSize += int64(n + 2)
 			// copy string
 			parts = append(parts, string(data[:n]))
 		}
 		// finished reading the command
+		totalSize += cmdByteSize
 
 		if len(parts) == 0 {
 			continue
@@ -794,15 +810,15 @@ func (db *DB) readLoad(rd io.Reader, modTime time.Time) error {
 			(parts[0][2] == 't' || parts[0][2] == 'T') {
 			// SET
 			if len(parts) < 3 || len(parts) == 4 || len(parts) > 5 {
-				return ErrInvalid
+				return totalSize, ErrInvalid
 			}
 			if len(parts) == 5 {
 				if strings.ToLower(parts[3]) != "ex" {
-					return ErrInvalid
+					return totalSize, ErrInvalid
 				}
 				ex, err := strconv.ParseInt(parts[4], 10, 64)
 				if err != nil {
-					return err
+					return totalSize, err
 				}
 				now := time.Now()
 				dur := (time.Duration(ex) * time.Second) - now.Sub(modTime)
@@ -824,7 +840,7 @@ func (db *DB) readLoad(rd io.Reader, modTime time.Time) error {
 			(parts[0][2] == 'l' || parts[0][2] == 'L') {
 			// DEL
 			if len(parts) != 2 {
-				return ErrInvalid
+				return totalSize, ErrInvalid
 			}
 			db.deleteFromDatabase(&dbItem{key: parts[1]})
 		} else if (parts[0][0] == 'f' || parts[0][1] == 'F') &&
@@ -833,10 +849,10 @@ func (db *DB) readLoad(rd io.Reader, modTime time.Time) error {
 			db.exps = btree.New(btreeDegrees, &exctx{db})
 			db.idxs = make(map[string]*index)
 		} else {
-			return ErrInvalid
+			return totalSize, ErrInvalid
 		}
 	}
-	return nil
+	return totalSize, nil
 }
 
 // load reads entries from the append only database file and fills the database.
@@ -849,10 +865,18 @@ func (db *DB) load() error {
 	if err != nil {
 		return err
 	}
-	if err := db.readLoad(db.file, fi.ModTime()); err != nil {
-		return err
+	n, err := db.readLoad(db.file, fi.ModTime())
+	if err != nil {
+		if err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// The file ended mid-command, which happens when a write was
+		// interrupted. Truncate it to the end of the last complete command.
+		if err := db.file.Truncate(n); err != nil {
+			return err
+		}
 	}
-	pos, err := db.file.Seek(0, 2)
+	pos, err := db.file.Seek(n, 0)
 	if err != nil {
 		return err
 	}
@@ -32,6 +32,7 @@ type Message struct {
 	ResultCode           uint32   `protobuf:"varint,9,opt,name=ResultCode,proto3" json:"ResultCode,omitempty"`
 	Key                  string   `protobuf:"bytes,10,opt,name=Key,proto3" json:"Key,omitempty"`
 	DBName               string   `protobuf:"bytes,11,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TTL                  int64    `protobuf:"varint,12,opt,name=TTL,proto3" json:"TTL,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -139,6 +140,13 @@ func (m *Message) GetDBName() string {
 	return ""
 }
 
+func (m *Message) GetTTL() int64 {
+	if m != nil {
+		return m.TTL
+	}
+	return 0
+}
+
 type BatchMessage struct {
 	Term                 uint64     `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
 	Messages             []*Message `protobuf:"bytes,2,rep,name=Messages,proto3" json:"Messages,omitempty"`
@@ -194,21 +202,22 @@ func init() {
 func init() { proto.RegisterFile("message.proto", fileDescriptor_33c57e4bae7b9afd) }
 
 var fileDescriptor_33c57e4bae7b9afd = []byte{
-	// 253 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xc1, 0x4a, 0xf3, 0x40,
-	0x10, 0xc7, 0xd9, 0x24, 0x4d, 0xda, 0x69, 0xfb, 0x51, 0x86, 0x0f, 0x99, 0x93, 0x2c, 0x3d, 0xed,
-	0x41, 0x72, 0xd0, 0x37, 0x68, 0x8b, 0x20, 0xa2, 0xc8, 0x92, 0x17, 0x58, 0xed, 0xa0, 0xa0, 0xc9,
-	0x96, 0x64, 0x8b, 0xed, 0x73, 0xfb, 0x02, 0xb2, 0xb3, 0x41, 0x7a, 0xfb, 0xcd, 0x6f, 0x76, 0x66,
-	0xf9, 0x0f, 0x2c, 0x5b, 0x1e, 0x06, 0xf7, 0xce, 0xf5, 0xa1, 0xf7, 0xc1, 0x63, 0xd5, 0x71, 0xf8,
-	0xf6, 0xfd, 0xe7, 0xfa, 0x47, 0x41, 0xf5, 0x94, 0x5a, 0x88, 0x50, 0x34, 0xe7, 0x03, 0x93, 0xd2,
-	0xca, 0x2c, 0xad, 0x30, 0xfe, 0x83, 0xac, 0xf1, 0x94, 0x69, 0x65, 0x0a, 0x9b, 0x35, 0x3e, 0xbe,
-	0xb9, 0xef, 0x7d, 0x4b, 0xb9, 0x18, 0x61, 0x99, 0xe3, 0xbe, 0xa5, 0x22, 0xb9, 0xc8, 0xf8, 0x1f,
-	0x26, 0x0f, 0xdd, 0x9e, 0x4f, 0x34, 0x11, 0x99, 0x8a, 0x68, 0xb7, 0xfe, 0xd8, 0x05, 0x2a, 0xe5,
-	0x8b, 0x54, 0xc4, 0xf9, 0x9d, 0x0b, 0x8e, 0x2a, 0xad, 0xcc, 0xc2, 0x0a, 0xa7, 0x9d, 0xa7, 0x40,
-	0x53, 0xad, 0xcc, 0xcc, 0x0a, 0xe3, 0x35, 0x80, 0xe5, 0xe1, 0xf8, 0x15, 0xb6, 0x7e, 0xcf, 0x34,
-	0x93, 0x15, 0x17, 0x06, 0x57, 0x90, 0x3f, 0xf2, 0x99, 0x40, 0x46, 0x22, 0xe2, 0x15, 0x94, 0xbb,
-	0xcd, 0xb3, 0x6b, 0x99, 0xe6, 0x22, 0xc7, 0x6a, 0xfd, 0x02, 0x8b, 0x8d, 0x0b, 0x6f, 0x1f, 0x17,
-	0xc9, 0x43, 0x4c, 0xa0, 0x52, 0x82, 0xc8, 0x78, 0x03, 0xd3, 0xb1, 0x3d, 0x50, 0xa6, 0x73, 0x33,
-	0xbf, 0x5d, 0xd5, 0xe3, 0xd5, 0xea, 0xb1, 0x61, 0xff, 0x5e, 0xbc, 0x96, 0x72, 0xd7, 0xbb, 0xdf,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x25, 0x2b, 0x04, 0x68, 0x01, 0x00, 0x00,
+	// 259 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xcf, 0x4a, 0x03, 0x31,
+	0x10, 0x87, 0xc9, 0xee, 0x76, 0xb7, 0x9d, 0x6e, 0xa5, 0x04, 0x91, 0x39, 0x49, 0xe8, 0x29, 0x07,
+	0xd9, 0x83, 0xbe, 0x41, 0x5b, 0x04, 0xf1, 0x0f, 0x12, 0xf2, 0x02, 0xd1, 0x0e, 0x0a, 0xba, 0x9b,
+	0xb2, 0x9b, 0x62, 0xfb, 0x00, 0xbe, 0xb7, 0x64, 0xb2, 0x48, 0x6f, 0xdf, 0x7c, 0x33, 0x93, 0x30,
+	0x3f, 0x58, 0xb4, 0x34, 0x0c, 0xee, 0x83, 0x9a, 0x7d, 0xef, 0x83, 0x97, 0x55, 0x47, 0xe1, 0xc7,
+	0xf7, 0x5f, 0xab, 0xdf, 0x0c, 0xaa, 0xe7, 0xd4, 0x92, 0x12, 0x0a, 0x7b, 0xda, 0x13, 0x0a, 0x25,
+	0xf4, 0xc2, 0x30, 0xcb, 0x0b, 0xc8, 0xac, 0xc7, 0x4c, 0x09, 0x5d, 0x98, 0xcc, 0xfa, 0x38, 0x73,
+	0xdf, 0xfb, 0x16, 0x73, 0x36, 0xcc, 0xbc, 0x47, 0x7d, 0x8b, 0x45, 0x72, 0x91, 0xe5, 0x25, 0x4c,
+	0x1e, 0xba, 0x1d, 0x1d, 0x71, 0xc2, 0x32, 0x15, 0xd1, 0x6e, 0xfc, 0xa1, 0x0b, 0x58, 0xf2, 0x17,
+	0xa9, 0x88, 0xfb, 0x5b, 0x17, 0x1c, 0x56, 0x4a, 0xe8, 0xda, 0x30, 0xa7, 0x37, 0x8f, 0x01, 0xa7,
+	0x4a, 0xe8, 0x99, 0x61, 0x96, 0xd7, 0x00, 0x86, 0x86, 0xc3, 0x77, 0xd8, 0xf8, 0x1d, 0xe1, 0x8c,
+	0x9f, 0x38, 0x33, 0x72, 0x09, 0xf9, 0x23, 0x9d, 0x10, 0x78, 0x25, 0xa2, 0xbc, 0x82, 0x72, 0xbb,
+	0x7e, 0x71, 0x2d, 0xe1, 0x9c, 0xe5, 0x58, 0xc5, 0x49, 0x6b, 0x9f, 0xb0, 0x56, 0x42, 0xe7, 0x26,
+	0xe2, 0xea, 0x15, 0xea, 0xb5, 0x0b, 0xef, 0x9f, 0x67, 0x59, 0x84, 0x78, 0x93, 0x48, 0x37, 0x45,
+	0x96, 0x37, 0x30, 0x1d, 0xdb, 0x03, 0x66, 0x2a, 0xd7, 0xf3, 0xdb, 0x65, 0x33, 0xe6, 0xd8, 0x8c,
+	0x0d, 0xf3, 0x3f, 0xf1, 0x56, 0x72, 0xd2, 0x77, 0x7f, 0x03, 0x00, 0x93, 0x14, 0x98, 0x0a, 0x7a,
+	0x01, 0x00, 0x00,
 }
@@ -2,8 +2,10 @@ package parse
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"github.com/xp/shorttext-db/config"
-	"github.com/yanyiwu/gojieba"
+	"os"
 	"regexp"
 	"strings"
 	"unicode/utf8"
@@ -22,228 +24,94 @@ const (
 const defaultMaxLen = 4
 
 /*
-中文分词
+分词器名字,通过Config.Cutter选择
+*/
+const (
+	CUTTER_NAME_JIEBA = "jieba"
+	CUTTER_NAME_NGRAM = "ngram"
+)
+
+/*
+中文分词，用户自定义词和停用词由所有分词器共用的UserDictionary在分词之后处理
 */
 type Cutter interface {
 	HMMCut(text string) []string
-	AddWord(word string)
 	CutForChinese(text string, maxLen int) []string
 	CutForASCII(text string, maxLen int) []string
 	CutForHybrid(text string, maxLen int) []string
 }
 
-func NewCutter(config *config.Config) *JiebaCutter {
-	instance := &JiebaCutter{}
-	instance.initialize(config)
-	return instance
-}
-
-type JiebaCutter struct {
-	impl             *gojieba.Jieba
-	regReplaced      *regexp.Regexp
-	regScopeReplaced *regexp.Regexp
-	regASCII         *regexp.Regexp
-	regSeparator     *regexp.Regexp
-	regNum           *regexp.Regexp
-}
-
-func (j *JiebaCutter) initialize(config *config.Config) {
+type CutterFactory func(config *config.Config) Cutter
 
-	gojieba.DICT_PATH = config.DictPath
-	gojieba.HMM_PATH = config.HmmPath
-	gojieba.USER_DICT_PATH = config.UserDictPath
-	gojieba.IDF_PATH = config.IdfPath
-	gojieba.STOP_WORDS_PATH = config.StopWordsPath
-
-	//删除非中文、字符、数字（除去点和百分号)
-	//\.\%°
-	j.regReplaced = regexp.MustCompile(`[^\p{L}\p{N}\p{Han}]`)
-	//匹配非中文字符
-	j.regASCII = regexp.MustCompile(`^[^\p{Han}]+$`)
-
-	//删除指定字符
-	j.regScopeReplaced = regexp.MustCompile(`[φ,δ,Φ]`)
-
-	j.regSeparator = regexp.MustCompile("(\\+|\\-|\\*|\\/|\\=|\\<|\\>|≥|≤|×)")
-
-	//数字
-	j.regNum = regexp.MustCompile(`^\d+(\.\d+)?[%]?$`)
-	j.impl = gojieba.NewJieba()
-
-}
-func (j *JiebaCutter) HMMCut(text string) []string {
-	result := j.impl.Cut(text, true)
-	return result
-}
+var cutterFactories = make(map[string]CutterFactory)
 
 /*
-先对混合字符进行分词，再判断词是否为非中文，如果为非中文，就与下一个词进行合并。
+注册分词器，在各分词器实现的init函数中调用
 */
-func (j *JiebaCutter) CutForHybrid(text string, maxLen int) []string {
-	if maxLen == 0 {
-		maxLen = defaultMaxLen
-	}
-
-	cuttText := j.clean(strings.ToUpper(text))
-
-	//长度小于４个字符，直接返回
-	if utf8.RuneCountInString(cuttText) <= maxLen {
-		return []string{cuttText}
-	}
-
-	newItems := make([]string, 0, 8)
-	result := j.impl.Cut(cuttText, true)
-	l := len(result)
-
-	if l < 2 {
-		newItems = append(newItems, j.truncateStr(cuttText, maxLen))
-		return newItems
-	}
-
-	var temp string = result[0]
-	for i := 1; i < l; i++ {
-		if j.isASCII(temp) {
-			temp = temp + result[i]
-		} else {
-			newItems = append(newItems, j.truncateStr(temp, maxLen))
-			temp = result[i]
-		}
-	}
-	//由于最后一个词合并后，不会append到数组，所以再进行一次append
-	newItems = append(newItems, j.truncateStr(temp, maxLen))
-	return newItems
-}
-
-func (j *JiebaCutter) CutForChinese(text string, maxLen int) []string {
-	if maxLen == 0 {
-		maxLen = defaultMaxLen
-	}
-	//长度小于４个字符，直接返回
-	if utf8.RuneCountInString(text) <= maxLen {
-		return []string{text}
-	}
-	result := j.impl.CutForSearch(text, true)
-
-	return result
+func RegisterCutter(name string, factory CutterFactory) {
+	cutterFactories[name] = factory
 }
 
-func (j *JiebaCutter) CutForASCII(text string, maxLen int) []string {
-
-	if maxLen == 0 {
-		maxLen = defaultMaxLen
-	}
-
-	upperText := strings.ToUpper(text)
-	ascText := j.cleanForASCII(upperText)
-	//长度小于6个字符，直接返回
-	if utf8.RuneCountInString(ascText) <= maxLen {
-		return []string{ascText}
-	}
-	result := make([]string, 0, 4)
-
-	//cleanedText :=j.clean(ascText)
-	//result = append(result,cleanedText)
-
-	words := j.regSeparator.Split(ascText, -1)
-	if len(words) == 1 {
-		return result
-	}
-	format := j.checkFormat(words)
-	switch format {
-	case CUTTER_NUMBER:
-		return result
-	case CUTTER_SINGLE_CHAR:
-		newWords := j.combine(words)
-		for _, w := range newWords {
-			result = append(result, j.truncateStr(w, maxLen))
-		}
-	default:
-		for _, w := range words {
-			result = append(result, j.truncateStr(w, maxLen))
+/*
+根据配置的分词器名字创建分词器，未配置时优先使用jieba分词,
+jieba不可用(未启用cgo)或没有配置(缺少词典文件)时使用ngram分词。配置为jieba但缺少词典文件时返回错误
+*/
+func CreateCutter(config *config.Config) (Cutter, error) {
+	if config == nil {
+		return NewNGramCutter(), nil
+	}
+	name := config.Cutter
+	if len(name) == 0 {
+		name = CUTTER_NAME_NGRAM
+		if _, ok := cutterFactories[CUTTER_NAME_JIEBA]; ok {
+			if err := checkJiebaDicts(config); err == nil {
+				name = CUTTER_NAME_JIEBA
+			} else {
+				logger.Infof("jieba分词不可用,使用ngram分词:%s\n", err.Error())
+			}
 		}
 	}
-	return result
-}
-
-func (j *JiebaCutter) checkFormat(words []string) int {
-	bNum := j.isNum(words)
-	if bNum {
-		return CUTTER_NUMBER
+	factory, ok := cutterFactories[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("分词器[%s]不存在", name))
 	}
-	count := j.countSingleChar(words)
-	if float32(count)/float32(len(words)) >= 0.7 {
-		return CUTTER_SINGLE_CHAR
-	}
-	return CUTTER_DEFAULT
-}
-
-func (j *JiebaCutter) countSingleChar(words []string) int {
-	count := 0
-	for _, w := range words {
-		if utf8.RuneCountInString(w) == 1 {
-			count = count + 1
+	//jieba加载不存在的词典文件时进程直接退出,创建前先检查
+	if name == CUTTER_NAME_JIEBA {
+		if err := checkJiebaDicts(config); err != nil {
+			return nil, err
 		}
 	}
-	return count
+	return factory(config), nil
 }
-func (j *JiebaCutter) isNum(words []string) bool {
 
-	for _, w := range words {
-		if !j.regNum.MatchString(w) {
-			return false
+/*
+检查jieba分词需要的词典文件是否存在,UserDictPath为空时不检查用户词典
+*/
+func checkJiebaDicts(config *config.Config) error {
+	dicts := []struct {
+		name string
+		path string
+	}{
+		{"DictPath", config.DictPath},
+		{"HmmPath", config.HmmPath},
+		{"IdfPath", config.IdfPath},
+		{"StopWordsPath", config.StopWordsPath},
+		{"UserDictPath", config.UserDictPath},
+	}
+	for _, dict := range dicts {
+		if len(dict.path) == 0 {
+			if dict.name == "UserDictPath" {
+				continue
+			}
+			return errors.New(fmt.Sprintf("jieba词典[%s]未配置", dict.name))
 		}
-	}
-	return true
-}
-func (j *JiebaCutter) combine(words []string) []string {
-	var max string
-	max = ""
-	var index int
-	var l int
-	for i, w := range words {
-		l1 := utf8.RuneCountInString(w)
-		l2 := utf8.RuneCountInString(max)
-		if l1 > l2 {
-			max = w
-			index = i
-			l = l1
+		if _, err := os.Stat(dict.path); err != nil {
+			return errors.New(fmt.Sprintf("jieba词典[%s]不存在:%s", dict.name, dict.path))
 		}
 	}
-	if l != 1 {
-		words[index] = ""
-	}
-	newWords := combineStr(words)
-	newWords = append(newWords, max)
-	return newWords
-}
-
-func (j *JiebaCutter) AddWord(word string) {
-	//	j.impl.AddWord(word)
-}
-
-func (j *JiebaCutter) isASCII(text string) bool {
-	if j.regASCII.MatchString(text) && utf8.RuneCountInString(text) < 4 {
-		return true
-	}
-	return false
-}
-
-func (j *JiebaCutter) clean(text string) string {
-	result := j.regReplaced.ReplaceAllString(text, "")
-	return result
+	return nil
 }
 
-func (j *JiebaCutter) cleanForASCII(text string) string {
-	result := j.regScopeReplaced.ReplaceAllString(text, "")
-	return result
-}
-
-func (j *JiebaCutter) truncateStr(text string, maxLen int) string {
-	if len(text) > maxLen {
-		return text[:maxLen]
-	}
-	return text
-}
 func combineStr(strList []string) []string {
 	newList := make([]string, 0)
 	strLen := len(strList)
@@ -0,0 +1,45 @@
+// +build cgo
+
+package parse
+
+import (
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"strings"
+	"testing"
+)
+
+func TestCutForChinese(t *testing.T) {
+	config.LoadSettings("/opt/test/config/test_case1.txt", nil)
+	cutter := NewCutter(config.GetConfig())
+	strs := []string{`O型圈`, `90°弯管广州`, `ABCD弯管`, `EH供油装置HTGT300G`}
+	for i := 0; i < len(strs); i++ {
+		text := cutter.CutForHybrid(strs[i], 4)
+		fmt.Printf("%d -- %s=>%s\n ", i+1, text, strings.Join(text, "|"))
+	}
+
+}
+
+func Test_isASCII(t *testing.T) {
+	config.LoadSettings("/opt/test/config/test_case1.txt", nil)
+	cutter := NewCutter(config.GetConfig())
+	r := cutter.isASCII(`弯管广州90°`)
+	fmt.Println(r)
+}
+
+func Test_CutterReg(t *testing.T) {
+	config.LoadSettings("/opt/test/config/test_case1.txt", nil)
+	p := NewCutter(config.GetConfig())
+	s1 := `φAB`
+	fmt.Println(p.regReplaced.ReplaceAllString(s1, ""))
+}
+
+func TestJiebaCutter_CutForASCII(t *testing.T) {
+	config.LoadSettings("/opt/test/config/test_case1.txt", nil)
+	cutter := NewCutter(config.GetConfig())
+	strs := []string{`520451-A1-02003`, `φAB`}
+	for i := 0; i < len(strs); i++ {
+		text := cutter.CutForASCII(strs[i], 4)
+		fmt.Printf("%d -- %s=>%s\n ", i+1, strs[i], strings.Join(text, "|"))
+	}
+}
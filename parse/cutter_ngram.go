@@ -0,0 +1,160 @@
+package parse
+
+import (
+	"github.com/xp/shorttext-db/config"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterCutter(CUTTER_NAME_NGRAM, func(config *config.Config) Cutter {
+		return NewNGramCutter()
+	})
+}
+
+/*
+纯Go实现的分词器，不依赖cgo和词典文件
+中文按二元组(bigram)切分，英文和数字按分隔符以及字母、数字的边界切分
+*/
+type NGramCutter struct {
+	regReplaced      *regexp.Regexp
+	regScopeReplaced *regexp.Regexp
+	regSeparator     *regexp.Regexp
+}
+
+func NewNGramCutter() *NGramCutter {
+	n := &NGramCutter{}
+	//删除非中文、字符、数字
+	n.regReplaced = regexp.MustCompile(`[^\p{L}\p{N}\p{Han}]`)
+	//删除指定字符
+	n.regScopeReplaced = regexp.MustCompile(`[φ,δ,Φ]`)
+	//非字母、数字均作为分隔符
+	n.regSeparator = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	return n
+}
+
+/*
+按中文和非中文切分成片段，中文片段再切分成二元组
+*/
+func (n *NGramCutter) HMMCut(text string) []string {
+	result := make([]string, 0, 8)
+	for _, seg := range n.splitHan(text) {
+		if isHanText(seg) {
+			result = append(result, n.bigram(seg)...)
+		} else {
+			result = append(result, n.regSeparator.Split(seg, -1)...)
+		}
+	}
+	return result
+}
+
+func (n *NGramCutter) CutForChinese(text string, maxLen int) []string {
+	if maxLen == 0 {
+		maxLen = defaultMaxLen
+	}
+	//长度小于４个字符，直接返回
+	if utf8.RuneCountInString(text) <= maxLen {
+		return []string{text}
+	}
+	return n.bigram(text)
+}
+
+func (n *NGramCutter) CutForASCII(text string, maxLen int) []string {
+	if maxLen == 0 {
+		maxLen = defaultMaxLen
+	}
+	ascText := n.regScopeReplaced.ReplaceAllString(strings.ToUpper(text), "")
+	if utf8.RuneCountInString(ascText) <= maxLen {
+		return []string{ascText}
+	}
+	result := make([]string, 0, 4)
+	for _, w := range n.regSeparator.Split(ascText, -1) {
+		result = append(result, w)
+		parts := splitLetterNumber(w)
+		if len(parts) > 1 {
+			result = append(result, parts...)
+		}
+	}
+	return result
+}
+
+/*
+先把混合字符切分成中文和非中文片段，再分别进行切分
+*/
+func (n *NGramCutter) CutForHybrid(text string, maxLen int) []string {
+	if maxLen == 0 {
+		maxLen = defaultMaxLen
+	}
+	cuttText := n.regReplaced.ReplaceAllString(strings.ToUpper(text), "")
+	//长度小于４个字符，直接返回
+	if utf8.RuneCountInString(cuttText) <= maxLen {
+		return []string{cuttText}
+	}
+	result := make([]string, 0, 8)
+	for _, seg := range n.splitHan(cuttText) {
+		if isHanText(seg) {
+			result = append(result, n.CutForChinese(seg, maxLen)...)
+		} else {
+			result = append(result, n.CutForASCII(seg, maxLen)...)
+		}
+	}
+	return result
+}
+
+//相邻两个字组成一个词
+func (n *NGramCutter) bigram(text string) []string {
+	runes := []rune(text)
+	if len(runes) < 2 {
+		return []string{text}
+	}
+	result := make([]string, 0, len(runes)-1)
+	for i := 0; i < len(runes)-1; i++ {
+		result = append(result, string(runes[i:i+2]))
+	}
+	return result
+}
+
+//按中文和非中文的边界切分
+func (n *NGramCutter) splitHan(text string) []string {
+	result := make([]string, 0, 4)
+	var start int
+	var last bool
+	for i, r := range text {
+		han := unicode.Is(unicode.Han, r)
+		if i > 0 && han != last {
+			result = append(result, text[start:i])
+			start = i
+		}
+		last = han
+	}
+	if start < len(text) {
+		result = append(result, text[start:])
+	}
+	return result
+}
+
+func isHanText(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return unicode.Is(unicode.Han, r)
+}
+
+//按字母和数字的边界切分，如"HTGT300G"切分为"HTGT"、"300"、"G"
+func splitLetterNumber(text string) []string {
+	result := make([]string, 0, 2)
+	var start int
+	var last bool
+	for i, r := range text {
+		digit := unicode.IsDigit(r)
+		if i > 0 && digit != last {
+			result = append(result, text[start:i])
+			start = i
+		}
+		last = digit
+	}
+	if start < len(text) {
+		result = append(result, text[start:])
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package parse
+
+import (
+	"github.com/xp/shorttext-db/config"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNGramCutter_CutForChinese(t *testing.T) {
+	cutter := NewNGramCutter()
+	words := cutter.CutForChinese(`汽水引出管`, 4)
+	if strings.Join(words, "|") != `汽水|水引|引出|出管` {
+		t.Errorf("中文二元组切分错误:%v", words)
+	}
+	words = cutter.CutForChinese(`弯管`, 4)
+	if len(words) != 1 || words[0] != `弯管` {
+		t.Errorf("短文本应直接返回:%v", words)
+	}
+}
+
+func TestNGramCutter_CutForASCII(t *testing.T) {
+	cutter := NewNGramCutter()
+	words := cutter.CutForASCII(`520451-a1-02003`, 4)
+	if strings.Join(words, "|") != `520451|A1|A|1|02003` {
+		t.Errorf("英文数字切分错误:%v", words)
+	}
+	words = cutter.CutForASCII(`φAB`, 4)
+	if len(words) != 1 || words[0] != `AB` {
+		t.Errorf("未删除指定字符:%v", words)
+	}
+}
+
+func TestNGramCutter_CutForHybrid(t *testing.T) {
+	cutter := NewNGramCutter()
+	words := cutter.CutForHybrid(`EH供油装置HTGT300G`, 4)
+	expected := map[string]bool{"EH": true, "供油装置": true, "HTGT300G": true, "HTGT": true, "300": true}
+	for _, w := range words {
+		delete(expected, w)
+	}
+	if len(expected) > 0 {
+		t.Errorf("混合字符切分缺少关键字:%v,结果:%v", expected, words)
+	}
+}
+
+func TestCreateCutter(t *testing.T) {
+	cfg := &config.Config{Cutter: CUTTER_NAME_NGRAM}
+	cutter, err := CreateCutter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cutter.(*NGramCutter); !ok {
+		t.Errorf("分词器类型错误:%T", cutter)
+	}
+	cfg.Cutter = "unknown"
+	if _, err = CreateCutter(cfg); err == nil {
+		t.Error("不存在的分词器应返回错误")
+	}
+}
+
+func TestCreateCutter_NilConfig(t *testing.T) {
+	cutter, err := CreateCutter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cutter.(*NGramCutter); !ok {
+		t.Errorf("没有配置时应使用ngram分词:%T", cutter)
+	}
+}
+
+func TestCreateCutter_MissingJiebaDicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DictPath: dir + "/jieba.dict.utf8", HmmPath: dir + "/hmm_model.utf8"}
+	if err = checkJiebaDicts(cfg); err == nil {
+		t.Error("词典文件不存在时应返回错误")
+	}
+	//未指定分词器且缺少jieba词典时使用ngram分词
+	cutter, err := CreateCutter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cutter.(*NGramCutter); !ok {
+		t.Errorf("缺少jieba词典时应使用ngram分词:%T", cutter)
+	}
+	cfg.Cutter = CUTTER_NAME_JIEBA
+	if _, err = CreateCutter(cfg); err == nil {
+		t.Error("配置jieba分词但缺少词典时应返回错误")
+	}
+
+	cfg = &config.Config{}
+	for _, name := range []string{"jieba.dict.utf8", "hmm_model.utf8", "idf.utf8", "stop_words.utf8"} {
+		if err = ioutil.WriteFile(dir+"/"+name, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.DictPath = dir + "/jieba.dict.utf8"
+	cfg.HmmPath = dir + "/hmm_model.utf8"
+	cfg.IdfPath = dir + "/idf.utf8"
+	cfg.StopWordsPath = dir + "/stop_words.utf8"
+	if err = checkJiebaDicts(cfg); err != nil {
+		t.Errorf("词典文件均存在时不应返回错误:%v", err)
+	}
+	cfg.UserDictPath = dir + "/user.dict.utf8"
+	if err = checkJiebaDicts(cfg); err == nil {
+		t.Error("配置的用户词典不存在时应返回错误")
+	}
+}
@@ -0,0 +1,245 @@
+package parse
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+var userDict = NewUserDictionary()
+
+/*
+获得进程内共享的运行时用户词典
+*/
+func GetUserDictionary() *UserDictionary {
+	return userDict
+}
+
+/*
+运行时用户词典，包括自定义词和停用词，所有分词器共用
+文本中包含自定义词时，自定义词作为一个完整的关键字；停用词不作为关键字
+*/
+type UserDictionary struct {
+	words     map[string]bool
+	stopWords map[string]bool
+	lock      sync.RWMutex
+}
+
+//词典的持久化格式
+type userDictFile struct {
+	Words     []string `json:"Words"`
+	StopWords []string `json:"StopWords"`
+}
+
+func NewUserDictionary() *UserDictionary {
+	u := &UserDictionary{}
+	u.words = make(map[string]bool)
+	u.stopWords = make(map[string]bool)
+	return u
+}
+
+/*
+增加自定义词，返回实际增加的词
+*/
+func (u *UserDictionary) AddWords(words ...string) []string {
+	return u.update(u.words, words, true)
+}
+
+/*
+删除自定义词，返回实际删除的词
+*/
+func (u *UserDictionary) RemoveWords(words ...string) []string {
+	return u.update(u.words, words, false)
+}
+
+/*
+增加停用词，返回实际增加的词
+*/
+func (u *UserDictionary) AddStopWords(words ...string) []string {
+	return u.update(u.stopWords, words, true)
+}
+
+/*
+删除停用词，返回实际删除的词
+*/
+func (u *UserDictionary) RemoveStopWords(words ...string) []string {
+	return u.update(u.stopWords, words, false)
+}
+
+func (u *UserDictionary) Words() []string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return sortedKeys(u.words)
+}
+
+func (u *UserDictionary) StopWords() []string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return sortedKeys(u.stopWords)
+}
+
+func (u *UserDictionary) IsStopWord(word string) bool {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return u.stopWords[normalizeWord(word)]
+}
+
+/*
+从文件加载词典，文件不存在时词典为空
+*/
+func (u *UserDictionary) Load(path string) error {
+	buff, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content := &userDictFile{}
+	err = json.Unmarshal(buff, content)
+	if err != nil {
+		return err
+	}
+	u.lock.Lock()
+	u.words = make(map[string]bool)
+	u.stopWords = make(map[string]bool)
+	u.lock.Unlock()
+	u.AddWords(content.Words...)
+	u.AddStopWords(content.StopWords...)
+	return nil
+}
+
+/*
+保存词典，先写临时文件再重命名，避免保存过程中断导致文件损坏
+*/
+func (u *UserDictionary) Save(path string) error {
+	content := &userDictFile{Words: u.Words(), StopWords: u.StopWords()}
+	buff, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, buff, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+/*
+对分词结果应用词典：增加文本中包含的完整自定义词，删除停用词
+*/
+func (u *UserDictionary) apply(text string, words []string) []string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	if len(u.words) == 0 && len(u.stopWords) == 0 {
+		return words
+	}
+	upperText := strings.ToUpper(text)
+	result := make([]string, 0, len(words))
+	for _, w := range words {
+		if u.stopWords[strings.ToUpper(w)] {
+			continue
+		}
+		result = append(result, w)
+	}
+	for w := range u.words {
+		if u.stopWords[w] {
+			continue
+		}
+		if containsWord(upperText, w) {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+func (u *UserDictionary) update(set map[string]bool, words []string, add bool) []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	changed := make([]string, 0, len(words))
+	for _, w := range words {
+		w = normalizeWord(w)
+		if len(w) == 0 || set[w] == add {
+			continue
+		}
+		if add {
+			set[w] = true
+		} else {
+			delete(set, w)
+		}
+		changed = append(changed, w)
+	}
+	return changed
+}
+
+/*
+文本中包含完整的词时返回true。字母与字母、数字与数字相邻时属于同一个关键字，
+词的前后不能紧接同类字符，例如SS不匹配PRESSURE,但匹配SS304和管道SS；中文没有词边界，按子串匹配
+*/
+func containsWord(text string, word string) bool {
+	if len(word) == 0 {
+		return false
+	}
+	for start := 0; start < len(text); {
+		pos := strings.Index(text[start:], word)
+		if pos < 0 {
+			return false
+		}
+		pos = pos + start
+		if isWordBoundary(text, pos, pos+len(word)) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		start = pos + size
+	}
+	return false
+}
+
+func isWordBoundary(text string, start int, end int) bool {
+	if start > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(text[:start])
+		first, _ := utf8.DecodeRuneInString(text[start:end])
+		if sameTokenClass(prev, first) {
+			return false
+		}
+	}
+	if end < len(text) {
+		last, _ := utf8.DecodeLastRuneInString(text[start:end])
+		next, _ := utf8.DecodeRuneInString(text[end:])
+		if sameTokenClass(last, next) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTokenClass(a rune, b rune) bool {
+	if unicode.Is(unicode.Han, a) || unicode.Is(unicode.Han, b) {
+		return false
+	}
+	return (unicode.IsLetter(a) && unicode.IsLetter(b)) || (unicode.IsDigit(a) && unicode.IsDigit(b))
+}
+
+func normalizeWord(word string) string {
+	return strings.ToUpper(strings.TrimSpace(word))
+}
+
+func sortedKeys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for k := range set {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
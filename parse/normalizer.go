@@ -0,0 +1,227 @@
+package parse
+
+import (
+	"bufio"
+	"github.com/xp/shorttext-db/config"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+常用繁体字与简体字对照表，每项为"繁简"两个字符，可通过T2SDictPath配置文件补充
+*/
+var defaultT2S = []string{
+	"國国", "標标", "準准", "規规", "電电", "壓压", "變变", "溫温", "計计", "開开",
+	"關关", "號号", "產产", "廠厂", "裝装", "與与", "東东", "車车", "門门", "問问",
+	"長长", "鐵铁", "鋼钢", "鋁铝", "銅铜", "鋅锌", "錫锡", "線线", "纜缆", "閥阀",
+	"軸轴", "輪轮", "機机", "構构", "設设", "備备", "氣气", "過过", "濾滤", "縮缩",
+	"體体", "積积", "質质", "熱热", "處处", "環环", "節节", "點点", "數数", "據据",
+	"庫库", "權权", "頭头", "應应", "錄录", "為为", "們们", "來来", "個个", "時时",
+	"對对", "會会", "說说", "發发", "現现", "實实", "際际", "種种", "類类", "碼码",
+	"場场", "經经", "濟济", "業业", "務务", "員员", "級级", "組组", "織织", "單单",
+	"價价", "額额", "總总", "統统", "導导", "進进", "運运", "輸输", "連连", "續续",
+	"斷断", "繼继", "護护", "絲丝", "釘钉", "墊垫", "彈弹", "鏈链", "條条", "齒齿",
+	"檢检", "測测", "儀仪", "錶表", "顯显", "監监", "網网", "絡络", "視视", "頻频",
+	"聲声", "語语", "樣样", "試试", "驗验", "證证", "書书", "圖图", "紙纸", "報报",
+	"項项", "貨货", "幣币", "銀银", "帳账", "戶户", "離离", "動动", "態态", "靜静",
+	"燈灯", "爐炉", "鍋锅", "閘闸", "蓋盖", "層层", "殼壳", "屬属", "萬万", "億亿",
+	"兩两", "雙双", "區区", "縣县", "廣广", "蘇苏", "漢汉", "華华", "陽阳", "陰阴",
+	"風风", "雲云", "葉叶", "馬马", "魚鱼", "鳥鸟", "龍龙", "閉闭", "間间", "隊队",
+	"陸陆", "險险", "隨随", "難难", "雜杂", "顏颜", "題题", "飛飞", "餘余", "館馆",
+	"驅驱", "鬆松", "麵面", "黃黄", "齊齐", "擴扩", "擋挡", "擇择", "擊击", "撥拨",
+	"換换", "揚扬", "損损", "搖摇", "攜携", "攝摄", "壞坏", "塊块", "夾夹", "寶宝",
+	"寫写", "審审", "寬宽", "將将", "專专", "屆届", "島岛", "帶带", "幫帮", "幹干",
+	"廢废", "廳厅", "彎弯", "徑径", "從从", "復复", "後后", "徵征", "戰战", "擺摆",
+	"於于", "樓楼", "樂乐", "樞枢", "橋桥", "櫃柜", "歐欧", "歷历", "殘残", "決决",
+	"沒没", "滅灭", "滿满", "漲涨", "澤泽", "濕湿", "灣湾", "燒烧", "爭争", "牆墙",
+	"狀状", "獨独", "獲获", "畫画", "當当", "療疗", "盡尽", "盤盘", "眾众", "礎础",
+	"確确", "禮礼", "稅税", "穩稳", "競竞", "筆笔", "範范", "築筑", "簡简", "糧粮",
+	"紅红", "約约", "紀纪", "純纯", "紐纽", "細细", "終终", "結结", "給给", "絕绝",
+	"綠绿", "維维", "綜综", "緊紧", "緣缘", "編编", "練练", "縱纵", "績绩", "繩绳",
+	"繪绘", "聯联", "聽听", "腦脑", "腳脚", "膠胶", "臨临", "舉举", "艙舱", "藝艺",
+	"蘭兰", "蟲虫", "補补", "製制", "複复", "親亲", "覺觉", "觀观", "訂订", "訊讯",
+	"記记", "許许", "診诊", "詞词", "詢询", "詳详", "認认", "誤误", "調调", "談谈",
+	"請请", "論论", "諮咨", "講讲", "謝谢", "識识", "譯译", "議议", "讀读", "讓让",
+	"豐丰", "負负", "財财", "責责", "費费", "資资", "賣卖", "購购", "載载", "輔辅",
+	"輕轻", "較较", "轉转", "辦办", "農农", "這这", "遠远", "適适", "選选", "還还",
+	"邊边", "醫医", "釋释", "針针", "鈕钮", "鉛铅", "鉤钩", "銷销", "鋪铺", "錯错",
+	"鍵键", "鎖锁", "鏡镜", "鐘钟", "鑄铸", "鑽钻", "閃闪", "陣阵", "隱隐", "霧雾",
+	"靈灵", "頁页", "頂顶", "順顺", "預预", "領领", "顆颗", "願愿", "顧顾", "飲饮",
+	"養养", "驚惊", "鬥斗", "鮮鲜", "麥麦", "齡龄", "裡里", "灑洒", "蘋苹",
+}
+
+/*
+常用汉字的拼音表，每项为"拼音:汉字"，多音字取常用读音，可通过PinyinDictPath配置文件补充或覆盖
+*/
+var defaultPinyin = []string{
+	"a:阿", "ai:爱", "an:安按案", "ba:八把巴拔", "bai:白百摆", "ban:板半办版班般", "bang:帮棒", "bao:包保报宝薄",
+	"bei:北备被杯贝", "ben:本", "beng:泵", "bi:比必闭笔壁币", "bian:边变编便", "biao:标表", "bie:别", "bing:并丙冰",
+	"bo:波玻拨", "bu:不布部步补", "cai:材才采财菜", "can:参残", "cao:草槽操", "ce:测侧册", "ceng:层", "cha:查差插叉",
+	"chai:拆柴", "chan:产", "chang:长厂常场", "che:车", "chen:沉衬陈", "cheng:成程称城承乘", "chi:尺齿池持",
+	"chong:充冲", "chu:出处初除础储", "chuan:传船串穿", "chuang:窗床创", "chui:吹垂", "chun:纯春", "ci:磁次词此",
+	"cong:从", "cu:粗", "cun:存寸", "da:大打达答", "dai:带代袋贷", "dan:单蛋担氮", "dang:当挡", "dao:导刀道到岛",
+	"de:的得德", "deng:等灯登", "di:低地底第递滴", "dian:电点垫店", "diao:吊", "die:叠", "ding:定顶订钉丁", "dong:东动冻",
+	"dou:斗", "du:度镀读独堵", "duan:端断段短锻", "dui:对队堆", "dun:吨盾", "duo:多朵", "e:额", "er:二耳", "fa:发阀法",
+	"fan:反范返翻", "fang:方防放房", "fei:费非废飞", "fen:分粉", "feng:风封丰峰缝", "fu:复负副服辅附浮父福", "gai:改盖钙",
+	"gan:干杆感", "gang:钢缸港岗", "gao:高告", "ge:个各格隔铬割", "gei:给", "gen:根跟", "geng:更", "gong:工公功供共",
+	"gou:构购沟钩", "gu:固故骨鼓古", "gua:挂", "guan:管关观官贯", "guang:光广", "gui:规柜贵硅", "gun:滚棍", "guo:国过锅果",
+	"hai:海", "han:焊含汉寒", "hang:航", "hao:号好耗", "he:合和盒核河", "hei:黑", "heng:恒横", "hong:红洪",
+	"hou:后厚候", "hu:护户互湖壶", "hua:化花华画滑", "huan:环换", "huang:黄", "hui:会回灰汇", "hun:混", "huo:火货活或获",
+	"ji:机级计基记技及积集击几极剂际继挤", "jia:加家价架甲夹", "jian:件检间建简减键剪尖", "jiang:将讲降江", "jiao:交角胶脚焦较",
+	"jie:接节结界解阶截", "jin:金进紧近斤今", "jing:精经径静镜井", "jiu:就旧九", "ju:据局具聚距", "juan:卷", "jue:绝决",
+	"jun:均", "ka:卡", "kai:开", "kang:抗", "kao:靠考", "ke:可科壳刻克颗", "kong:空孔控", "kou:口扣", "ku:库苦",
+	"kuai:块快", "kuan:宽款", "kuang:矿框", "la:拉", "lan:兰蓝缆", "lao:老牢", "lei:类雷", "leng:冷",
+	"li:力理里立利离例粒历", "lian:连联链炼", "liang:量两亮梁粮", "liao:料疗", "lie:列裂", "lin:临林磷", "ling:零领另灵龄",
+	"liu:流六留硫", "long:龙", "lou:楼漏", "lu:路炉录陆露", "lun:轮论", "luo:螺罗落", "lv:铝绿律", "ma:马码麻",
+	"mai:卖买脉", "man:满", "mao:毛帽锚", "mei:每美煤镁", "men:门", "mi:密米", "mian:面棉", "miao:秒", "min:民",
+	"ming:名明", "mo:模膜磨末", "mu:木目母", "na:钠纳", "nai:耐", "nei:内", "neng:能", "ni:泥逆", "nian:年粘",
+	"nie:镍", "ning:凝", "niu:钮扭牛", "nong:农", "nuan:暖", "pai:排牌派", "pan:盘", "pao:泡", "pei:配",
+	"pen:喷", "pi:皮批匹", "pian:片偏", "pin:品频", "ping:平瓶评", "po:破坡", "pu:普铺", "qi:气器起其期七汽齐企",
+	"qian:千铅前签", "qiang:强墙枪", "qiao:桥", "qie:切", "qin:亲", "qing:清轻青请氢", "qiu:球求", "qu:区取曲驱",
+	"quan:全圈权", "que:确缺", "ran:燃染", "re:热", "ren:人认", "ri:日", "rong:容溶熔", "rou:柔", "ru:入如",
+	"ruan:软", "run:润", "san:三散", "se:色", "sha:沙砂", "shan:扇山闪", "shang:上商", "shao:少烧", "she:设射社",
+	"shen:深伸身", "sheng:生升声绳", "shi:式时使试十石实室市是事视示识湿适释", "shou:手收首受售", "shu:数输书属树术束", "shuang:双",
+	"shui:水税", "shun:顺", "shuo:说", "si:丝四司死", "song:送松", "su:速塑素苏", "suan:酸算", "sui:碎随",
+	"suo:锁缩所", "ta:塔", "tai:台太态", "tan:弹碳探", "tang:糖堂", "tao:套陶", "te:特", "ti:体提题替", "tian:天填",
+	"tiao:调条", "tie:铁贴", "ting:停", "tong:铜通同筒统", "tou:头投透", "tu:图土涂凸", "tui:推退", "tuo:托脱", "wa:瓦",
+	"wai:外", "wan:万弯完碗", "wang:网往", "wei:位为维微尾围", "wen:温文稳", "wo:涡", "wu:无物五雾务", "xi:系洗细锡吸西析",
+	"xia:下夏", "xian:线显限纤现先", "xiang:箱相向项像", "xiao:小销消效", "xie:鞋协写斜谢", "xin:新心芯信锌", "xing:型形星性行",
+	"xiu:修", "xu:需序续", "xuan:选旋", "xue:学雪", "xun:寻", "ya:压牙", "yan:盐延验颜研", "yang:样阳氧养", "yao:要药",
+	"ye:液叶业页", "yi:一仪以已易异移医亿议衣", "yin:引印银音阴", "ying:应硬英影", "yong:用永", "you:油有由优", "yu:与于余鱼预语雨玉",
+	"yuan:元圆原源院远", "yue:月约", "yun:运云", "za:杂", "zai:载在", "zao:造早", "ze:则责择", "zeng:增", "zha:闸轧炸",
+	"zhan:站展战占", "zhang:张涨账", "zhao:照", "zhe:这折", "zhen:针真振阵诊", "zheng:正整证蒸征政", "zhi:纸制直支值质指止织职智",
+	"zhong:中重种钟终", "zhou:轴周州", "zhu:主注柱铸助住筑", "zhuan:转专砖", "zhuang:装状", "zhun:准", "zi:子自字资紫",
+	"zong:总综纵", "zu:组阻族", "zuan:钻", "zui:最", "zuo:作座左做",
+}
+
+/*
+文本归一化：全角字符转半角、繁体字转简体字,并提供汉字的拼音转换
+*/
+type Normalizer struct {
+	t2s    map[rune]rune
+	pinyin map[rune]string
+}
+
+func NewNormalizer(cfg *config.Config) *Normalizer {
+	n := &Normalizer{}
+	n.t2s = make(map[rune]rune, len(defaultT2S))
+	n.pinyin = make(map[rune]string)
+	for _, pair := range defaultT2S {
+		n.addT2S(pair)
+	}
+	for _, item := range defaultPinyin {
+		n.addPinyin(item)
+	}
+	if cfg == nil {
+		return n
+	}
+	if len(cfg.T2SDictPath) > 0 {
+		err := loadDict(cfg.T2SDictPath, func(fields []string) {
+			if len(fields) >= 2 {
+				n.addT2S(fields[0] + fields[1])
+			}
+		})
+		if err != nil {
+			logger.Errorf("加载繁简对照表[%s]失败:%s\n", cfg.T2SDictPath, err.Error())
+		}
+	}
+	if len(cfg.PinyinDictPath) > 0 {
+		err := loadDict(cfg.PinyinDictPath, func(fields []string) {
+			if len(fields) < 2 {
+				return
+			}
+			r, size := utf8.DecodeRuneInString(fields[0])
+			if size == 0 || size != len(fields[0]) {
+				return
+			}
+			//多音字只取第一个读音
+			reading := strings.Split(fields[1], ",")[0]
+			n.pinyin[r] = strings.ToUpper(reading)
+		})
+		if err != nil {
+			logger.Errorf("加载拼音字典[%s]失败:%s\n", cfg.PinyinDictPath, err.Error())
+		}
+	}
+	return n
+}
+
+func (n *Normalizer) addT2S(pair string) {
+	runes := []rune(pair)
+	if len(runes) != 2 {
+		return
+	}
+	n.t2s[runes[0]] = runes[1]
+}
+
+func (n *Normalizer) addPinyin(item string) {
+	pos := strings.IndexByte(item, ':')
+	if pos <= 0 {
+		return
+	}
+	reading := strings.ToUpper(item[:pos])
+	for _, r := range item[pos+1:] {
+		n.pinyin[r] = reading
+	}
+}
+
+/*
+全角字符转半角，繁体字转简体字
+*/
+func (n *Normalizer) Normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - 0xFEE0
+		}
+		if s, ok := n.t2s[r]; ok {
+			return s
+		}
+		return r
+	}, text)
+}
+
+/*
+获得中文文本的全拼和拼音首字母，存在没有拼音的字符时返回false
+*/
+func (n *Normalizer) Pinyin(text string) (string, string, bool) {
+	if len(n.pinyin) == 0 {
+		return "", "", false
+	}
+	var full, initials strings.Builder
+	for _, r := range text {
+		if !unicode.Is(unicode.Han, r) {
+			return "", "", false
+		}
+		reading, ok := n.pinyin[r]
+		if !ok || len(reading) == 0 {
+			return "", "", false
+		}
+		full.WriteString(reading)
+		initials.WriteByte(reading[0])
+	}
+	return full.String(), initials.String(), true
+}
+
+/*
+按行读取以空白字符分隔的字典文件
+*/
+func loadDict(path string, f func(fields []string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f(strings.Fields(line))
+	}
+	return scanner.Err()
+}
@@ -3,53 +3,22 @@ package parse
 import (
 	"fmt"
 	"github.com/xp/shorttext-db/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
 
-func TestCutForChinese(t *testing.T) {
-	config.LoadSettings("/opt/test/config/test_case1.txt")
-	cutter := NewCutter(config.GetConfig())
-	strs := []string{`O型圈`, `90°弯管广州`, `ABCD弯管`, `EH供油装置HTGT300G`}
-	for i := 0; i < len(strs); i++ {
-		text := cutter.CutForHybrid(strs[i], 4)
-		fmt.Printf("%d -- %s=>%s\n ", i+1, text, strings.Join(text, "|"))
-	}
-
-}
-
-func Test_isASCII(t *testing.T) {
-	config.LoadSettings("/opt/test/config/test_case1.txt")
-	cutter := NewCutter(config.GetConfig())
-	r := cutter.isASCII(`弯管广州90°`)
-	fmt.Println(r)
-}
-
 func Test_ParseReg(t *testing.T) {
-	config.LoadSettings("/opt/test/config/test_case1.txt")
+	config.LoadSettings("/opt/test/config/test_case1.txt", nil)
 	p := NewParser().(*Parser)
 	s1 := `A,B C|D;E　F`
 	strs := p.regSeparator.Split(s1, -1)
 	fmt.Println(strings.Join(strs, "|"))
 }
 
-func Test_CutterReg(t *testing.T) {
-	config.LoadSettings("/opt/test/config/test_case1.txt")
-	p := NewCutter(config.GetConfig())
-	s1 := `φAB`
-	fmt.Println(p.regReplaced.ReplaceAllString(s1, ""))
-}
-
-func TestJiebaCutter_CutForASCII(t *testing.T) {
-	config.LoadSettings("/opt/test/config/test_case1.txt")
-	cutter := NewCutter(config.GetConfig())
-	strs := []string{`520451-A1-02003`, `φAB`}
-	for i := 0; i < len(strs); i++ {
-		text := cutter.CutForASCII(strs[i], 4)
-		fmt.Printf("%d -- %s=>%s\n ", i+1, strs[i], strings.Join(text, "|"))
-	}
-}
-
 type parseCase struct {
 	Text    string
 	Results []string
@@ -63,7 +32,7 @@ var parseCaseEntity = []parseCase{
 }
 
 func TestParser_Parse(t *testing.T) {
-	config.LoadSettings("/opt/test/config/test_case1.txt")
+	config.LoadSettings("/opt/test/config/test_case1.txt", nil)
 	parse := NewParser()
 	for i := 0; i < len(parseCaseEntity); i++ {
 		_, _ = parse.Parse(parseCaseEntity[i].Text)
@@ -91,3 +60,127 @@ func checkParsedResult(actual []config.Text, expected []string) ([]string, bool)
 	}
 	return result, true
 }
+
+func TestNormalizer_Normalize(t *testing.T) {
+	n := NewNormalizer(nil)
+	cases := map[string]string{
+		`國標溫度計`:    `国标温度计`,
+		`ＷＮＹ－１１`:   `WNY-11`,
+		`電壓　變送器`:   `电压 变送器`,
+		`HGY-2018`: `HGY-2018`,
+	}
+	for text, expected := range cases {
+		if actual := n.Normalize(text); actual != expected {
+			t.Errorf("归一化结果错误[%s]:%s,预期:%s\n", text, actual, expected)
+		}
+	}
+}
+
+func TestNormalizer_Pinyin(t *testing.T) {
+	//未配置拼音字典时使用内置的拼音表
+	n := NewNormalizer(nil)
+	full, initials, ok := n.Pinyin(`国标`)
+	if !ok || full != "GUOBIAO" || initials != "GB" {
+		t.Errorf("拼音转换错误:%s,%s,%v\n", full, initials, ok)
+	}
+	if full, initials, ok = n.Pinyin(`铝线`); !ok || full != "LVXIAN" || initials != "LX" {
+		t.Errorf("拼音转换错误:%s,%s,%v\n", full, initials, ok)
+	}
+	if _, _, ok = n.Pinyin(`国罍`); ok {
+		t.Error("缺少拼音的字符应返回false")
+	}
+}
+
+func TestUserDictionary_Apply(t *testing.T) {
+	dict := NewUserDictionary()
+	if added := dict.AddWords(`引出管`, ` htgt `, `引出管`); len(added) != 2 {
+		t.Errorf("增加自定义词数量错误:%v\n", added)
+	}
+	dict.AddStopWords(`汽水`)
+	words := dict.apply(`汽水引出管`, []string{`汽水`, `引出`, `出管`})
+	if strings.Join(words, "|") != `引出|出管|引出管` {
+		t.Errorf("应用词典结果错误:%v\n", words)
+	}
+	//英文和数字的自定义词只匹配完整的关键字
+	dict.AddWords(`SS`)
+	for text, found := range map[string]bool{`PRESSURE`: false, `CLASS`: false, `SS304`: true, `管道SS`: true, `SS-304`: true} {
+		words = dict.apply(text, []string{})
+		if contains := strings.Contains("|"+strings.Join(words, "|")+"|", "|SS|"); contains != found {
+			t.Errorf("文本[%s]匹配自定义词SS错误:%v\n", text, words)
+		}
+	}
+	dict.RemoveWords(`SS`)
+	dict.RemoveStopWords(`汽水`)
+	dict.RemoveWords(`引出管`)
+	if !reflect.DeepEqual(dict.Words(), []string{`HTGT`}) || len(dict.StopWords()) != 0 {
+		t.Errorf("删除后词典内容错误:%v,%v\n", dict.Words(), dict.StopWords())
+	}
+}
+
+func TestUserDictionary_SaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1", "user_dict.json")
+	dict := NewUserDictionary()
+	dict.AddWords(`引出管`)
+	dict.AddStopWords(`型号`)
+	if err = dict.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewUserDictionary()
+	if err = loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Words(), dict.Words()) || !loaded.IsStopWord(`型号`) {
+		t.Errorf("加载的词典内容错误:%v,%v\n", loaded.Words(), loaded.StopWords())
+	}
+	if err = NewUserDictionary().Load(filepath.Join(dir, "none.json")); err != nil {
+		t.Error("文件不存在时不应返回错误:", err)
+	}
+}
+
+func TestSynonymDictionary(t *testing.T) {
+	dict := NewSynonymDictionary()
+	dict.AddGroup(`不锈钢`, `ss`)
+	dict.AddGroup(`SS`, `SUS`)
+	dict.AddGroup(`螺栓`, `bolt`)
+	if !reflect.DeepEqual(dict.Synonyms(`不锈钢`), []string{`SS`, `SUS`}) {
+		t.Errorf("合并后的同义词错误:%v\n", dict.Synonyms(`不锈钢`))
+	}
+	dict.RemoveWords(`BOLT`)
+	if len(dict.Synonyms(`螺栓`)) != 0 || len(dict.Groups()) != 1 {
+		t.Errorf("删除同义词后词组错误:%v\n", dict.Groups())
+	}
+	words := dict.apply(`不锈钢螺栓`, []string{`不锈`, `锈钢`, `螺栓`})
+	if !reflect.DeepEqual(words, []string{`不锈`, `锈钢`, `螺栓`, `不锈钢`}) {
+		t.Errorf("应用同义词后的分词结果错误:%v\n", words)
+	}
+	for _, text := range []string{`PRESSURE`, `CLASS`, `ASSY`} {
+		if words = dict.apply(text, []string{text}); len(words) != 1 {
+			t.Errorf("文本[%s]不应匹配同义词SS:%v\n", text, words)
+		}
+	}
+	if words = dict.apply(`SS304`, []string{`SS304`}); !reflect.DeepEqual(words, []string{`SS304`, `SS`}) {
+		t.Errorf("应用同义词后的分词结果错误:%v\n", words)
+	}
+
+	dir, err := ioutil.TempDir("", "synonym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "synonyms.txt")
+	if err = dict.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewSynonymDictionary()
+	if err = loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Groups(), dict.Groups()) {
+		t.Errorf("加载的同义词错误:%v\n", loaded.Groups())
+	}
+}
@@ -23,11 +23,20 @@ type textItem struct {
 	Index    int
 }
 type IParse interface {
+	//查询时分词
 	Parse(text string) ([]config.Text, error)
+	//创建索引时分词,在查询分词的基础上增加拼音等扩展关键字
+	ParseForIndex(text string) ([]config.Text, error)
+	//文本归一化,全角转半角、繁体转简体,字符数量不变
+	Normalize(text string) string
 }
 
 type Parser struct {
 	cutter          Cutter
+	normalizer      *Normalizer
+	dict            *UserDictionary
+	synonyms        *SynonymDictionary
+	pinyinIndex     bool
 	regCompletedHan *regexp.Regexp
 	regPartitionHan *regexp.Regexp
 	regSeparator    *regexp.Regexp
@@ -36,7 +45,16 @@ type Parser struct {
 func NewParser() IParse {
 	cfg := config.GetConfig()
 	p := &Parser{}
-	p.cutter = NewCutter(cfg)
+	cutter, err := CreateCutter(cfg)
+	if err != nil {
+		logger.Errorf("创建分词器失败:%s,使用ngram分词\n", err.Error())
+		cutter = NewNGramCutter()
+	}
+	p.cutter = cutter
+	p.normalizer = NewNormalizer(cfg)
+	p.dict = GetUserDictionary()
+	p.synonyms = GetSynonymDictionary()
+	p.pinyinIndex = cfg != nil && cfg.PinyinIndex
 	//字符从头到尾都是中文字符
 	p.regCompletedHan = regexp.MustCompile(`^\p{Han}+$`)
 	//部分字符是中文
@@ -47,6 +65,22 @@ func NewParser() IParse {
 }
 
 func (p *Parser) Parse(text string) ([]config.Text, error) {
+	return p.parse(text, false)
+}
+
+func (p *Parser) ParseForIndex(text string) ([]config.Text, error) {
+	return p.parse(text, p.pinyinIndex)
+}
+
+func (p *Parser) Normalize(text string) string {
+	return p.normalizer.Normalize(text)
+}
+
+/*
+先对文本进行归一化再分词，withPinyin为true时对中文关键字增加全拼和拼音首字母
+*/
+func (p *Parser) parse(text string, withPinyin bool) ([]config.Text, error) {
+	text = p.normalizer.Normalize(text)
 	textItems := p.split(text)
 	result := make([]config.Text, 0, 0)
 	var words []string
@@ -61,6 +95,8 @@ func (p *Parser) Parse(text string) ([]config.Text, error) {
 		case HYBRID:
 			words = p.cutter.CutForHybrid(val.Text, 4)
 		}
+		words = p.dict.apply(val.Text, words)
+		words = p.synonyms.apply(val.Text, words)
 		for _, w := range words {
 			if len(w) == 0 {
 				continue
@@ -76,11 +112,37 @@ func (p *Parser) Parse(text string) ([]config.Text, error) {
 			result = append(result, item)
 			checker[w] = true
 		}
+		if withPinyin && val.ItemType == CHINESE {
+			result = p.appendPinyin(result, checker, append(words, val.Text))
+		}
 	}
 	//logParsedResult(text, result)
 	return result, nil
 }
 
+/*
+增加中文关键字的全拼和拼音首字母
+*/
+func (p *Parser) appendPinyin(result []config.Text, checker map[string]bool, words []string) []config.Text {
+	for _, w := range words {
+		if utf8.RuneCountInString(w) < 2 {
+			continue
+		}
+		full, initials, ok := p.normalizer.Pinyin(w)
+		if !ok {
+			continue
+		}
+		for _, py := range []string{full, initials} {
+			if checker[py] {
+				continue
+			}
+			result = append(result, config.Text(py))
+			checker[py] = true
+		}
+	}
+	return result
+}
+
 func (p *Parser) split(text string) []textItem {
 	segmentations := strings.Split(text, config.PARSING_RECORD_SEP)
 	result := make([]textItem, 0)
@@ -0,0 +1,226 @@
+package parse
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var synonymDict = NewSynonymDictionary()
+
+/*
+获得进程内共享的运行时同义词词典
+*/
+func GetSynonymDictionary() *SynonymDictionary {
+	return synonymDict
+}
+
+/*
+运行时同义词词典，同一组中的词互为同义词。
+文本中包含同义词时，同义词在建索引和查找时都作为一个完整的关键字，查找时关键字扩展为同组的其他词。
+已建索引的记录不会自动包含新增的同义词关键字，同义词变化后需要对包含变化词的记录重建索引
+*/
+type SynonymDictionary struct {
+	//词对应的同义词组编号
+	groups map[string]int
+	//同义词组编号对应的词
+	members map[int]map[string]bool
+	nextId  int
+	lock    sync.RWMutex
+}
+
+func NewSynonymDictionary() *SynonymDictionary {
+	s := &SynonymDictionary{}
+	s.groups = make(map[string]int)
+	s.members = make(map[int]map[string]bool)
+	return s
+}
+
+/*
+增加一组同义词，组中的词已属于其他组时合并为一组，返回新增的词
+*/
+func (s *SynonymDictionary) AddGroup(words ...string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	normalized := make([]string, 0, len(words))
+	for _, w := range words {
+		w = normalizeWord(w)
+		if len(w) > 0 {
+			normalized = append(normalized, w)
+		}
+	}
+	if len(normalized) < 2 {
+		return nil
+	}
+	s.nextId++
+	id := s.nextId
+	group := make(map[string]bool)
+	changed := make([]string, 0, len(normalized))
+	for _, w := range normalized {
+		old, ok := s.groups[w]
+		if !ok {
+			group[w] = true
+			changed = append(changed, w)
+			continue
+		}
+		for m := range s.members[old] {
+			group[m] = true
+		}
+		delete(s.members, old)
+	}
+	for w := range group {
+		s.groups[w] = id
+	}
+	s.members[id] = group
+	return changed
+}
+
+/*
+从同义词组中删除词，组中只剩一个词时删除整个组，返回实际删除的词
+*/
+func (s *SynonymDictionary) RemoveWords(words ...string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	changed := make([]string, 0, len(words))
+	for _, w := range words {
+		w = normalizeWord(w)
+		id, ok := s.groups[w]
+		if !ok {
+			continue
+		}
+		delete(s.groups, w)
+		delete(s.members[id], w)
+		changed = append(changed, w)
+		if len(s.members[id]) < 2 {
+			for m := range s.members[id] {
+				delete(s.groups, m)
+				changed = append(changed, m)
+			}
+			delete(s.members, id)
+		}
+	}
+	return changed
+}
+
+/*
+获得词的同义词，不包含词本身
+*/
+func (s *SynonymDictionary) Synonyms(word string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	w := normalizeWord(word)
+	id, ok := s.groups[w]
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(s.members[id])-1)
+	for m := range s.members[id] {
+		if m != w {
+			result = append(result, m)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+/*
+获得所有同义词组，组内和组之间均按字典顺序排列
+*/
+func (s *SynonymDictionary) Groups() [][]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([][]string, 0, len(s.members))
+	for _, group := range s.members {
+		result = append(result, sortedKeys(group))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0] < result[j][0]
+	})
+	return result
+}
+
+/*
+从文件加载同义词词典，每行一组同义词，词之间用逗号分隔，#开头的行为注释。
+文件不存在时词典为空
+*/
+func (s *SynonymDictionary) Load(path string) error {
+	buff, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	groups := make([][]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(buff))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		groups = append(groups, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == '，'
+		}))
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.groups = make(map[string]int)
+	s.members = make(map[int]map[string]bool)
+	s.lock.Unlock()
+	for _, group := range groups {
+		s.AddGroup(group...)
+	}
+	return nil
+}
+
+/*
+保存同义词词典，先写临时文件再重命名，避免保存过程中断导致文件损坏
+*/
+func (s *SynonymDictionary) Save(path string) error {
+	var buff bytes.Buffer
+	for _, group := range s.Groups() {
+		buff.WriteString(strings.Join(group, ","))
+		buff.WriteString("\n")
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, buff.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+/*
+对分词结果应用同义词词典：增加文本中包含的完整同义词，使同义词在索引和查找时都是完整的关键字，
+英文和数字的同义词不匹配更长关键字的一部分，例如SS不匹配PRESSURE
+*/
+func (s *SynonymDictionary) apply(text string, words []string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.groups) == 0 {
+		return words
+	}
+	upperText := strings.ToUpper(text)
+	exists := make(map[string]bool, len(words))
+	for _, w := range words {
+		exists[strings.ToUpper(w)] = true
+	}
+	for w := range s.groups {
+		if !exists[w] && containsWord(upperText, w) {
+			words = append(words, w)
+			exists[w] = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,116 @@
+package shardeddb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/memdb"
+	"github.com/xp/shorttext-db/network"
+	"strconv"
+)
+
+//批量消息结果的键,节点返回的消息保留请求消息的Index和Key
+type batchKey struct {
+	index uint64
+	key   string
+}
+
+/*
+把多条消息作为一个BatchMessage发送给节点,各消息使用相同的Term，节点逐条处理后返回结果。
+返回各消息的处理结果,同一批中Index和Key相同的消息只保留一个结果
+*/
+func (d *dbNodeClient) sendMessages(messages []*network.Message) (map[batchKey]*network.Message, error) {
+	term, err := d.generateId()
+	if err != nil {
+		return nil, err
+	}
+	from := config.GetCase().GetMaster().ID
+	batch := &network.BatchMessage{Term: term, Messages: messages}
+	for _, m := range messages {
+		m.Term = term
+		m.Count = uint32(len(messages))
+		m.From = from
+		m.To = d.Id
+	}
+	result, err := d.client.Send(batch)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New(fmt.Sprintf("dbNodeClient 批量操作失败[Node:%d]", d.Id))
+	}
+	replies := make(map[batchKey]*network.Message, len(result.Messages))
+	for _, m := range result.Messages {
+		replies[batchKey{index: m.Index, key: m.Key}] = m
+	}
+	return replies, nil
+}
+
+/*
+把一批记录按分库生成消息后作为一个BatchMessage发送,结果保存在各记录中
+*/
+func (d *dbNodeClient) sendItems(items []*entities.BatchItem, msgType uint32) error {
+	if len(items) == 0 {
+		return nil
+	}
+	messages := make([]*network.Message, 0, len(items))
+	for _, item := range items {
+		m := &network.Message{}
+		m.Type = msgType
+		m.Key = item.Key
+		m.Index = item.Index
+		m.DBName = d.dbName + "_" + strconv.FormatUint(item.Index, 10)
+		if msgType == config.MSG_KV_TEXTSET || msgType == config.MSG_KV_SET {
+			m.Text = item.Value
+		}
+		messages = append(messages, m)
+	}
+	replies, err := d.sendMessages(messages)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		item.Err = nil
+		reply, ok := replies[batchKey{index: item.Index, key: item.Key}]
+		switch {
+		case !ok:
+			item.Err = errors.New(fmt.Sprintf("节点[%d]没有返回结果[Key:%s]", d.Id, item.Key))
+		case reply.ResultCode == config.MSG_KV_RESULT_FAILURE:
+			item.Err = errors.New(reply.Text)
+		case msgType == config.MSG_KV_TEXTGET:
+			item.Value = reply.Text
+		}
+	}
+	return nil
+}
+
+/*
+批量读取文本,记录不存在时Value为空且没有错误
+*/
+func (d *dbNodeClient) MultiGetText(items []*entities.BatchItem) error {
+	for _, item := range items {
+		item.Value = ""
+	}
+	if err := d.sendItems(items, config.MSG_KV_TEXTGET); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Err != nil && item.Err.Error() == memdb.ErrNotFound.Error() {
+			item.Err = nil
+		}
+	}
+	return nil
+}
+
+func (d *dbNodeClient) MultiSetText(items []*entities.BatchItem) error {
+	return d.sendItems(items, config.MSG_KV_TEXTSET)
+}
+
+func (d *dbNodeClient) MultiSet(items []*entities.BatchItem) error {
+	return d.sendItems(items, config.MSG_KV_SET)
+}
+
+func (d *dbNodeClient) MultiDelete(items []*entities.BatchItem) error {
+	return d.sendItems(items, config.MSG_KV_DEL)
+}
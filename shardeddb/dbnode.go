@@ -10,9 +10,10 @@ import (
 	"github.com/xp/shorttext-db/filedb"
 	"github.com/xp/shorttext-db/network"
 	"github.com/xp/shorttext-db/network/proxy"
-	"github.com/xp/shorttext-db/shardedkv"
+	"github.com/xp/shorttext-db/parse"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var dbNode *DBNode
@@ -29,8 +30,13 @@ type DBNode struct {
 }
 
 type findParam struct {
-	DBName string
-	Text   string
+	DBName  string
+	Text    string
+	Options *entities.FindOptions
+	//分页查找的每页记录数,小于等于0时不分页
+	PageSize int
+	//分页查找时各分库的起始位置,键为分库名字,Offset为-1表示分库已没有更多记录
+	Offsets map[string]pagePos
 }
 
 func Start(remoting bool) {
@@ -60,10 +66,17 @@ func NewDBNode(remoting bool) (*DBNode, error) {
 	id := int(c.Local.ID)
 	node.ID = id
 	cfg := config.GetConfig()
-	node.chooser = shardedkv.NewRangeChooser(
-		uint32(config.GetConfig().KVDBMaxRange),
-		uint32(config.GetConfig().KVDBRowCount),
-		uint32(config.GetConfig().KVDRowStart))
+	node.chooser = newChooser(cfg)
+
+	//分库重建索引之前加载用户词典
+	dictPath := userDictPath(id, cfg)
+	if err := parse.GetUserDictionary().Load(dictPath); err != nil {
+		logger.Errorf("加载用户词典[%s]失败:%s\n", dictPath, err.Error())
+	}
+	synonymsPath := synonymPath(id, cfg)
+	if err := parse.GetSynonymDictionary().Load(synonymsPath); err != nil {
+		logger.Errorf("加载同义词词典[%s]失败:%s\n", synonymsPath, err.Error())
+	}
 
 	cards := config.GetCase().CardList
 	shardNames := make([]string, 0, len(cards))
@@ -75,6 +88,7 @@ func NewDBNode(remoting bool) (*DBNode, error) {
 	processor := newDBNodeHandler(id,
 		int(config.GetConfig().KVDBMaxRange),
 		config.GetConfig().KVDBFilePath,
+		cfg,
 		config.GetConfig().KVDBNames...)
 
 	if remoting {
@@ -89,6 +103,8 @@ func NewDBNode(remoting bool) (*DBNode, error) {
 	}
 	processor.clbt = collaborator.NewCollaborator(int(cfg.KVDBMaxRange))
 	processor.defaultDB = cfg.KVDBNames[0]
+	processor.dictPath = dictPath
+	processor.synonymPath = synonymsPath
 	node.nodeHandler = processor
 	LoadLookupJob(cfg, processor.dbs)
 
@@ -116,14 +132,51 @@ func (d *DBNode) StartProxy() {
 	server.Start()
 }
 
-func (d *DBNode) Find(db string, text string) ([]entities.Record, error) {
-	return d.nodeHandler.find(db, text)
+/*
+查找文本命中的记录，opts为空时使用缺省的匹配模式和阈值。
+部分分库查找失败或索引正在重建时返回其余分库的记录和*entities.PartialError
+*/
+func (d *DBNode) Find(db string, text string, opts *entities.FindOptions) ([]entities.Record, error) {
+	if opts == nil {
+		opts = entities.NewFindOptions()
+	}
+	return d.nodeHandler.find(db, text, opts)
+}
+
+/*
+查找文本命中的记录，按相关度评分从高到低返回前limit条
+*/
+func (d *DBNode) FindTop(db string, text string, limit int) ([]entities.Record, error) {
+	opts := entities.NewFindOptions()
+	opts.Limit = limit
+	return d.nodeHandler.find(db, text, opts)
+}
+
+/*
+数据库的所有分库索引均已就绪时返回true
+*/
+func (d *DBNode) IsIndexReady(db string) bool {
+	return d.nodeHandler.isIndexReady(db)
 }
 
 func (d *DBNode) GetCount(db string) []int {
 	return d.nodeHandler.getCount(db)
 }
 func (d *DBNode) Set(dbName string, key uint64, value string) (err error) {
+	return d.set(dbName, key, value, 0)
+}
+
+/*
+在本节点保存文本并创建索引，ttl之后记录及其索引自动删除
+*/
+func (d *DBNode) SetWithTTL(dbName string, key uint64, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New(fmt.Sprintf("TTL必须大于0, key:%d", key))
+	}
+	return d.set(dbName, key, value, ttl)
+}
+
+func (d *DBNode) set(dbName string, key uint64, value string, ttl time.Duration) (err error) {
 	shardName, index := d.chooser.Choose(key)
 	if config.GetCase().Local.Name != shardName {
 		return errors.New(fmt.Sprintf("分片名称不同, local:%s,actual:%s", config.GetCase().Local.Name, shardName))
@@ -134,7 +187,10 @@ func (d *DBNode) Set(dbName string, key uint64, value string) (err error) {
 		return errors.New(fmt.Sprintf("数据库不存在, DbName:%s", actualDb))
 	}
 	strKey := strconv.FormatUint(key, 10)
-	err = store.SetWithIndex(strKey, value, config.GJSON_FIELD_DESC)
+	if ttl > 0 {
+		return store.SetWithTTL(strKey, value, ttl)
+	}
+	err = store.SetWithIndex(strKey, value)
 
 	return err
 }
@@ -0,0 +1,165 @@
+package shardeddb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/network"
+	"github.com/xp/shorttext-db/parse"
+	"github.com/xp/shorttext-db/utils"
+	"strconv"
+	"strings"
+)
+
+/*
+获得用户词典的保存路径,未配置KVUserDictPath时保存在节点的数据目录下
+*/
+func userDictPath(id int, cfg *config.Config) string {
+	if len(cfg.KVUserDictPath) > 0 {
+		return cfg.KVUserDictPath
+	}
+	return cfg.KVDBFilePath + "/" + strconv.Itoa(id) + "/user_dict.json"
+}
+
+/*
+获得同义词词典的保存路径,未配置KVSynonymPath时保存在节点的数据目录下
+*/
+func synonymPath(id int, cfg *config.Config) string {
+	if len(cfg.KVSynonymPath) > 0 {
+		return cfg.KVSynonymPath
+	}
+	return cfg.KVDBFilePath + "/" + strconv.Itoa(id) + "/synonyms.txt"
+}
+
+/*
+更新本节点的用户词典和同义词词典并保存，Reindex为true时在后台对包含变化词的记录重建索引。
+同义词在建索引时作为完整的关键字(见parse.SynonymDictionary),同义词变化时总是在后台对包含变化同义词的记录重建索引,
+否则运行时增加的同义词只对之后写入的记录生效
+*/
+func (d *dbNodeHandler) updateDictionary(req *entities.DictRequest) error {
+	dict := parse.GetUserDictionary()
+	synonyms := parse.GetSynonymDictionary()
+	var changed, changedSynonyms []string
+	switch req.Action {
+	case entities.DICT_ACTION_ADD:
+		changed = append(dict.AddWords(req.Words...), dict.AddStopWords(req.StopWords...)...)
+		for _, group := range req.Synonyms {
+			changedSynonyms = append(changedSynonyms, synonyms.AddGroup(group...)...)
+		}
+	case entities.DICT_ACTION_REMOVE:
+		changed = append(dict.RemoveWords(req.Words...), dict.RemoveStopWords(req.StopWords...)...)
+		for _, group := range req.Synonyms {
+			changedSynonyms = append(changedSynonyms, synonyms.RemoveWords(group...)...)
+		}
+	default:
+		return errors.New(fmt.Sprintf("用户词典不支持该操作[%d]", req.Action))
+	}
+	logger.Infof("用户词典更新:[action:%d,words:%s,synonyms:%s]\n", req.Action, strings.Join(changed, "|"), strings.Join(changedSynonyms, "|"))
+	if len(changed) > 0 && len(d.dictPath) > 0 {
+		if err := dict.Save(d.dictPath); err != nil {
+			return errors.New(fmt.Sprintf("保存用户词典[%s]失败:%s", d.dictPath, err.Error()))
+		}
+	}
+	if len(changedSynonyms) > 0 && len(d.synonymPath) > 0 {
+		if err := synonyms.Save(d.synonymPath); err != nil {
+			return errors.New(fmt.Sprintf("保存同义词词典[%s]失败:%s", d.synonymPath, err.Error()))
+		}
+	}
+	if !req.Reindex {
+		changed = nil
+	}
+	changed = append(changed, changedSynonyms...)
+	if len(changed) > 0 {
+		go d.reindex(changed)
+	}
+	return nil
+}
+
+func (d *dbNodeHandler) reindex(words []string) {
+	t := utils.NewTimer()
+	count := 0
+	for _, store := range d.dbs {
+		count = count + store.Reindex(words)
+	}
+	logger.Infof("用户词典变化后重建索引完成,记录数:%d,Time:%.2f\n", count, t.Stop())
+}
+
+/*
+更新本节点的用户词典，整个集群的更新使用BroadcastDictionary
+*/
+func (d *DBNode) UpdateDictionary(req *entities.DictRequest) error {
+	return d.nodeHandler.updateDictionary(req)
+}
+
+/*
+获得本节点的自定义词和停用词
+*/
+func (d *DBNode) GetDictionary() (words []string, stopWords []string) {
+	dict := parse.GetUserDictionary()
+	return dict.Words(), dict.StopWords()
+}
+
+/*
+获得本节点的同义词组
+*/
+func (d *DBNode) GetSynonyms() [][]string {
+	return parse.GetSynonymDictionary().Groups()
+}
+
+/*
+从文件重新加载本节点的同义词词典，用于直接修改同义词文件之后,不重建索引。
+需要对包含变化同义词的记录重建索引时使用UpdateDictionary
+*/
+func (d *DBNode) ReloadSynonyms() error {
+	return parse.GetSynonymDictionary().Load(d.nodeHandler.synonymPath)
+}
+
+func (d *dbNodeClient) updateDictionary(req *entities.DictRequest) error {
+	text, err := serialize(req)
+	if err != nil {
+		return err
+	}
+	term, err := d.generateId()
+	if err != nil {
+		return err
+	}
+	m := network.NewOnlyOneMsg(term, "", text, config.MSG_KV_DICT)
+	m.Messages[0].From = config.GetCase().GetMaster().ID
+	m.Messages[0].To = d.Id
+	result, err := d.client.Send(m)
+	if err != nil {
+		return err
+	}
+	if result == nil || len(result.Messages) == 0 {
+		return errors.New(fmt.Sprintf("dbNodeClient 更新用户词典失败[Node:%d]", d.Id))
+	}
+	resultMsg := result.Messages[0]
+	if resultMsg.ResultCode == config.MSG_KV_RESULT_FAILURE {
+		return errors.New(resultMsg.Text)
+	}
+	return nil
+}
+
+/*
+通过代理服务器把用户词典的更新发送给集群中的所有节点，返回更新失败的节点信息
+*/
+func BroadcastDictionary(req *entities.DictRequest) error {
+	shards, err := newShards("")
+	if err != nil {
+		return err
+	}
+	failures := make([]string, 0)
+	for _, shard := range shards {
+		client := shard.Backend.(*dbNodeClient)
+		err = client.updateDictionary(req)
+		if err != nil {
+			logger.Errorf("节点[%s]更新用户词典失败:%s\n", shard.Name, err.Error())
+			failures = append(failures, shard.Name+":"+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(fmt.Sprintf("更新用户词典失败:%s", strings.Join(failures, ";")))
+	}
+	return nil
+}
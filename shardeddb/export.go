@@ -0,0 +1,344 @@
+package shardeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/gjson"
+	"github.com/xp/shorttext-db/memdb"
+	"github.com/xp/shorttext-db/network"
+	"github.com/xp/shorttext-db/shardedkv"
+	"github.com/xp/shorttext-db/utils"
+	"io"
+	"strconv"
+	"time"
+)
+
+//分页导出的缺省每页记录数
+const DEFAULT_EXPORT_PAGE_SIZE = 1000
+
+/*
+分页导出请求,After为上一页的最后一个主键,为空时从第一条记录开始
+*/
+type exportRequest struct {
+	After string `json:"after"`
+	Limit int    `json:"limit"`
+	Terms bool   `json:"terms"`
+}
+
+/*
+按主键顺序导出after之后的最多limit条记录,withTerms为true时同时导出索引关键字,
+设置了过期时间的记录同时导出剩余的生存时间,已过期的记录不导出
+*/
+func (m *memStorage) Export(after string, limit int, withTerms bool) (*entities.ExportPage, error) {
+	if limit <= 0 {
+		limit = DEFAULT_EXPORT_PAGE_SIZE
+	}
+	page := &entities.ExportPage{Records: make([]entities.ExportRecord, 0, limit)}
+	err := m.db.View(func(tx *memdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", after, func(key, value string) bool {
+			if key == after {
+				return true
+			}
+			if len(page.Records) == limit {
+				page.Next = page.Records[len(page.Records)-1].Key
+				return false
+			}
+			ttl, err := tx.TTL(key)
+			if err != nil {
+				return true
+			}
+			record := entities.ExportRecord{Key: key, Value: exportValue(value)}
+			if ttl > 0 {
+				//不足1毫秒时按1毫秒导出,避免被当作没有过期时间
+				record.TTL = int64((ttl + time.Millisecond - 1) / time.Millisecond)
+			}
+			if withTerms {
+				record.Terms = m.exportTerms(value)
+			}
+			page.Records = append(page.Records, record)
+			return true
+		})
+	})
+	return page, err
+}
+
+/*
+存储的文本是JSON时原样导出,否则导出为JSON字符串
+*/
+func exportValue(value string) json.RawMessage {
+	if gjson.Valid(value) {
+		return json.RawMessage(value)
+	}
+	buff, _ := json.Marshal(value)
+	return json.RawMessage(buff)
+}
+
+/*
+导入时还原存储的文本
+*/
+func importValue(value json.RawMessage) string {
+	var text string
+	if len(value) > 0 && value[0] == '"' && json.Unmarshal(value, &text) == nil {
+		return text
+	}
+	return string(value)
+}
+
+/*
+按创建索引时的分词方式获得记录索引字段的关键字
+*/
+func (m *memStorage) exportTerms(value string) []string {
+	terms := make([]string, 0)
+	checker := make(map[string]bool)
+	for _, text := range m.indexValues(value) {
+		words, err := m.index.ParseForIndex(text)
+		if err != nil {
+			continue
+		}
+		for _, w := range words {
+			term := string(w)
+			if !checker[term] {
+				checker[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+/*
+把分库的记录逐页写入w,跳过已删除记录的墓碑,返回导出的记录数
+*/
+func writeExport(w io.Writer, pageSize int, next func(after string, limit int) (*entities.ExportPage, error)) (int, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	count := 0
+	after := ""
+	for {
+		page, err := next(after, pageSize)
+		if err != nil {
+			return count, err
+		}
+		for i := range page.Records {
+			//有多个副本时删除记录写入的墓碑不导出
+			if shardedkv.IsTombstone(importValue(page.Records[i].Value)) {
+				continue
+			}
+			if err = encoder.Encode(&page.Records[i]); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(page.Next) == 0 {
+			return count, nil
+		}
+		after = page.Next
+	}
+}
+
+/*
+以JSON Lines格式导出本节点数据库的所有分库,每行一条记录,返回导出的记录数
+*/
+func (d *DBNode) Export(db string, w io.Writer, withTerms bool) (int, error) {
+	if len(db) == 0 {
+		db = d.nodeHandler.defaultDB
+	}
+	total := 0
+	for i := 1; i <= d.nodeHandler.dbCount; i++ {
+		dbName := db + "_" + strconv.Itoa(i)
+		store, ok := d.nodeHandler.dbs[dbName]
+		if !ok {
+			return total, errors.New(fmt.Sprintf("数据库实例[%s]不存在", dbName))
+		}
+		count, err := writeExport(w, DEFAULT_EXPORT_PAGE_SIZE, func(after string, limit int) (*entities.ExportPage, error) {
+			return store.Export(after, limit, withTerms)
+		})
+		total = total + count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (d *dbNodeHandler) processExport(db IMemStorage, m network.Message) (string, error) {
+	req := &exportRequest{}
+	if _, err := deserialize(m.Text, req); err != nil {
+		return "", err
+	}
+	page, err := db.Export(req.After, req.Limit, req.Terms)
+	if err != nil {
+		return "", err
+	}
+	return serialize(page)
+}
+
+func (d *dbNodeClient) exportPage(index int, req *exportRequest) (*entities.ExportPage, error) {
+	page := &entities.ExportPage{}
+	if err := d.query(uint64(index), config.MSG_KV_EXPORT, req, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+/*
+向节点上的分库发送JSON格式的请求,把返回的JSON结果解析到result
+*/
+func (d *dbNodeClient) query(index uint64, msgType uint32, req interface{}, result interface{}) error {
+	text, err := serialize(req)
+	if err != nil {
+		return err
+	}
+	term, err := d.generateId()
+	if err != nil {
+		return err
+	}
+	m := network.NewOnlyOneMsg(term, "", text, msgType)
+	m.Messages[0].From = config.GetCase().GetMaster().ID
+	m.Messages[0].To = d.Id
+	m.Messages[0].DBName = d.dbName + "_" + strconv.FormatUint(index, 10)
+	reply, err := d.client.Send(m)
+	if err != nil {
+		return err
+	}
+	if reply == nil || len(reply.Messages) == 0 {
+		return errors.New(fmt.Sprintf("dbNodeClient 请求失败[Node:%d,Type:%d]", d.Id, msgType))
+	}
+	resultMsg := reply.Messages[0]
+	if resultMsg.ResultCode == config.MSG_KV_RESULT_FAILURE {
+		return errors.New(resultMsg.Text)
+	}
+	_, err = deserialize(resultMsg.Text, result)
+	return err
+}
+
+/*
+按主键顺序分页导出节点上分库中after之后的记录
+*/
+func (d *dbNodeClient) Export(index uint64, after string, limit int) (*entities.ExportPage, error) {
+	return d.exportPage(int(index), &exportRequest{After: after, Limit: limit})
+}
+
+/*
+通过代理服务器逐页导出集群中数据库的所有记录，边读取边写入，不在内存中缓存整个数据库
+*/
+type Exporter struct {
+	dbName  string
+	clients []*dbNodeClient
+	names   []string
+	//分库数量
+	dbCount int
+	//每页记录数
+	PageSize int
+	//是否导出索引关键字
+	WithTerms bool
+	//每页导出完成后调用,参数为截至当前导出的记录数
+	Progress func(count int)
+	//有多个副本时按分片选择器只导出主副本上的记录
+	chooser  api.Chooser
+	replicas int
+}
+
+func NewExporter(dbName string) (*Exporter, error) {
+	shards, err := newShards(dbName)
+	if err != nil {
+		return nil, err
+	}
+	e := &Exporter{}
+	e.dbName = dbName
+	e.dbCount = int(config.GetConfig().KVDBMaxRange)
+	e.PageSize = DEFAULT_EXPORT_PAGE_SIZE
+	for _, shard := range shards {
+		e.clients = append(e.clients, shard.Backend.(*dbNodeClient))
+		e.names = append(e.names, shard.Name)
+	}
+	e.replicas = config.GetConfig().GetReplicas()
+	e.chooser = newChooser(config.GetConfig())
+	if err = e.chooser.SetBuckets(e.names); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+/*
+以JSON Lines格式把所有节点的所有分库写入w,返回导出的记录数。
+有多个副本时每条记录只从主副本所在的节点导出一次
+*/
+func (e *Exporter) Export(w io.Writer) (int, error) {
+	t := utils.NewTimer()
+	total := 0
+	exported := 0
+	for i, client := range e.clients {
+		for index := 1; index <= e.dbCount; index++ {
+			count, err := writeExport(w, e.PageSize, func(after string, limit int) (*entities.ExportPage, error) {
+				page, err := client.exportPage(index, &exportRequest{After: after, Limit: limit, Terms: e.WithTerms})
+				if err == nil {
+					page.Records = e.primaryRecords(e.names[i], page.Records)
+				}
+				if err == nil && e.Progress != nil {
+					exported = exported + len(page.Records)
+					e.Progress(exported)
+				}
+				return page, err
+			})
+			total = total + count
+			if err != nil {
+				return total, errors.New(fmt.Sprintf("节点[%s]分库[%s_%d]导出失败:%s", e.names[i], e.dbName, index, err.Error()))
+			}
+		}
+	}
+	logger.Infof("数据库[%s]导出完成,记录数:%d,Time:%.2f\n", e.dbName, total, t.Stop())
+	return total, nil
+}
+
+/*
+有多个副本时只保留主副本在该节点上的记录。主副本在其他节点上的记录按节点批量读取主副本,
+主副本没有记录或读取失败时仍从该节点导出,避免记录丢失
+*/
+func (e *Exporter) primaryRecords(name string, records []entities.ExportRecord) []entities.ExportRecord {
+	if e.replicas <= 1 || e.chooser == nil {
+		return records
+	}
+	others := make(map[string][]*entities.BatchItem)
+	for _, record := range records {
+		nKey, err := strconv.ParseUint(record.Key, 10, 64)
+		if err != nil {
+			continue
+		}
+		if primary, index := e.chooser.Choose(nKey); primary != name {
+			others[primary] = append(others[primary], &entities.BatchItem{Key: record.Key, Index: index})
+		}
+	}
+	skipped := make(map[string]bool)
+	for primary, items := range others {
+		client := e.client(primary)
+		if client == nil || client.MultiGetText(items) != nil {
+			continue
+		}
+		for _, item := range items {
+			if item.Err == nil && len(item.Value) > 0 {
+				skipped[item.Key] = true
+			}
+		}
+	}
+	result := records[:0]
+	for _, record := range records {
+		if !skipped[record.Key] {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+func (e *Exporter) client(name string) *dbNodeClient {
+	for i, n := range e.names {
+		if n == name {
+			return e.clients[i]
+		}
+	}
+	return nil
+}
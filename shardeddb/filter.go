@@ -0,0 +1,158 @@
+package shardeddb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/gjson"
+	"github.com/xp/shorttext-db/memdb"
+	"strings"
+)
+
+//二级索引名字的前缀,后接gjson路径
+const filterIndexPrefix = "filter:"
+
+/*
+编译后的过滤条件,过滤值转换为gjson.Result后与记录字段比较
+*/
+type valueFilter struct {
+	path   string
+	op     string
+	values []gjson.Result
+}
+
+func compileFilters(filters []entities.Filter) ([]valueFilter, error) {
+	result := make([]valueFilter, 0, len(filters))
+	for _, f := range filters {
+		if len(f.Path) == 0 {
+			return nil, errors.New("过滤条件的字段路径为空")
+		}
+		c := valueFilter{path: f.Path, op: f.Op}
+		switch f.Op {
+		case entities.FILTER_EQ, entities.FILTER_GT, entities.FILTER_GTE, entities.FILTER_LT, entities.FILTER_LTE:
+			c.values = []gjson.Result{toResult(f.Value)}
+		case entities.FILTER_IN:
+			for _, v := range f.Values {
+				c.values = append(c.values, toResult(v))
+			}
+		default:
+			return nil, errors.New(fmt.Sprintf("不支持的过滤操作[%s]", f.Op))
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func toResult(value interface{}) gjson.Result {
+	buff, err := json.Marshal(value)
+	if err != nil {
+		return gjson.Result{}
+	}
+	return gjson.ParseBytes(buff)
+}
+
+/*
+判断记录是否满足全部过滤条件,字段不存在时不满足
+*/
+func matchFilters(value string, filters []valueFilter) bool {
+	for _, f := range filters {
+		if !f.match(gjson.Get(value, f.path)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f valueFilter) match(actual gjson.Result) bool {
+	if !actual.Exists() {
+		return false
+	}
+	switch f.op {
+	case entities.FILTER_EQ, entities.FILTER_IN:
+		for _, v := range f.values {
+			if !actual.Less(v, false) && !v.Less(actual, false) {
+				return true
+			}
+		}
+		return false
+	}
+	target := f.values[0]
+	if actual.Type != target.Type {
+		return false
+	}
+	switch f.op {
+	case entities.FILTER_GT:
+		return target.Less(actual, false)
+	case entities.FILTER_GTE:
+		return !actual.Less(target, false)
+	case entities.FILTER_LT:
+		return actual.Less(target, false)
+	case entities.FILTER_LTE:
+		return !target.Less(actual, false)
+	}
+	return false
+}
+
+/*
+二级索引只支持以点分隔的普通路径,不支持通配符、数组下标等gjson语法
+*/
+func isPlainPath(path string) bool {
+	return len(path) > 0 && !strings.ContainsAny(path, "*?#|@\\!=<>%")
+}
+
+/*
+构造二级索引查找使用的JSON文本,使gjson路径path的值为value
+*/
+func filterPivot(path string, value gjson.Result) string {
+	var obj interface{} = value.Value()
+	parts := strings.Split(path, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		obj = map[string]interface{}{parts[i]: obj}
+	}
+	buff, _ := json.Marshal(obj)
+	return string(buff)
+}
+
+/*
+为过滤字段创建二级索引
+*/
+func createFilterIndexes(db *memdb.DB, paths []string) error {
+	for _, path := range paths {
+		if !isPlainPath(path) {
+			return errors.New(fmt.Sprintf("过滤字段[%s]不能创建二级索引", path))
+		}
+		err := db.CreateIndex(filterIndexPrefix+path, "*", memdb.IndexJSON(path))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+根据已创建二级索引的等值过滤条件查找满足条件的记录主键,多个条件取交集,
+没有可用的二级索引时返回nil,表示不限制
+*/
+func filterKeys(db *memdb.DB, indexed map[string]bool, filters []valueFilter) map[string]bool {
+	var result map[string]bool
+	for _, f := range filters {
+		if !indexed[f.path] || (f.op != entities.FILTER_EQ && f.op != entities.FILTER_IN) {
+			continue
+		}
+		keys := make(map[string]bool)
+		db.View(func(tx *memdb.Tx) error {
+			for _, v := range f.values {
+				tx.AscendEqual(filterIndexPrefix+f.path, filterPivot(f.path, v), func(key, value string) bool {
+					if result == nil || result[key] {
+						keys[key] = true
+					}
+					return true
+				})
+			}
+			return nil
+		})
+		result = keys
+	}
+	return result
+}
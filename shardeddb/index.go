@@ -2,8 +2,20 @@ package shardeddb
 
 import (
 	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
 	"github.com/xp/shorttext-db/parse"
 	"github.com/xp/shorttext-db/trie"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*
+BM25评分参数,k1控制词频饱和度,b控制文本长度归一化程度
+*/
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
 )
 
 /*
@@ -11,84 +23,470 @@ import (
 */
 type Index interface {
 	parse.IParse
+	//对第一个索引字段创建索引
 	Create(prefix string, key string) error
-	Find(keyWords []config.Text, length int) (config.RatioSet, error)
+	//对多个索引字段创建索引,values的键为字段的gjson路径
+	CreateFields(values map[string]string, key string) error
+	Find(keyWords []config.Text, length int, opts *entities.FindOptions) (config.RatioSet, error)
+	Rank(keyWords []config.Text, length int, opts *entities.FindOptions) (config.RankList, error)
+	//把关键字在各索引字段中的BM25统计信息累加到stats中
+	Stats(keyWords []config.Text, opts *entities.FindOptions, stats *entities.TermStats)
+	//删除记录ID与所有关键字的关联
+	Remove(key string)
+	//回收已标记为无效的关联,返回回收的数量
+	Compact() int
+	Clear()
+	//所有索引字段的关键字数量
+	TermCount() int
 }
 
 func NewIndex() Index {
+	return NewFieldsIndex(nil)
+}
+
+/*
+创建多字段索引,fields为空时只索引desc字段
+*/
+func NewFieldsIndex(fields []config.IndexField) Index {
+	if len(fields) == 0 {
+		fields = []config.IndexField{{Path: config.GJSON_FIELD_DESC, Weight: 1}}
+	}
 	k := &keywordIndex{}
 	k.parser = parse.NewParser()
-	k.dictionary = trie.NewTrie()
+	k.synonyms = parse.GetSynonymDictionary()
+	k.fields = make([]*fieldIndex, 0, len(fields))
+	for _, f := range fields {
+		k.fields = append(k.fields, newFieldIndex(f))
+	}
 	return k
 }
 
 type keywordIndex struct {
-	parser     parse.IParse
+	parser parse.IParse
+	//查找时用于扩展关键字的同义词词典
+	synonyms *parse.SynonymDictionary
+	fields []*fieldIndex
+	ratio  float32
+	mu     sync.RWMutex
+}
+
+/*
+单个字段的倒排索引
+*/
+type fieldIndex struct {
+	path       string
+	weight     float32
 	dictionary *trie.Trie
-	ratio      float32
+	//记录ID对应的关键字数量,用于计算文档总数和平均长度
+	docLens  map[string]int
+	totalLen int
+}
+
+func newFieldIndex(field config.IndexField) *fieldIndex {
+	f := &fieldIndex{}
+	f.path = field.Path
+	f.weight = field.Weight
+	if f.weight <= 0 {
+		f.weight = 1
+	}
+	f.dictionary = trie.NewTrie()
+	f.docLens = make(map[string]int)
+	return f
+}
+
+/*
+关键字在索引中命中的记录,term为索引中的关键字,weight为命中的权重,精确命中为1,同义词命中为同义词权重,模糊命中按编辑距离降低
+*/
+type termHit struct {
+	term     string
+	postings map[string]bool
+	weight   float32
 }
 
 /*
- 根据关键字查找记录，并记录命中关键字的utf8字符长度
+查找每个关键字及其同义词命中的记录,同义词命中的权重为synonymWeight,
+关键字和同义词都未命中时按编辑距离进行模糊查找
 */
-func (k *keywordIndex) findOriginalItems(keyWords []config.Text) config.TextSet {
-	result := make(config.TextSet)
-	for _, word := range keyWords {
-		dataItem, found := k.dictionary.Find(trie.Prefix(word))
-		item := dataItem.(map[string]bool)
+func (f *fieldIndex) resolve(keyWords []config.Text, fuzzy int, synonyms *parse.SynonymDictionary, synonymWeight float32) [][]termHit {
+	result := make([][]termHit, len(keyWords))
+	for i, word := range keyWords {
+		dataItem, found := f.dictionary.Find(trie.Prefix(word))
 		if found {
-			for itemKey, flag := range item {
+			result[i] = []termHit{{term: string(word), postings: dataItem.(map[string]bool), weight: 1}}
+		}
+		if synonyms != nil {
+			for _, synonym := range synonyms.Synonyms(string(word)) {
+				if dataItem, ok := f.dictionary.Find(trie.Prefix(synonym)); ok {
+					result[i] = append(result[i], termHit{term: synonym, postings: dataItem.(map[string]bool), weight: synonymWeight})
+				}
+			}
+		}
+		if len(result[i]) > 0 {
+			continue
+		}
+		distance := fuzzyDistance(word, fuzzy)
+		if distance == 0 {
+			continue
+		}
+		for _, item := range f.dictionary.FindFuzzy(trie.Prefix(word), distance) {
+			hit := termHit{term: string(item.Key), postings: item.Item.(map[string]bool), weight: 1 / float32(1+item.Distance)}
+			result[i] = append(result[i], hit)
+		}
+	}
+	return result
+}
+
+/*
+根据关键字长度限制编辑距离，避免短关键字模糊匹配到大量无关记录
+*/
+func fuzzyDistance(word config.Text, fuzzy int) int {
+	distance := len(word) / 3
+	if distance > fuzzy {
+		distance = fuzzy
+	}
+	return distance
+}
+
+/*
+ 根据关键字查找记录，并记录命中关键字的utf8字符长度(模糊命中按权重折算)、命中关键字的个数和命中的索引关键字
+*/
+func (f *fieldIndex) findOriginalItems(keyWords []config.Text, hits [][]termHit) (config.RatioSet, config.TextSet, map[string][]string) {
+	result := make(config.RatioSet)
+	counts := make(config.TextSet)
+	terms := make(map[string][]string)
+	for i, word := range keyWords {
+		//同一关键字多次模糊命中同一记录时，取权重最大的一次
+		weights := make(map[string]float32)
+		for _, hit := range hits[i] {
+			for itemKey, flag := range hit.postings {
 				//当关键字关联的记录ID为无效状态的时候，直接忽略
 				if !flag {
 					continue
 				}
-				w, ok := result[itemKey]
-				if ok {
-					result[itemKey] = w + len(word)
-				} else {
-					result[itemKey] = len(word)
+				if hit.weight > weights[itemKey] {
+					weights[itemKey] = hit.weight
 				}
+				terms[itemKey] = append(terms[itemKey], hit.term)
 			}
 		}
+		for itemKey, w := range weights {
+			result[itemKey] = result[itemKey] + w*float32(len(word))
+			counts[itemKey] = counts[itemKey] + 1
+		}
 	}
-	return result
+	return result, counts, terms
 }
 
 /*
-关键字命中超过50%的记录，被提取出来。
+根据匹配模式提取命中的记录和记录命中的索引关键字，缺省提取关键字命中超过50%的记录。
 */
-func (k *keywordIndex) Find(keyWords []config.Text, length int) (config.RatioSet, error) {
-	orginalItems := k.findOriginalItems(keyWords)
+func (f *fieldIndex) find(keyWords []config.Text, hits [][]termHit, length int, opts *entities.FindOptions) (config.RatioSet, map[string][]string) {
+	orginalItems, counts, terms := f.findOriginalItems(keyWords, hits)
 	result := make(config.RatioSet)
+	mode := opts.GetMode()
+	minRatio := opts.GetMinRatio()
 	for k, v := range orginalItems {
-		ratio := float32(v) / float32(length)
-		if ratio < 0.5 {
-			continue
+		ratio := v / float32(length)
+		switch mode {
+		case entities.MATCH_ALL:
+			if counts[k] < len(keyWords) {
+				continue
+			}
+		case entities.MATCH_ANY, entities.MATCH_PHRASE:
+			//短语匹配先按任意关键字召回，再由存储层根据原文过滤
+		default:
+			if ratio < minRatio {
+				continue
+			}
 		}
 		result[k] = ratio
 	}
+	return result, terms
+}
+
+/*
+计算BM25评分:
+ score = Σ weight * idf(t) * tf * (k1 + 1) / (tf + k1 * (1 - b + b * dl / avgdl))
+由于分词结果已去重，同一关键字在一条记录中的词频为1。
+stats不为空时按汇总的记录数、平均长度和命中记录数计算,使不同分库的评分可以比较
+*/
+func (f *fieldIndex) score(hits [][]termHit, found config.RatioSet, stats *entities.TermStats) map[string]float32 {
+	scores := make(map[string]float32, len(found))
+	docCount := len(f.docLens)
+	totalLen := f.totalLen
+	global := stats != nil && stats.Docs[f.path] >= docCount && docCount > 0
+	if global {
+		docCount = stats.Docs[f.path]
+		totalLen = stats.Lens[f.path]
+	}
+	if docCount == 0 {
+		return scores
+	}
+	avgLen := float64(totalLen) / float64(docCount)
+	if avgLen == 0 {
+		avgLen = 1
+	}
+	const tf = 1.0
+	for _, wordHits := range hits {
+		for _, hit := range wordHits {
+			df := hit.df()
+			if df == 0 {
+				continue
+			}
+			//汇总统计中没有的关键字按本分库的命中记录数计算
+			if global && stats.DF[f.path][hit.term] > df {
+				df = stats.DF[f.path][hit.term]
+			}
+			idf := math.Log(1 + (float64(docCount)-float64(df)+0.5)/(float64(df)+0.5))
+			for itemKey, flag := range hit.postings {
+				if !flag {
+					continue
+				}
+				if _, ok := found[itemKey]; !ok {
+					continue
+				}
+				dl := float64(f.docLens[itemKey])
+				norm := tf + bm25K1*(1-bm25B+bm25B*dl/avgLen)
+				scores[itemKey] += hit.weight * float32(idf*tf*(bm25K1+1)/norm)
+			}
+		}
+	}
+	return scores
+}
+
+//关键字命中的有效记录数
+func (h *termHit) df() int {
+	df := 0
+	for _, flag := range h.postings {
+		if flag {
+			df++
+		}
+	}
+	return df
+}
+
+/*
+把字段的记录数、关键字总数和命中关键字的记录数累加到stats中,同一关键字只统计一次
+*/
+func (f *fieldIndex) stats(hits [][]termHit, stats *entities.TermStats) {
+	stats.Docs[f.path] = stats.Docs[f.path] + len(f.docLens)
+	stats.Lens[f.path] = stats.Lens[f.path] + f.totalLen
+	checker := make(map[string]bool)
+	for _, wordHits := range hits {
+		for _, hit := range wordHits {
+			if checker[hit.term] {
+				continue
+			}
+			checker[hit.term] = true
+			stats.AddDF(f.path, hit.term, hit.df())
+		}
+	}
+}
+
+/*
+删除记录ID与关键字的关联，不再关联任何记录的关键字从字典树中删除
+*/
+func (f *fieldIndex) remove(key string) {
+	f.dictionary.RemoveItem(key)
+	if l, ok := f.docLens[key]; ok {
+		f.totalLen = f.totalLen - l
+		delete(f.docLens, key)
+	}
+}
+
+/*
+建立关键字与记录ID的关联
+*/
+func (f *fieldIndex) add(parsed []config.Text, key string) {
+	for _, v := range parsed {
+		f.dictionary.Append(trie.Prefix(v), key, true)
+	}
+	f.docLens[key] = len(parsed)
+	f.totalLen = f.totalLen + len(parsed)
+}
+
+func (f *fieldIndex) clear() {
+	f.dictionary = trie.NewTrie()
+	f.docLens = make(map[string]int)
+	f.totalLen = 0
+}
+
+/*
+根据匹配模式提取命中的记录，多个字段命中时取最大的命中占比
+*/
+func (k *keywordIndex) Find(keyWords []config.Text, length int, opts *entities.FindOptions) (config.RatioSet, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	result := make(config.RatioSet)
+	for _, f := range k.fields {
+		hits := f.resolve(keyWords, opts.GetFuzzy(), k.synonyms, config.GetConfig().GetSynonymWeight())
+		found, _ := f.find(keyWords, hits, length, opts)
+		for key, ratio := range found {
+			if ratio > result[key] {
+				result[key] = ratio
+			}
+		}
+	}
 	return result, nil
 }
 
+/*
+对命中的记录进行BM25评分，按评分从高到低返回前opts.Limit条,Limit小于等于0时返回全部。
+记录的评分为各命中字段评分乘以字段权重之和
+*/
+func (k *keywordIndex) Rank(keyWords []config.Text, length int, opts *entities.FindOptions) (config.RankList, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	items := make(map[string]*config.RankItem)
+	for _, f := range k.fields {
+		hits := f.resolve(keyWords, opts.GetFuzzy(), k.synonyms, config.GetConfig().GetSynonymWeight())
+		found, terms := f.find(keyWords, hits, length, opts)
+		if len(found) == 0 {
+			continue
+		}
+		scores := f.score(hits, found, opts.GetStats())
+		for key, ratio := range found {
+			item, ok := items[key]
+			if !ok {
+				item = &config.RankItem{Key: key}
+				items[key] = item
+			}
+			if ratio > item.Ratio {
+				item.Ratio = ratio
+			}
+			item.Score = item.Score + f.weight*scores[key]
+			item.Fields = append(item.Fields, f.path)
+			item.Terms = appendTerms(item.Terms, terms[key])
+		}
+	}
+	result := make(config.RankList, 0, len(items))
+	for _, item := range items {
+		result = append(result, *item)
+	}
+	sort.Sort(result)
+	limit := opts.GetLimit()
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+//合并多个字段命中的关键字,忽略重复的关键字
+func appendTerms(result []string, terms []string) []string {
+	for _, t := range terms {
+		exists := false
+		for _, r := range result {
+			if r == t {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
 /*
 创建索引
 */
 func (k *keywordIndex) Create(prefix string, key string) error {
-	parsed, err := k.parser.Parse(prefix)
-	if err != nil {
-		return err
+	return k.CreateFields(map[string]string{k.fields[0].path: prefix}, key)
+}
+
+/*
+对多个字段创建索引，先删除记录ID已存在的关联，未提供值的字段不再索引该记录
+*/
+func (k *keywordIndex) CreateFields(values map[string]string, key string) error {
+	parsedFields := make(map[string][]config.Text, len(values))
+	for path, value := range values {
+		parsed, err := k.parser.ParseForIndex(value)
+		if err != nil {
+			return err
+		}
+		parsedFields[path] = parsed
 	}
-	//先删除已存在数据主键
-	k.dictionary.DelItem(key)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, f := range k.fields {
+		//先删除已存在数据主键
+		f.remove(key)
+		if parsed, ok := parsedFields[f.path]; ok {
+			f.add(parsed, key)
+		}
+	}
+	return nil
+}
 
-	//建立关键字与数据主键的关联
-	for _, v := range parsed {
-		k.dictionary.Append(trie.Prefix(v), key, true)
+/*
+删除记录ID的索引
+*/
+func (k *keywordIndex) Remove(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, f := range k.fields {
+		f.remove(key)
 	}
+}
 
-	return nil
+/*
+回收各字段中已标记为无效的关联
+*/
+func (k *keywordIndex) Compact() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	count := 0
+	for _, f := range k.fields {
+		count = count + f.dictionary.CompactItems()
+	}
+	return count
+}
+
+/*
+统计关键字及其同义词、模糊命中的关键字在各索引字段中的BM25统计信息,累加到stats中
+*/
+func (k *keywordIndex) Stats(keyWords []config.Text, opts *entities.FindOptions, stats *entities.TermStats) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, f := range k.fields {
+		hits := f.resolve(keyWords, opts.GetFuzzy(), k.synonyms, config.GetConfig().GetSynonymWeight())
+		f.stats(hits, stats)
+	}
+}
+
+/*
+获得所有索引字段的关键字数量，需要遍历字典树
+*/
+func (k *keywordIndex) TermCount() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	count := 0
+	for _, f := range k.fields {
+		count = count + f.dictionary.Size()
+	}
+	return count
+}
+
+/*
+清空索引，重建索引之前调用
+*/
+func (k *keywordIndex) Clear() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, f := range k.fields {
+		f.clear()
+	}
 }
 
 func (k *keywordIndex) Parse(text string) ([]config.Text, error) {
 	return k.parser.Parse(text)
 }
+
+func (k *keywordIndex) ParseForIndex(text string) ([]config.Text, error) {
+	return k.parser.ParseForIndex(text)
+}
+
+func (k *keywordIndex) Normalize(text string) string {
+	return k.parser.Normalize(text)
+}
@@ -0,0 +1,441 @@
+package shardeddb
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/filedb"
+	"github.com/xp/shorttext-db/gjson"
+	"github.com/xp/shorttext-db/network"
+	"github.com/xp/shorttext-db/shardedkv"
+	"github.com/xp/shorttext-db/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+批量导入的文件格式
+*/
+const (
+	LOAD_FORMAT_JSONL = "jsonl"
+	LOAD_FORMAT_CSV   = "csv"
+)
+
+//批量导入时每批读取的记录数
+const DEFAULT_LOAD_BATCH_SIZE = 1000
+
+//导入结果中最多保留的错误数量,超出的错误只计数
+const maxLoadErrors = 1000
+
+//JSON Lines单行的最大长度
+const maxLoadLineSize = 4 * 1024 * 1024
+
+/*
+导入失败的记录,Line为记录在文件中的行号,Key为分配的主键,解析失败时为0
+*/
+type LoadError struct {
+	Line    int
+	Key     uint64
+	Message string
+}
+
+/*
+批量导入的结果,Read为读取的记录数,Loaded为保存成功的记录数,Failed为失败的记录数
+*/
+type LoadResult struct {
+	Read   int
+	Loaded int
+	Failed int
+	Errors []LoadError
+}
+
+func (r *LoadResult) addError(line int, key uint64, msg string) {
+	r.Failed++
+	if len(r.Errors) < maxLoadErrors {
+		r.Errors = append(r.Errors, LoadError{Line: line, Key: key, Message: msg})
+	}
+}
+
+//批量发送消息的节点
+type batchSender interface {
+	sendBatch(messages []*network.Message) (map[string]string, error)
+}
+
+//待导入的记录
+type loadRecord struct {
+	line int
+	key  uint64
+	text string
+	//导入导出的记录时原样保存，不增加id字段
+	raw bool
+}
+
+/*
+批量导入器，按批从序列服务预留主键，按分片分组后每个节点发送一个BatchMessage
+*/
+type BulkLoader struct {
+	dbName  string
+	chooser api.Chooser
+	seq     filedb.SequenceSvc
+	senders map[string]batchSender
+	//数据库的索引字段
+	fields []config.IndexField
+	//每条记录的副本数量和写入成功需要的副本数量
+	replicas int
+	quorum   int
+	//每批读取的记录数
+	BatchSize int
+	//每批导入完成后调用,参数为截至当前的导入结果
+	Progress func(result *LoadResult)
+}
+
+/*
+创建批量导入器，通过代理服务器访问集群中的各个节点
+*/
+func NewBulkLoader(dbName string) (*BulkLoader, error) {
+	cfg := config.GetConfig()
+	shards, err := newShards(dbName)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := filedb.NewSequenceProxy(cfg.SequenceServer)
+	if err != nil {
+		return nil, err
+	}
+	chooser := newChooser(cfg)
+	senders := make(map[string]batchSender, len(shards))
+	for _, shard := range shards {
+		senders[shard.Name] = shard.Backend.(*dbNodeClient)
+	}
+	return newBulkLoader(dbName, chooser, seq, senders), nil
+}
+
+func newBulkLoader(dbName string, chooser api.Chooser, seq filedb.SequenceSvc, senders map[string]batchSender) *BulkLoader {
+	l := &BulkLoader{}
+	l.dbName = dbName
+	l.chooser = chooser
+	l.seq = seq
+	l.senders = senders
+	l.fields = config.GetConfig().GetIndexFields(dbName)
+	l.replicas = config.GetConfig().GetReplicas()
+	l.quorum = writeQuorum(config.GetConfig().GetWriteQuorum())
+	l.BatchSize = DEFAULT_LOAD_BATCH_SIZE
+	names := make([]string, 0, len(senders))
+	for _, card := range config.GetCase().GetCardList() {
+		if _, ok := senders[card.Name]; ok {
+			names = append(names, card.Name)
+		}
+	}
+	chooser.SetBuckets(names)
+	return l
+}
+
+/*
+导入文件,扩展名为.csv时按CSV格式导入,否则按JSON Lines格式导入
+*/
+func (l *BulkLoader) LoadFile(path string) (*LoadResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.ToLower(filepath.Ext(path)) == "."+LOAD_FORMAT_CSV {
+		return l.LoadCSV(file)
+	}
+	return l.LoadJSONL(file)
+}
+
+/*
+导入JSON Lines格式的记录,每行一个JSON对象,空行被忽略
+*/
+func (l *BulkLoader) LoadJSONL(r io.Reader) (*LoadResult, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLoadLineSize)
+	line := 0
+	return l.load(func(result *LoadResult) (*loadRecord, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if len(text) == 0 {
+				continue
+			}
+			if !gjson.Valid(text) || !gjson.Parse(text).IsObject() {
+				result.Read++
+				result.addError(line, 0, "记录不是有效的JSON对象")
+				continue
+			}
+			return &loadRecord{line: line, text: text}, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	})
+}
+
+/*
+导入CSV格式的记录,第一行为字段名,每行转换为以字段名为键的JSON对象
+*/
+func (l *BulkLoader) LoadCSV(r io.Reader) (*LoadResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("读取CSV字段名失败:%s", err.Error()))
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	line := 1
+	return l.load(func(result *LoadResult) (*loadRecord, error) {
+		for {
+			fields, err := reader.Read()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			line++
+			if err != nil {
+				if _, ok := err.(*csv.ParseError); !ok {
+					return nil, err
+				}
+				result.Read++
+				result.addError(line, 0, err.Error())
+				continue
+			}
+			if len(fields) != len(header) {
+				result.Read++
+				result.addError(line, 0, fmt.Sprintf("字段数量[%d]与字段名数量[%d]不一致", len(fields), len(header)))
+				continue
+			}
+			values := make(map[string]string, len(header))
+			for i, name := range header {
+				values[name] = fields[i]
+			}
+			buff, err := json.Marshal(values)
+			if err != nil {
+				result.Read++
+				result.addError(line, 0, err.Error())
+				continue
+			}
+			return &loadRecord{line: line, text: string(buff)}, nil
+		}
+	})
+}
+
+/*
+导入Exporter导出的JSON Lines文件,保留原有主键,不从序列服务预留主键。
+导入完成后序列服务的当前值小于导入的最大主键时，把序列的起始值设置为最大主键
+*/
+func (l *BulkLoader) Import(r io.Reader) (*LoadResult, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLoadLineSize)
+	line := 0
+	var maxKey uint64
+	result, err := l.load(func(result *LoadResult) (*loadRecord, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if len(text) == 0 {
+				continue
+			}
+			exported := &entities.ExportRecord{}
+			if err := json.Unmarshal([]byte(text), exported); err != nil {
+				result.Read++
+				result.addError(line, 0, err.Error())
+				continue
+			}
+			key, err := strconv.ParseUint(exported.Key, 10, 64)
+			if err != nil {
+				result.Read++
+				result.addError(line, 0, fmt.Sprintf("主键[%s]不是有效的数字", exported.Key))
+				continue
+			}
+			if key > maxKey {
+				maxKey = key
+			}
+			return &loadRecord{line: line, key: key, text: importValue(exported.Value), raw: true}, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	})
+	if err != nil || maxKey == 0 {
+		return result, err
+	}
+	next, err := l.seq.Reserve(l.dbName, 1)
+	if err != nil {
+		return result, errors.New(fmt.Sprintf("更新序列失败:%s", err.Error()))
+	}
+	if next <= maxKey {
+		err = l.seq.SetStart(l.dbName, maxKey)
+	}
+	return result, err
+}
+
+/*
+按批读取记录并导入，next返回io.EOF时结束
+*/
+func (l *BulkLoader) load(next func(result *LoadResult) (*loadRecord, error)) (*LoadResult, error) {
+	t := utils.NewTimer()
+	batchSize := l.BatchSize
+	if batchSize <= 0 {
+		batchSize = DEFAULT_LOAD_BATCH_SIZE
+	}
+	result := &LoadResult{}
+	batch := make([]*loadRecord, 0, batchSize)
+	for {
+		record, err := next(result)
+		if err != nil && err != io.EOF {
+			return result, err
+		}
+		if record != nil {
+			result.Read++
+			batch = append(batch, record)
+		}
+		if len(batch) == batchSize || (err == io.EOF && len(batch) > 0) {
+			if err := l.loadBatch(batch, result); err != nil {
+				return result, err
+			}
+			batch = batch[:0]
+			if l.Progress != nil {
+				l.Progress(result)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	logger.Infof("数据库[%s]批量导入完成,读取:%d,成功:%d,失败:%d,Time:%.2f\n", l.dbName, result.Read, result.Loaded, result.Failed, t.Stop())
+	return result, nil
+}
+
+/*
+为一批记录预留主键，按分片分组后并发发送给各个节点，同一批中的主键不能重复。
+配置了多个副本时发送给所有副本所在的节点,写入成功的副本数量达到配置的数量时导入成功
+*/
+func (l *BulkLoader) loadBatch(batch []*loadRecord, result *LoadResult) error {
+	//导入的记录已有主键时不预留
+	if batch[0].key == 0 {
+		start, err := l.seq.Reserve(l.dbName, uint64(len(batch)))
+		if err != nil {
+			return errors.New(fmt.Sprintf("预留主键失败:%s", err.Error()))
+		}
+		for i, record := range batch {
+			record.key = start + uint64(i)
+		}
+	}
+	groups := make(map[string][]*network.Message)
+	records := make(map[string]*loadRecord, len(batch))
+	//每条记录写入成功的副本数量和失败信息
+	acks := make(map[string]int, len(batch))
+	failures := make(map[string][]string)
+	for _, record := range batch {
+		strKey := strconv.FormatUint(record.key, 10)
+		records[strKey] = record
+		m := network.Message{}
+		m.Key = strKey
+		m.Text = record.text
+		m.Type = config.MSG_KV_TEXTSET
+		if !record.raw {
+			m.Text = withRecordId(record.text, strKey)
+		} else if !hasIndexFields(record.text, l.fields) {
+			//没有索引字段的记录导出前没有创建索引
+			m.Type = config.MSG_KV_SET
+		}
+		names, index := l.choose(record.key)
+		m.DBName = l.dbName + "_" + strconv.FormatUint(index, 10)
+		for _, shardName := range names {
+			replica := m
+			groups[shardName] = append(groups[shardName], &replica)
+		}
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for shardName, messages := range groups {
+		sender, ok := l.senders[shardName]
+		if !ok {
+			for _, m := range messages {
+				failures[m.Key] = append(failures[m.Key], fmt.Sprintf("分片[%s]不存在", shardName))
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(shardName string, sender batchSender, messages []*network.Message) {
+			defer wg.Done()
+			failed, err := sender.sendBatch(messages)
+			lock.Lock()
+			defer lock.Unlock()
+			for _, m := range messages {
+				switch {
+				case err != nil:
+					failures[m.Key] = append(failures[m.Key], fmt.Sprintf("分片[%s]导入失败:%s", shardName, err.Error()))
+				case len(failed[m.Key]) > 0:
+					failures[m.Key] = append(failures[m.Key], failed[m.Key])
+				default:
+					acks[m.Key]++
+				}
+			}
+		}(shardName, sender, messages)
+	}
+	wg.Wait()
+	for _, record := range batch {
+		strKey := strconv.FormatUint(record.key, 10)
+		names, _ := l.choose(record.key)
+		if acks[strKey] >= shardedkv.QuorumSize(l.quorum, len(names)) {
+			result.Loaded++
+			continue
+		}
+		result.addError(record.line, record.key, strings.Join(failures[strKey], ";"))
+	}
+	return nil
+}
+
+/*
+获得记录所有副本所在的分片和分库序号
+*/
+func (l *BulkLoader) choose(key uint64) ([]string, uint64) {
+	shardName, index := l.chooser.Choose(key)
+	if rc, ok := l.chooser.(api.ReplicaChooser); ok && l.replicas > 1 {
+		return rc.ChooseReplicas(key, l.replicas), index
+	}
+	return []string{shardName}, index
+}
+
+func hasIndexFields(text string, fields []config.IndexField) bool {
+	if !gjson.Parse(text).IsObject() {
+		return false
+	}
+	for _, f := range fields {
+		if len(gjson.Get(text, f.Path).String()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+记录没有id字段时以主键作为id
+*/
+func withRecordId(text string, key string) string {
+	if gjson.Get(text, config.GJSON_FIELD_ID).Exists() {
+		return text
+	}
+	id, _ := json.Marshal(map[string]string{config.GJSON_FIELD_ID: key})
+	body := strings.TrimSpace(text)
+	body = strings.TrimSpace(body[1:])
+	if body == "}" {
+		return string(id)
+	}
+	return string(id[:len(id)-1]) + "," + body
+}
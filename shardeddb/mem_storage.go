@@ -7,23 +7,74 @@ import (
 	"github.com/xp/shorttext-db/entities"
 	"github.com/xp/shorttext-db/gjson"
 	"github.com/xp/shorttext-db/memdb"
+	"github.com/xp/shorttext-db/shardedkv"
 	"github.com/xp/shorttext-db/utils"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
 )
 
+/*
+索引状态，数据库从文件加载后需要重建索引，重建过程中查找只能命中已建立索引的记录，返回ErrIndexPartial
+*/
+const (
+	INDEX_STATUS_EMPTY    = 0
+	INDEX_STATUS_BUILDING = 1
+	INDEX_STATUS_READY    = 2
+)
+
+var ErrIndexNotReady = errors.New("索引尚未就绪")
+
+//索引重建过程中查找返回已命中的记录和该错误
+var ErrIndexPartial = errors.New("索引正在重建,查找结果只包含已建立索引的记录")
+
+//重建索引时每批处理的记录数,每批单独加读锁,批之间不阻塞写入
+const rebuildBatchSize = 1000
+
+//旧版本在数据库中保存记录数量的键,打开数据库时删除
+const keyCountKey = "key_count"
+
+//后台回收索引中无效关联的时间间隔
+const indexCompactInterval = 10 * time.Minute
+
+//分页查找时排序结果的缓存时间和缓存的查询数量
+const (
+	pageCacheTTL  = time.Minute
+	pageCacheSize = 128
+)
+
+//分页查找时缓存的排序结果
+type rankCache struct {
+	ranked  config.RankList
+	expires time.Time
+}
+
 type IMemStorage interface {
 	Get(key string) (string, error)
 	Set(key string, text string) error
-	SetWithIndex(key string, text string, prefixName string) error
-	Find(text string) ([]entities.Record, error)
+	SetWithIndex(key string, text string) error
+	SetWithTTL(key string, text string, ttl time.Duration) error
+	Find(text string, opts *entities.FindOptions) ([]entities.Record, error)
+	//把查询文本的关键字在本分库索引中的BM25统计信息累加到stats中
+	TermStats(text string, opts *entities.FindOptions, stats *entities.TermStats) error
 	Delete(key string) error
 	Save() error
 	Open() error
 	Close() error
 	GetKeyCount() int
+	IndexStatus() int
+	Reindex(words []string) int
+	FindPage(text string, opts *entities.FindOptions, pos pagePos, size int) ([]entities.Record, []pagePos, pagePos, error)
+	FindSimilar(text string, threshold float32) ([]entities.SimilarRecord, error)
+	Export(after string, limit int, withTerms bool) (*entities.ExportPage, error)
+	Scan(start uint64, end uint64, limit int) (*entities.ScanPage, error)
+	Stats() entities.DBStats
 }
 
 //对内存数据库的封装,提供简易接口
@@ -32,63 +83,248 @@ type memStorage struct {
 	db    *memdb.DB
 	path  string
 	index Index
-	count int
+	//记录的MinHash签名,用于查找近似重复的记录
+	similar *similarIndex
+	//最近一次生成快照的时间,UnixNano
+	lastSave int64
+	//索引字段
+	fields []config.IndexField
+	//创建了二级索引的过滤字段
+	filterPaths map[string]bool
+	//索引状态,见INDEX_STATUS_*
+	indexStatus int32
+	//分页查找的排序结果缓存,键为查询条件
+	pages    map[string]*rankCache
+	pageLock sync.Mutex
+	//有多个副本时删除记录写入的墓碑数量,不计入记录数量
+	tombstones int64
 }
 
-func newMemStorage(id int, path string, name string) (*memStorage, error) {
+func newMemStorage(id int, path string, name string, fields []config.IndexField, filterPaths []string) (*memStorage, error) {
 	m := &memStorage{}
 	m.name = name
 	m.path = path + "/" + strconv.Itoa(id) + "/" + m.name + ".db"
 	m.name = name
+	m.fields = fields
+	m.filterPaths = make(map[string]bool, len(filterPaths))
+	for _, p := range filterPaths {
+		m.filterPaths[p] = true
+	}
+	m.pages = make(map[string]*rankCache)
+	m.index = NewFieldsIndex(fields)
+	m.similar = newSimilarIndex()
 	err := m.Open()
 	if err != nil {
 		return nil, err
 	}
-	m.index = NewIndex()
 
 	go m.persistent()
+	go m.compactIndex()
 	return m, nil
 }
 
+/*
+定时回收索引中已标记为无效的关联
+*/
+func (m *memStorage) compactIndex() {
+	heartbeat := time.NewTicker(indexCompactInterval)
+	defer heartbeat.Stop()
+	for range heartbeat.C {
+		if m.IndexStatus() != INDEX_STATUS_READY {
+			continue
+		}
+		if count := m.index.Compact(); count > 0 {
+			logger.Infof("数据库[%s]回收索引无效关联:%d\n", m.name, count)
+		}
+	}
+}
+
+/*
+定时生成快照,把追加日志压缩为每条记录一条命令
+*/
 func (m *memStorage) persistent() {
-	heartbeat := time.NewTicker(time.Second * 3000)
+	heartbeat := time.NewTicker(time.Second * time.Duration(config.GetConfig().GetSnapshotInterval()))
 	defer heartbeat.Stop()
 	for range heartbeat.C {
-		m.Save()
+		if err := m.Save(); err != nil {
+			logger.Errorf("数据库[%s]生成快照失败:%s\n", m.name, err.Error())
+		}
+	}
+}
+
+func syncPolicy(policy string) memdb.SyncPolicy {
+	switch policy {
+	case config.SYNC_POLICY_NEVER:
+		return memdb.Never
+	case config.SYNC_POLICY_ALWAYS:
+		return memdb.Always
 	}
+	return memdb.EverySecond
 }
+
+/*
+打开数据库，数据库以追加日志的方式写入文件，打开时重放日志恢复数据，然后在后台重建索引。
+日志末尾因写入中断而不完整的命令会被截掉
+*/
 func (m *memStorage) Open() error {
 	var err error
-	var fs *os.File
-	m.db, err = memdb.Open(":memory:")
-	if !utils.IsExist(m.path) {
+	if m.db != nil {
+		m.db.Close()
+	}
+	if err = os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	m.db, err = memdb.Open(m.path)
+	if err != nil {
+		return err
+	}
+	var dbConfig memdb.Config
+	if err = m.db.ReadConfig(&dbConfig); err != nil {
+		return err
+	}
+	dbConfig.SyncPolicy = syncPolicy(config.GetConfig().GetSyncPolicy())
+	dbConfig.OnExpiredSync = m.onExpired
+	if err = m.db.SetConfig(dbConfig); err != nil {
+		return err
+	}
+	//二级索引在加载数据之前创建,加载时同时建立
+	paths := make([]string, 0, len(m.filterPaths))
+	for p := range m.filterPaths {
+		paths = append(paths, p)
+	}
+	if err = createFilterIndexes(m.db, paths); err != nil {
+		return err
+	}
+	m.index.Clear()
+	m.similar.clear()
+	m.removeLegacyCount()
+	atomic.StoreInt64(&m.tombstones, m.countTombstones())
+	if m.GetKeyCount() == 0 {
+		atomic.StoreInt32(&m.indexStatus, INDEX_STATUS_READY)
 		return nil
 	}
-	fs, err = os.Open(m.path)
+	atomic.StoreInt32(&m.indexStatus, INDEX_STATUS_BUILDING)
+	go m.rebuildIndex()
+	return nil
+}
+
+/*
+根据已存储记录的索引字段重建索引，按主键顺序分批读取记录
+*/
+func (m *memStorage) rebuildIndex() error {
+	t := utils.NewTimer()
+	count, err := m.indexBatches(func(values map[string]string) bool {
+		return true
+	})
 	if err != nil {
+		atomic.StoreInt32(&m.indexStatus, INDEX_STATUS_EMPTY)
+		logger.Errorf("数据库[%s]重建索引失败:%s\n", m.name, err.Error())
 		return err
 	}
-	err = m.db.Load(fs)
-	return err
+	atomic.StoreInt32(&m.indexStatus, INDEX_STATUS_READY)
+	logger.Infof("数据库[%s]重建索引完成,记录数:%d,Time:%.2f\n", m.name, count, t.Stop())
+	return nil
+}
+
+/*
+按主键顺序分批读取记录,对索引字段满足filter的记录创建索引,返回创建索引的记录数。
+每批最多读取rebuildBatchSize条记录,批与批之间释放读锁,避免长时间阻塞写入
+*/
+func (m *memStorage) indexBatches(filter func(values map[string]string) bool) (int, error) {
+	count := 0
+	last := ""
+	started := false
+	for {
+		n := 0
+		err := m.db.View(func(tx *memdb.Tx) error {
+			return tx.AscendGreaterOrEqual("", last, func(key, value string) bool {
+				//上一批的最后一条记录已处理
+				if started && key == last {
+					return true
+				}
+				if n == rebuildBatchSize {
+					return false
+				}
+				last = key
+				n++
+				values := m.indexValues(value)
+				if len(values) == 0 || !filter(values) {
+					return true
+				}
+				if err := m.index.CreateFields(values, key); err != nil {
+					logger.Errorf("数据库[%s]重建索引失败[key:%s]:%s\n", m.name, key, err.Error())
+					return true
+				}
+				m.addSignature(key, values)
+				count++
+				return true
+			})
+		})
+		if err != nil {
+			return count, err
+		}
+		started = true
+		if n < rebuildBatchSize {
+			return count, nil
+		}
+	}
+}
+
+/*
+对索引字段包含words中任意一个词的记录重建索引，words为空时对全部记录重建索引，返回重建的记录数。
+用户词典变化后调用，按批读取记录,批与批之间释放读锁,重建过程中查找和写入仍然可用
+*/
+func (m *memStorage) Reindex(words []string) int {
+	upperWords := make([]string, 0, len(words))
+	for _, w := range words {
+		upperWords = append(upperWords, strings.ToUpper(w))
+	}
+	count, err := m.indexBatches(func(values map[string]string) bool {
+		return containsAnyWord(values, upperWords)
+	})
+	if err != nil {
+		logger.Errorf("数据库[%s]重建索引失败:%s\n", m.name, err.Error())
+	}
+	return count
+}
+
+func containsAnyWord(values map[string]string, upperWords []string) bool {
+	if len(upperWords) == 0 {
+		return true
+	}
+	for _, v := range values {
+		upperValue := strings.ToUpper(v)
+		for _, w := range upperWords {
+			if strings.Contains(upperValue, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+获得索引状态
+*/
+func (m *memStorage) IndexStatus() int {
+	return int(atomic.LoadInt32(&m.indexStatus))
 }
 
 func (m *memStorage) Close() error {
 	return m.db.Close()
 }
 
+/*
+生成快照：把当前数据写入临时文件，追加快照过程中的新写入后重命名为数据库文件，
+保存过程中断不会损坏原有文件
+*/
 func (m *memStorage) Save() error {
-	var (
-		err  error
-		file *os.File
-	)
-	file, err = os.Create(m.path)
-	defer file.Close()
-	if err != nil {
-		return err
+	err := m.db.Shrink()
+	if err == memdb.ErrShrinkInProcess {
+		return nil
 	}
-	err = m.db.Save(file)
-	if err != nil {
-		return err
+	if err == nil {
+		atomic.StoreInt64(&m.lastSave, time.Now().UnixNano())
 	}
 	return err
 }
@@ -105,82 +341,434 @@ func (m *memStorage) Get(key string) (string, error) {
 	return text, err
 }
 
+/*
+保存记录但不创建索引，覆盖已索引的记录时删除原有索引。
+删除记录写入的墓碑保留KVDBTombstoneTTL时间后自动删除
+*/
 func (m *memStorage) Set(key string, text string) error {
 	var err error
+	//在写入的事务中删除索引,与并发的SetWithIndex按顺序执行,避免删除其后创建的索引
 	err = m.db.Update(func(tx *memdb.Tx) error {
-		_, _, err := tx.Set(key, text, nil)
+		var opts *memdb.SetOptions
+		if shardedkv.IsTombstone(text) {
+			opts = &memdb.SetOptions{Expires: true, TTL: time.Duration(config.GetConfig().GetTombstoneTTL()) * time.Second}
+		}
+		prev, _ := tx.Get(key, true)
+		_, _, err := tx.Set(key, text, opts)
+		if err == nil {
+			m.index.Remove(key)
+			m.similar.remove(key)
+			m.countTombstone(prev, text)
+		}
 		return err
 	})
-	if err != nil {
-		m.increaseCount(1)
-	}
-
 	return err
 }
 
-func (m *memStorage) SetWithIndex(key string, text string, prefixName string) error {
+/*
+保存记录并对配置的索引字段创建索引，所有索引字段均为空时返回错误
+*/
+func (m *memStorage) SetWithIndex(key string, text string) error {
+	return m.setWithIndex(key, text, nil)
+}
+
+/*
+保存记录并创建索引，ttl之后记录自动删除，同时删除记录的索引
+*/
+func (m *memStorage) SetWithTTL(key string, text string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New(fmt.Sprintf("主键:%s, 错误信息:TTL必须大于0", key))
+	}
+	return m.setWithIndex(key, text, &memdb.SetOptions{Expires: true, TTL: ttl})
+}
+
+func (m *memStorage) setWithIndex(key string, text string, opts *memdb.SetOptions) error {
 	var err error
 	//if !gjson.Valid(text){
 	//	return errors.New(fmt.Sprintf("文本[%s]不符合Json格式",text))
 	//}
 	err = m.db.Update(func(tx *memdb.Tx) error {
-		_, _, err := tx.Set(key, text, nil)
+		prev, _ := tx.Get(key, true)
+		_, _, err := tx.Set(key, text, opts)
 		if err == nil {
-			desc := gjson.Get(text, prefixName).Str
-			if len(desc) == 0 {
-				return errors.New(fmt.Sprintf("主键:%s ,字段:%s, 错误信息:创建索引时字段为空", key, prefixName))
+			values := m.indexValues(text)
+			if len(values) == 0 {
+				return errors.New(fmt.Sprintf("主键:%s ,字段:%s, 错误信息:创建索引时字段为空", key, m.fieldPaths()))
 			}
 			if !utils.IsNil(m.index) {
-				err = m.index.Create(desc, key)
+				err = m.index.CreateFields(values, key)
+			}
+			if err == nil {
+				m.addSignature(key, values)
+				m.countTombstone(prev, text)
 			}
 		}
 		return err
 	})
-	if err == nil {
-		m.increaseCount(1)
-	}
-
 	return err
 }
 
+/*
+记录过期时在后台过期处理的事务中调用,删除记录及其索引
+*/
+func (m *memStorage) onExpired(key, value string, tx *memdb.Tx) error {
+	if _, err := tx.Delete(key); err != nil && err != memdb.ErrNotFound {
+		return err
+	}
+	m.index.Remove(key)
+	m.similar.remove(key)
+	m.countTombstone(value, "")
+	return nil
+}
+
+/*
+删除记录及其索引
+*/
 func (m *memStorage) Delete(key string) error {
 	err := m.db.Update(func(tx *memdb.Tx) error {
+		prev, _ := tx.Get(key, true)
 		_, err := tx.Delete(key)
+		//已过期但尚未被后台删除的记录返回ErrNotFound,同样需要删除索引
+		if err == nil || err == memdb.ErrNotFound {
+			m.index.Remove(key)
+			m.similar.remove(key)
+		}
+		if err == nil {
+			m.countTombstone(prev, "")
+		}
 		return err
 	})
+	return err
+}
+
+/*
+在写入事务中更新墓碑数量,prev为原有的文本,包含已过期但尚未删除的记录,text为新的文本,删除时为空
+*/
+func (m *memStorage) countTombstone(prev string, text string) {
+	if shardedkv.IsTombstone(prev) {
+		atomic.AddInt64(&m.tombstones, -1)
+	}
+	if shardedkv.IsTombstone(text) {
+		atomic.AddInt64(&m.tombstones, 1)
+	}
+}
+
+/*
+打开数据库时统计墓碑数量
+*/
+func (m *memStorage) countTombstones() int64 {
+	var count int64
+	m.db.View(func(tx *memdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			if shardedkv.IsTombstone(value) {
+				count++
+			}
+			return true
+		})
+	})
+	return count
+}
+
+/*
+查找文本命中的记录,按相关度从高到低返回前opts.Limit条,Limit小于等于0时返回全部。
+索引重建过程中返回已命中的记录和ErrIndexPartial
+*/
+func (m *memStorage) Find(text string, opts *entities.FindOptions) ([]entities.Record, error) {
+	status := m.IndexStatus()
+	if status == INDEX_STATUS_EMPTY {
+		return make([]entities.Record, 0), ErrIndexNotReady
+	}
+	ranked, err := m.rank(text, opts)
 	if err != nil {
-		m.increaseCount(-1)
+		return make([]entities.Record, 0), err
 	}
-	return err
+	result, _, _, err := m.collect(ranked, text, opts, 0, opts.GetLimit())
+	if err == nil && status == INDEX_STATUS_BUILDING {
+		err = ErrIndexPartial
+	}
+	return result, err
 }
 
 /*
-查找文本命中的记录
+把查询文本的关键字在本分库索引中的BM25统计信息累加到stats中,索引尚未建立时返回ErrIndexNotReady
 */
-func (m *memStorage) Find(text string) ([]entities.Record, error) {
-	var r entities.Record
-	result := make([]entities.Record, 0)
+func (m *memStorage) TermStats(text string, opts *entities.FindOptions, stats *entities.TermStats) error {
+	if m.IndexStatus() == INDEX_STATUS_EMPTY {
+		return ErrIndexNotReady
+	}
 	keyWords, err := m.index.Parse(text)
 	if err != nil {
-		return result, err
+		return err
+	}
+	m.index.Stats(keyWords, opts, stats)
+	return nil
+}
+
+/*
+分页查找,从排序结果的pos位置开始返回最多size条记录,以及每条记录之后的位置和本页之后的位置,
+没有更多记录时返回位置的Offset为-1。同一查询的排序结果缓存pageCacheTTL时间，翻页时不再重新计算,
+缓存过期后重新排序并从pos记录的最后一条记录之后继续,分页查找时忽略Limit。
+索引重建过程中不缓存排序结果,返回已命中的记录和ErrIndexPartial
+*/
+func (m *memStorage) FindPage(text string, opts *entities.FindOptions, pos pagePos, size int) ([]entities.Record, []pagePos, pagePos, error) {
+	status := m.IndexStatus()
+	if status == INDEX_STATUS_EMPTY {
+		return make([]entities.Record, 0), nil, pos, ErrIndexNotReady
+	}
+	pageOpts := entities.FindOptions{}
+	if opts != nil {
+		pageOpts = *opts
+	}
+	pageOpts.Limit = 0
+	var ranked config.RankList
+	var err error
+	if status == INDEX_STATUS_BUILDING {
+		ranked, err = m.rank(text, &pageOpts)
+	} else {
+		ranked, err = m.cachedRank(text, &pageOpts)
+	}
+	if err != nil {
+		return make([]entities.Record, 0), nil, pos, err
+	}
+	result, offsets, offset, err := m.collect(ranked, text, &pageOpts, pos.resume(ranked), size)
+	nexts := make([]pagePos, len(offsets))
+	for i, o := range offsets {
+		nexts[i] = rankPos(ranked, o)
+	}
+	next := rankPos(ranked, offset)
+	if err == nil && offset >= len(ranked) {
+		next.Offset = -1
+	}
+	if err == nil && status == INDEX_STATUS_BUILDING {
+		err = ErrIndexPartial
+	}
+	return result, nexts, next, err
+}
+
+/*
+对查询文本命中的记录按相关度排序
+*/
+func (m *memStorage) rank(text string, opts *entities.FindOptions) (config.RankList, error) {
+	keyWords, err := m.index.Parse(text)
+	if err != nil {
+		return nil, err
 	}
 	kwLen := m.lengthWords(keyWords)
-	found, err := m.index.Find(keyWords, kwLen)
+	rankOpts := opts
+	if opts.GetMode() == entities.MATCH_PHRASE {
+		//短语匹配需要过滤原文后再截取，索引层不限制数量
+		rankOpts = &entities.FindOptions{Mode: entities.MATCH_PHRASE, Stats: opts.GetStats()}
+	} else if len(opts.GetFilters()) > 0 {
+		//有过滤条件时先过滤再截取，索引层不限制数量
+		limited := *opts
+		limited.Limit = 0
+		rankOpts = &limited
+	}
+	return m.index.Rank(keyWords, kwLen, rankOpts)
+}
+
+func (m *memStorage) cachedRank(text string, opts *entities.FindOptions) (config.RankList, error) {
+	key := pageCacheKey(text, opts)
+	now := time.Now()
+	m.pageLock.Lock()
+	cache, ok := m.pages[key]
+	m.pageLock.Unlock()
+	if ok && now.Before(cache.expires) {
+		return cache.ranked, nil
+	}
+	ranked, err := m.rank(text, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.pageLock.Lock()
+	defer m.pageLock.Unlock()
+	for k, v := range m.pages {
+		if now.After(v.expires) || len(m.pages) >= pageCacheSize {
+			delete(m.pages, k)
+		}
+	}
+	m.pages[key] = &rankCache{ranked: ranked, expires: now.Add(pageCacheTTL)}
+	return ranked, nil
+}
+
+func pageCacheKey(text string, opts *entities.FindOptions) string {
+	return fmt.Sprintf("%s|%d|%.4f|%d|%v", text, opts.GetMode(), opts.GetMinRatio(), opts.GetFuzzy(), opts.GetHighlight())
+}
+
+/*
+从排序结果的offset位置开始读取最多size条记录,size小于等于0时读取全部,
+返回记录、每条记录之后的位置和最后读取的位置之后的位置
+*/
+func (m *memStorage) collect(ranked config.RankList, text string, opts *entities.FindOptions, offset int, size int) ([]entities.Record, []int, int, error) {
+	var r entities.Record
+	result := make([]entities.Record, 0)
+	nexts := make([]int, 0)
+	phrase := opts.GetMode() == entities.MATCH_PHRASE
+	filters, err := compileFilters(opts.GetFilters())
 	if err != nil {
-		return result, err
+		return result, nexts, offset, err
 	}
-	for k, v := range found {
-		text, err := m.Get(k)
+	//等值过滤字段有二级索引时先查出满足条件的主键,不在其中的记录不再读取
+	allowed := filterKeys(m.db, m.filterPaths, filters)
+	i := offset
+	for ; i < len(ranked); i++ {
+		if size > 0 && len(result) >= size {
+			break
+		}
+		item := ranked[i]
+		if allowed != nil && !allowed[item.Key] {
+			continue
+		}
+		value, err := m.Get(item.Key)
+		if err == memdb.ErrNotFound {
+			//记录已删除但索引尚未删除，忽略该记录
+			continue
+		}
 		if err != nil {
-			return result, err
+			return result, nexts, i, err
+		}
+		if len(value) == 0 || !matchFilters(value, filters) {
+			continue
 		}
-		if len(text) == 0 {
+		r = m.createRecord(value, item.Ratio)
+		r.Fields = item.Fields
+		if phrase && !containsPhrase(r.Desc, text) {
 			continue
 		}
-		r = m.createRecord(text, v)
+		r.Score = item.Score
+		if opts.GetHighlight() {
+			r.Terms = item.Terms
+			r.Matches = highlight(m.index.Normalize(r.Desc), item.Terms)
+		}
 		result = append(result, r)
+		nexts = append(nexts, i+1)
 	}
-	return result, err
+	return result, nexts, i, nil
+}
+
+/*
+忽略大小写，判断描述中是否包含完整的查询文本
+*/
+func containsPhrase(desc string, phrase string) bool {
+	return strings.Contains(strings.ToUpper(desc), strings.ToUpper(phrase))
+}
+
+/*
+查找关键字在描述中的所有位置,desc需要先归一化,忽略大小写,按位置排序
+*/
+func highlight(desc string, terms []string) []entities.Match {
+	text := []rune(desc)
+	for i, r := range text {
+		text[i] = unicode.ToUpper(r)
+	}
+	result := make([]entities.Match, 0)
+	for _, term := range terms {
+		word := []rune(strings.ToUpper(term))
+		if len(word) == 0 {
+			continue
+		}
+		for i := 0; i+len(word) <= len(text); i++ {
+			if string(text[i:i+len(word)]) == string(word) {
+				result = append(result, entities.Match{Term: term, Start: i, End: i + len(word)})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		return result[i].End < result[j].End
+	})
+	return result
+}
+
+/*
+查找与文本近似重复的记录,按相似度从高到低排序
+*/
+func (m *memStorage) FindSimilar(text string, threshold float32) ([]entities.SimilarRecord, error) {
+	if m.IndexStatus() != INDEX_STATUS_READY {
+		return make([]entities.SimilarRecord, 0), ErrIndexNotReady
+	}
+	words, err := m.index.Parse(text)
+	if err != nil {
+		return make([]entities.SimilarRecord, 0), err
+	}
+	found := m.similar.find(words, threshold)
+	result := make([]entities.SimilarRecord, 0, len(found))
+	for key, similarity := range found {
+		value, err := m.Get(key)
+		if err == memdb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		r := m.createRecord(value, 0)
+		result = append(result, entities.SimilarRecord{Id: r.Id, Desc: r.Desc, Similarity: similarity})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Similarity != result[j].Similarity {
+			return result[i].Similarity > result[j].Similarity
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result, nil
+}
+
+/*
+根据记录索引字段的关键字计算并保存MinHash签名
+*/
+func (m *memStorage) addSignature(key string, values map[string]string) {
+	words := make([]config.Text, 0)
+	for _, f := range m.fields {
+		value, ok := values[f.Path]
+		if !ok {
+			continue
+		}
+		parsed, err := m.index.Parse(value)
+		if err != nil {
+			logger.Errorf("数据库[%s]计算签名失败[key:%s]:%s\n", m.name, key, err.Error())
+			return
+		}
+		words = append(words, parsed...)
+	}
+	m.similar.add(key, words)
+}
+
+/*
+获得记录中索引字段的文本,数组字段的元素以记录分隔符连接,值为空的字段被忽略
+*/
+func (m *memStorage) indexValues(text string) map[string]string {
+	values := make(map[string]string, len(m.fields))
+	for _, f := range m.fields {
+		result := gjson.Get(text, f.Path)
+		if !result.Exists() {
+			continue
+		}
+		var value string
+		if result.IsArray() {
+			items := make([]string, 0)
+			for _, v := range result.Array() {
+				items = append(items, v.String())
+			}
+			value = strings.Join(items, config.PARSING_RECORD_SEP)
+		} else {
+			value = result.String()
+		}
+		if len(value) == 0 {
+			continue
+		}
+		values[f.Path] = value
+	}
+	return values
+}
+
+func (m *memStorage) fieldPaths() string {
+	paths := make([]string, 0, len(m.fields))
+	for _, f := range m.fields {
+		paths = append(paths, f.Path)
+	}
+	return strings.Join(paths, ",")
 }
 
 /*
@@ -189,6 +777,7 @@ func (m *memStorage) Find(text string) ([]entities.Record, error) {
 func (m *memStorage) createRecord(text string, ratio float32) entities.Record {
 	var r entities.Record = entities.Record{}
 	r.PrefixRatio = ratio
+	r.Value = text
 	r.Desc = gjson.Get(text, config.GJSON_FIELD_DESC).Str
 	r.Id = gjson.Get(text, config.GJSON_FIELD_ID).Str
 	return r
@@ -206,35 +795,28 @@ func (m *memStorage) lengthWords(words []config.Text) int {
 }
 
 /*
-键计数器，每次增加一条记录加一,删除数据减一
+删除旧版本保存在数据库中的记录数量,记录数量改为由memdb统计
 */
-func (m *memStorage) increaseCount(delta int64) {
-	var nCount int
-	key := "key_count"
-	strCount, err := m.Get(key)
-	if err != nil && err.Error() != "not found" {
-		logger.Errorf("Service:memStorage,Message:获取Key数量报错|%s\n", err.Error())
-		return
-	}
-	if len(strCount) == 0 {
-		nCount = 0
-	} else {
-		nCount, err = strconv.Atoi(strCount)
-		if err != nil {
-			logger.Errorf("Service:memStorage,Message:类型转换报错|%s|%s\n", strCount, err.Error())
-			return
-		}
+func (m *memStorage) removeLegacyCount() {
+	err := m.db.Update(func(tx *memdb.Tx) error {
+		_, err := tx.Delete(keyCountKey)
+		return err
+	})
+	if err != nil && err != memdb.ErrNotFound {
+		logger.Errorf("数据库[%s]删除旧的记录数量失败:%s\n", m.name, err.Error())
 	}
-
-	nCount64 := int64(nCount)
-	m.count = int(atomic.AddInt64(&nCount64, delta))
-	m.Set(key, strconv.Itoa(m.count))
-
 }
 
 /*
-获得本库键的总数
+获得本库键的总数,不包含删除记录写入的墓碑
 */
 func (m *memStorage) GetKeyCount() int {
-	return m.count
+	count := 0
+	m.db.View(func(tx *memdb.Tx) error {
+		var err error
+		count, err = tx.Len()
+		count = count - int(atomic.LoadInt64(&m.tombstones))
+		return err
+	})
+	return count
 }
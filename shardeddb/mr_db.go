@@ -61,7 +61,7 @@ func (l *LookupJob) createTasks(jobInfo *interfaces.JobInfo) []*task.Task {
 		t.Result = task.Collection{}
 		t.Stage = 0
 		t.TimeOut = 0
-		t.Object = &findParam{DBName: p.DBName + "_" + strconv.Itoa(i), Text: p.Text}
+		t.Object = &findParam{DBName: p.DBName + "_" + strconv.Itoa(i), Text: p.Text, Options: p.Options, PageSize: p.PageSize, Offsets: p.Offsets}
 		t.Source = *task.NewCollection()
 		t.Context = task.NewTaskContextEx()
 		tasks = append(tasks, t)
@@ -96,17 +96,26 @@ func (l *LookupConsumer) Consume(workerId uint, taskItem *task.Task) bool {
 	result := task.NewTaskResult(make([]entities.Record, 0, 0))
 	if !ok {
 		result.Success = false
+		result.Message = "数据库不存在"
 		logger.Errorf("Service:LookupConsumer,WorkerId:%d,GOROUTINE:%d,Time:%.2f,Message:%s数据库不存在\n", workerId, utils.GetGID(), t.Stop(), p.DBName)
 		taskItem.Result.Append(result)
 		return true
 	}
-	records, err := store.Find(p.Text)
-	if err != nil {
+	if p.PageSize > 0 {
+		return l.consumePage(workerId, taskItem, store, p)
+	}
+	records, err := store.Find(p.Text, p.Options)
+	if err != nil && err != ErrIndexPartial {
 		result.Success = false
-		logger.Errorf("Service:LookupConsumer,WorkerId:%d,GOROUTINE:%d,Time:%.2fs,Message:查找%s|%\n", workerId, utils.GetGID(), t.Stop(), p.Text, err.Error())
+		result.Message = err.Error()
+		logger.Errorf("Service:LookupConsumer,WorkerId:%d,GOROUTINE:%d,Time:%.2fs,Message:查找%s|%s\n", workerId, utils.GetGID(), t.Stop(), p.Text, err.Error())
 		taskItem.Result.Append(result)
 		return true
 	}
+	//索引重建过程中返回已命中的记录,Message记录结果不完整的原因
+	if err == ErrIndexPartial {
+		result.Message = err.Error()
+	}
 	result.Content = records
 	result.Success = true
 	taskItem.Result.Append(result)
@@ -114,20 +123,179 @@ func (l *LookupConsumer) Consume(workerId uint, taskItem *task.Task) bool {
 	return true
 }
 
+/*
+分页查找时,分库从游标记录的位置开始读取一页记录
+*/
+func (l *LookupConsumer) consumePage(workerId uint, taskItem *task.Task, store IMemStorage, p *findParam) bool {
+	t := utils.NewTimer()
+	page := &shardPage{DBName: p.DBName}
+	result := task.NewTaskResult(page)
+	pos, ok := p.Offsets[p.DBName]
+	page.Start = pos
+	if ok && pos.Offset < 0 {
+		page.Next = pos
+		result.Success = true
+		taskItem.Result.Append(result)
+		return true
+	}
+	records, nexts, next, err := store.FindPage(p.Text, p.Options, pos, p.PageSize)
+	if err != nil && err != ErrIndexPartial {
+		page.Next = pos
+		result.Success = false
+		result.Message = err.Error()
+		logger.Errorf("Service:LookupConsumer,WorkerId:%d,GOROUTINE:%d,Time:%.2fs,Message:分页查找%s|%s\n", workerId, utils.GetGID(), t.Stop(), p.Text, err.Error())
+		taskItem.Result.Append(result)
+		return true
+	}
+	if err == ErrIndexPartial {
+		result.Message = err.Error()
+	}
+	page.Records = records
+	page.Nexts = nexts
+	page.Next = next
+	result.Success = true
+	taskItem.Result.Append(result)
+	return true
+}
+
+/*
+分库的一页记录,Start为本页的起始位置,Nexts为每条记录之后的位置,Next为本页之后的位置,
+位置的Offset为-1表示没有更多记录
+*/
+type shardPage struct {
+	DBName  string
+	Start   pagePos
+	Records []entities.Record
+	Nexts   []pagePos
+	Next    pagePos
+}
+
+/*
+合并各分库的一页记录后的结果,Offsets为各分库下一页的起始位置,Failures为查找失败或结果不完整的分库及原因
+*/
+type pageResult struct {
+	Records  []entities.Record
+	Offsets  map[string]pagePos
+	Failures map[string]string
+}
+
 type LookupReducer struct {
 }
 
+/*
+合并各分库的查找结果,查找失败或结果不完整的分库记录在FindResult.Failures中
+*/
 func (l *LookupReducer) Reduce(sources map[int]*task.Task) (map[int]*task.Task, *task.TaskResult, error) {
 	t := utils.NewTimer()
-	all := make([]entities.Record, 0, 0)
+	var limit int
+	lists := make([][]entities.Record, 0, len(sources))
+	failures := make(map[string]string)
 	for _, t := range sources {
+		p, ok := t.Object.(*findParam)
+		if ok {
+			if p.PageSize > 0 {
+				return sources, task.NewTaskResult(reducePages(sources, p.PageSize)), nil
+			}
+			limit = p.Options.GetLimit()
+		}
 		for _, r := range t.Result {
 			item := r.(*task.TaskResult)
+			if len(item.Message) > 0 && ok {
+				failures[p.DBName] = item.Message
+			}
 			records := item.Content.([]entities.Record)
-			all = append(all, records...)
+			lists = append(lists, records)
 		}
 	}
-	result := task.NewTaskResult(all)
+	merged := &entities.FindResult{Records: mergeRecords(lists, limit)}
+	if len(failures) > 0 {
+		merged.Failures = failures
+	}
+	result := task.NewTaskResult(merged)
 	logger.Infof("Service:LookupReducer,GOROUTINE:%d,Time:%.2f,Message:汇总完成\n", utils.GetGID(), t.Stop())
 	return sources, result, nil
 }
+
+/*
+合并各分库的一页记录,取前pageSize条,并计算各分库下一页的起始位置。
+查找失败的分库记录在Failures中,下一页从原位置重新查找
+*/
+func reducePages(sources map[int]*task.Task, pageSize int) *pageResult {
+	pages := make([]*shardPage, 0, len(sources))
+	lists := make([][]entities.Record, 0, len(sources))
+	failures := make(map[string]string)
+	for _, t := range sources {
+		for _, r := range t.Result {
+			item := r.(*task.TaskResult)
+			if len(item.Message) > 0 {
+				if p, ok := t.Object.(*findParam); ok {
+					failures[p.DBName] = item.Message
+				}
+			}
+			page, ok := item.Content.(*shardPage)
+			if !ok {
+				continue
+			}
+			pages = append(pages, page)
+			lists = append(lists, page.Records)
+		}
+	}
+	records, heads := mergeRecordLists(lists, pageSize)
+	result := &pageResult{Records: records, Offsets: make(map[string]pagePos, len(pages))}
+	if len(failures) > 0 {
+		result.Failures = failures
+	}
+	for i, page := range pages {
+		switch {
+		case heads[i] == len(page.Records):
+			//本页记录全部返回,从本页之后的位置继续
+			result.Offsets[page.DBName] = page.Next
+		case heads[i] > 0:
+			result.Offsets[page.DBName] = page.Nexts[heads[i]-1]
+		default:
+			result.Offsets[page.DBName] = page.Start
+		}
+	}
+	return result
+}
+
+/*
+合并各分库已按评分排序的记录列表，取前limit条,limit小于等于0时返回全部。
+各分库按汇总的统计信息评分(见dbNodeHandler.withStats),评分可以直接比较
+*/
+func mergeRecords(lists [][]entities.Record, limit int) []entities.Record {
+	result, _ := mergeRecordLists(lists, limit)
+	return result
+}
+
+/*
+合并已排序的记录列表,同时返回每个列表被取出的记录数
+*/
+func mergeRecordLists(lists [][]entities.Record, limit int) ([]entities.Record, []int) {
+	total := 0
+	for _, list := range lists {
+		total = total + len(list)
+	}
+	if limit <= 0 || limit > total {
+		limit = total
+	}
+	result := make([]entities.Record, 0, limit)
+	heads := make([]int, len(lists))
+	for len(result) < limit {
+		best := -1
+		for i, list := range lists {
+			if heads[i] >= len(list) {
+				continue
+			}
+			if best < 0 || list[heads[i]].Before(&lists[best][heads[best]]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		result = append(result, lists[best][heads[best]])
+		heads[best]++
+	}
+	return result, heads
+}
@@ -0,0 +1,171 @@
+package shardeddb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/easymr/artifacts/task"
+	"github.com/xp/shorttext-db/easymr/interfaces"
+	"github.com/xp/shorttext-db/entities"
+	"hash/fnv"
+	"sort"
+)
+
+var ErrInvalidCursor = errors.New("游标无效或与查询条件不一致")
+
+/*
+分页查找的游标,Hash为查询条件的摘要,Offsets为各分库下一页的起始位置
+*/
+type findCursor struct {
+	Hash    uint32             `json:"h"`
+	Offsets map[string]pagePos `json:"p"`
+}
+
+/*
+分库排序结果中的位置,Offset为下一条记录的序号,-1表示没有更多记录。
+Score、Ratio和Key为该位置之前的一条记录,排序结果过期重新计算后记录的序号可能变化,
+此时从该记录之后继续读取,翻页过程中有写入时不会重复或遗漏其余记录
+*/
+type pagePos struct {
+	Offset int     `json:"o"`
+	Score  float32 `json:"s,omitempty"`
+	Ratio  float32 `json:"r,omitempty"`
+	Key    string  `json:"k,omitempty"`
+}
+
+//排序结果中offset之前的位置
+func rankPos(ranked config.RankList, offset int) pagePos {
+	if offset >= len(ranked) {
+		offset = len(ranked)
+	}
+	if offset <= 0 {
+		return pagePos{}
+	}
+	item := ranked[offset-1]
+	return pagePos{Offset: offset, Score: item.Score, Ratio: item.Ratio, Key: item.Key}
+}
+
+/*
+在排序结果中找到继续读取的序号,序号处的前一条记录与游标记录一致时直接返回,
+否则返回排序在游标记录之后的第一条记录的序号
+*/
+func (p pagePos) resume(ranked config.RankList) int {
+	if p.Offset <= 0 {
+		return 0
+	}
+	if p.Offset <= len(ranked) {
+		item := ranked[p.Offset-1]
+		if item.Key == p.Key && item.Score == p.Score && item.Ratio == p.Ratio {
+			return p.Offset
+		}
+	}
+	return sort.Search(len(ranked), func(i int) bool {
+		item := ranked[i]
+		if item.Score != p.Score {
+			return item.Score < p.Score
+		}
+		if item.Ratio != p.Ratio {
+			return item.Ratio < p.Ratio
+		}
+		return item.Key > p.Key
+	})
+}
+
+func queryHash(db string, text string, opts *entities.FindOptions) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(db + "|" + pageCacheKey(text, opts)))
+	//过滤条件不影响排序结果的缓存,但同一游标必须使用相同的过滤条件
+	if filters := opts.GetFilters(); len(filters) > 0 {
+		buff, _ := json.Marshal(filters)
+		h.Write(buff)
+	}
+	return h.Sum32()
+}
+
+func encodeCursor(c *findCursor) (string, error) {
+	buff, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buff), nil
+}
+
+func decodeCursor(text string) (*findCursor, error) {
+	buff, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &findCursor{}
+	if err = json.Unmarshal(buff, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+/*
+分页查找,cursor为空时返回第一页,各分库全部读取完毕时返回的游标为空。
+部分分库查找失败时返回其余分库的记录,失败的分库记录在Page.Failures中,下一页时重新查找
+*/
+func (d *dbNodeHandler) findPage(db string, text string, opts *entities.FindOptions, pageSize int, cursor string) (*entities.Page, error) {
+	if len(db) == 0 {
+		db = d.defaultDB
+	}
+	if pageSize <= 0 {
+		pageSize = entities.DEFAULT_PAGE_SIZE
+	}
+	hash := queryHash(db, text, opts)
+	offsets := make(map[string]pagePos)
+	if len(cursor) > 0 {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Hash != hash {
+			return nil, ErrInvalidCursor
+		}
+		offsets = c.Offsets
+	}
+	jobInfo := &interfaces.JobInfo{}
+	jobInfo.Handler = "LookupJob"
+	jobInfo.Params = make(map[string]string)
+	jobInfo.Context = make(map[string][]byte)
+	jobInfo.LocalJob = true
+	p := &findParam{}
+	p.Text = text
+	p.DBName = db
+	p.Options = d.withStats(db, text, opts)
+	p.PageSize = pageSize
+	p.Offsets = offsets
+	jobInfo.Source = p
+
+	context := &task.TaskContext{}
+	context.Context = make(map[string]interface{})
+	result, err := d.clbt.MapReduce(jobInfo, context)
+	if err != nil {
+		return nil, err
+	}
+	merged, ok := result.Content.(*pageResult)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("数据库[%s]分页查找结果错误", db))
+	}
+	page := &entities.Page{Records: merged.Records, Failures: merged.Failures}
+	for _, pos := range merged.Offsets {
+		if pos.Offset >= 0 {
+			page.Cursor, err = encodeCursor(&findCursor{Hash: hash, Offsets: merged.Offsets})
+			break
+		}
+	}
+	return page, err
+}
+
+/*
+分页查找文本命中的记录,按相关度评分从高到低返回pageSize条,cursor为上一页返回的游标,为空时返回第一页
+*/
+func (d *DBNode) FindPage(db string, text string, opts *entities.FindOptions, pageSize int, cursor string) (*entities.Page, error) {
+	if opts == nil {
+		opts = entities.NewFindOptions()
+	}
+	return d.nodeHandler.findPage(db, text, opts, pageSize, cursor)
+}
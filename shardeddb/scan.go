@@ -0,0 +1,150 @@
+package shardeddb
+
+import (
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/memdb"
+	"github.com/xp/shorttext-db/network"
+	"strconv"
+)
+
+//范围扫描的缺省每页记录数
+const DEFAULT_SCAN_PAGE_SIZE = 1000
+
+/*
+范围扫描请求,扫描主键在[Start,End]范围内的记录
+*/
+type scanRequest struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+	Limit int    `json:"limit"`
+}
+
+/*
+按主键数值顺序返回[start,end]范围内的最多limit条记录
+*/
+func (m *memStorage) Scan(start uint64, end uint64, limit int) (*entities.ScanPage, error) {
+	if limit <= 0 {
+		limit = DEFAULT_SCAN_PAGE_SIZE
+	}
+	page := &entities.ScanPage{Records: make([]entities.KeyValue, 0)}
+	err := m.db.View(func(tx *memdb.Tx) error {
+		return scanKeys(tx, start, end, func(key uint64, value string) bool {
+			if len(page.Records) == limit {
+				page.Next = key
+				return false
+			}
+			page.Records = append(page.Records, entities.KeyValue{Key: key, Value: value})
+			return true
+		})
+	})
+	return page, err
+}
+
+/*
+按数值顺序遍历主键在[start,end]范围内的记录。主键是不带前导零的十进制字符串,位数相同的主键字符串顺序与数值顺序一致,
+因此按位数分段遍历;遇到位数更多的主键时跳到下一个前缀继续,不逐条跳过以该前缀开头的主键
+*/
+func scanKeys(tx *memdb.Tx, start uint64, end uint64, iterator func(key uint64, value string) bool) error {
+	if start > end {
+		return nil
+	}
+	maxDigits := len(strconv.FormatUint(end, 10))
+	for digits := len(strconv.FormatUint(start, 10)); digits <= maxDigits; digits++ {
+		lower := start
+		if min := minOfDigits(digits); lower < min {
+			lower = min
+		}
+		upper := end
+		if max, ok := maxOfDigits(digits); ok && upper > max {
+			upper = max
+		}
+		last := strconv.FormatUint(upper, 10)
+		pivot := strconv.FormatUint(lower, 10)
+		for len(pivot) > 0 {
+			from := pivot
+			pivot = ""
+			stopped := false
+			err := tx.AscendGreaterOrEqual("", from, func(key, value string) bool {
+				n := len(key)
+				if n > digits {
+					n = digits
+				}
+				if key[:n] > last[:n] {
+					return false
+				}
+				switch {
+				case len(key) < digits:
+					return true
+				case len(key) > digits:
+					//跳过以key[:digits]开头的所有主键
+					prefix, err := strconv.ParseUint(key[:digits], 10, 64)
+					if err == nil && key[:digits] < last {
+						pivot = strconv.FormatUint(prefix+1, 10)
+					}
+					return false
+				}
+				nKey, err := strconv.ParseUint(key, 10, 64)
+				if err != nil {
+					return true
+				}
+				if !iterator(nKey, value) {
+					stopped = true
+					return false
+				}
+				return key != last
+			})
+			if err != nil || stopped {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//digits位十进制数的最小值,一位数时为0
+func minOfDigits(digits int) uint64 {
+	if digits == 1 {
+		return 0
+	}
+	min := uint64(1)
+	for i := 1; i < digits; i++ {
+		min = min * 10
+	}
+	return min
+}
+
+//digits位十进制数的最大值,超出uint64范围时ok为false
+func maxOfDigits(digits int) (uint64, bool) {
+	if digits >= 20 {
+		return 0, false
+	}
+	max := uint64(1)
+	for i := 0; i < digits; i++ {
+		max = max * 10
+	}
+	return max - 1, true
+}
+
+func (d *dbNodeHandler) processScan(db IMemStorage, m network.Message) (string, error) {
+	req := &scanRequest{}
+	if _, err := deserialize(m.Text, req); err != nil {
+		return "", err
+	}
+	page, err := db.Scan(req.Start, req.End, req.Limit)
+	if err != nil {
+		return "", err
+	}
+	return serialize(page)
+}
+
+/*
+按主键数值顺序扫描节点上分库中[start,end]范围内的最多limit条记录
+*/
+func (d *dbNodeClient) Scan(index uint64, start uint64, end uint64, limit int) (*entities.ScanPage, error) {
+	page := &entities.ScanPage{}
+	if err := d.query(index, config.MSG_KV_SCAN, &scanRequest{Start: start, End: end, Limit: limit}, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
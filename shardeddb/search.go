@@ -0,0 +1,198 @@
+package shardeddb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/network"
+	"strconv"
+)
+
+/*
+集群查找时发送给节点的查找请求
+*/
+type findRequest struct {
+	Text    string                `json:"text"`
+	Options *entities.FindOptions `json:"options"`
+}
+
+/*
+节点返回的查找结果,Record的评分不参与JSON序列化,单独传递以便合并后重新排序
+*/
+type rankedRecord struct {
+	entities.Record
+	Score       float32  `json:"score"`
+	PrefixRatio float32  `json:"prefix"`
+	Value       string   `json:"value"`
+	Fields      []string `json:"fields,omitempty"`
+}
+
+/*
+节点返回的查找结果,Failures为查找失败或结果不完整的分库及原因
+*/
+type findResponse struct {
+	Records  []rankedRecord    `json:"records"`
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+func toRankedRecords(records []entities.Record) []rankedRecord {
+	result := make([]rankedRecord, 0, len(records))
+	for _, r := range records {
+		result = append(result, rankedRecord{Record: r, Score: r.Score, PrefixRatio: r.PrefixRatio, Value: r.Value, Fields: r.Fields})
+	}
+	return result
+}
+
+func fromRankedRecords(ranked []rankedRecord) []entities.Record {
+	result := make([]entities.Record, 0, len(ranked))
+	for _, item := range ranked {
+		r := item.Record
+		r.Score = item.Score
+		r.PrefixRatio = item.PrefixRatio
+		r.Value = item.Value
+		r.Fields = item.Fields
+		result = append(result, r)
+	}
+	return result
+}
+
+/*
+处理集群查找请求,在本节点数据库的所有分库上查找,部分分库结果不完整时仍返回其余分库的记录
+*/
+func (d *dbNodeHandler) processFind(m network.Message) (string, error) {
+	req := &findRequest{}
+	if _, err := deserialize(m.Text, req); err != nil {
+		return "", err
+	}
+	if req.Options == nil {
+		req.Options = entities.NewFindOptions()
+	}
+	records, err := d.find(m.DBName, req.Text, req.Options)
+	resp := &findResponse{}
+	if partial, ok := err.(*entities.PartialError); ok {
+		resp.Failures = partial.Failures
+	} else if err != nil {
+		return "", err
+	}
+	resp.Records = toRankedRecords(records)
+	return serialize(resp)
+}
+
+/*
+在节点上查找数据库的所有分库,部分分库结果不完整时返回其余分库的记录和*entities.PartialError
+*/
+func (d *dbNodeClient) Find(text string, opts *entities.FindOptions) ([]entities.Record, error) {
+	text, err := serialize(&findRequest{Text: text, Options: opts})
+	if err != nil {
+		return nil, err
+	}
+	term, err := d.generateId()
+	if err != nil {
+		return nil, err
+	}
+	m := network.NewOnlyOneMsg(term, "", text, config.MSG_KV_FIND)
+	m.Messages[0].From = config.GetCase().GetMaster().ID
+	m.Messages[0].To = d.Id
+	m.Messages[0].DBName = d.dbName
+	result, err := d.client.Send(m)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || len(result.Messages) == 0 {
+		return nil, errors.New(fmt.Sprintf("dbNodeClient 查找失败[Node:%d]", d.Id))
+	}
+	resultMsg := result.Messages[0]
+	if resultMsg.ResultCode == config.MSG_KV_RESULT_FAILURE {
+		return nil, errors.New(resultMsg.Text)
+	}
+	resp := &findResponse{}
+	if _, err = deserialize(resultMsg.Text, resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Failures) > 0 {
+		return fromRankedRecords(resp.Records), &entities.PartialError{Failures: resp.Failures}
+	}
+	return fromRankedRecords(resp.Records), nil
+}
+
+/*
+汇总节点上数据库所有分库的BM25统计信息,索引尚未建立或统计失败的分库不计入
+*/
+func (d *dbNodeHandler) termStats(db string, text string, opts *entities.FindOptions) *entities.TermStats {
+	if len(db) == 0 {
+		db = d.defaultDB
+	}
+	stats := entities.NewTermStats()
+	for i := 1; i <= d.dbCount; i++ {
+		dbName := db + "_" + strconv.Itoa(i)
+		store, ok := d.dbs[dbName]
+		if !ok {
+			continue
+		}
+		if err := store.TermStats(text, opts, stats); err != nil && err != ErrIndexNotReady {
+			logger.Errorf("数据库[%s]统计关键字失败:%s\n", dbName, err.Error())
+		}
+	}
+	return stats
+}
+
+/*
+查询选项中没有汇总的统计信息时,使用本节点所有分库的统计信息,使各分库的评分可以直接合并
+*/
+func (d *dbNodeHandler) withStats(db string, text string, opts *entities.FindOptions) *entities.FindOptions {
+	if opts.GetStats() != nil || d.dbCount <= 1 {
+		return opts
+	}
+	result := entities.FindOptions{}
+	if opts != nil {
+		result = *opts
+	}
+	result.Stats = d.termStats(db, text, opts)
+	return &result
+}
+
+/*
+处理集群查找前的统计请求,返回本节点所有分库的BM25统计信息
+*/
+func (d *dbNodeHandler) processTermStats(m network.Message) (string, error) {
+	req := &findRequest{}
+	if _, err := deserialize(m.Text, req); err != nil {
+		return "", err
+	}
+	return serialize(d.termStats(m.DBName, req.Text, req.Options))
+}
+
+/*
+获得查询文本的关键字在节点上数据库所有分库中的BM25统计信息
+*/
+func (d *dbNodeClient) TermStats(text string, opts *entities.FindOptions) (*entities.TermStats, error) {
+	text, err := serialize(&findRequest{Text: text, Options: opts})
+	if err != nil {
+		return nil, err
+	}
+	term, err := d.generateId()
+	if err != nil {
+		return nil, err
+	}
+	m := network.NewOnlyOneMsg(term, "", text, config.MSG_KV_TERMSTATS)
+	m.Messages[0].From = config.GetCase().GetMaster().ID
+	m.Messages[0].To = d.Id
+	m.Messages[0].DBName = d.dbName
+	result, err := d.client.Send(m)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || len(result.Messages) == 0 {
+		return nil, errors.New(fmt.Sprintf("dbNodeClient 统计关键字失败[Node:%d]", d.Id))
+	}
+	resultMsg := result.Messages[0]
+	if resultMsg.ResultCode == config.MSG_KV_RESULT_FAILURE {
+		return nil, errors.New(resultMsg.Text)
+	}
+	stats := entities.NewTermStats()
+	if _, err = deserialize(resultMsg.Text, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -29,9 +29,13 @@ type dbNodeHandler struct {
 	clbt      *collaborator.Collaborator
 	defaultDB string
 	dbCount   int
+	//用户词典的保存路径
+	dictPath string
+	//同义词词典的保存路径
+	synonymPath string
 }
 
-func newDBNodeHandler(id int, dbCount int, path string, names ...string) *dbNodeHandler {
+func newDBNodeHandler(id int, dbCount int, path string, cfg *config.Config, names ...string) *dbNodeHandler {
 	d := &dbNodeHandler{}
 	d.dbs = make(map[string]IMemStorage)
 
@@ -39,9 +43,10 @@ func newDBNodeHandler(id int, dbCount int, path string, names ...string) *dbNode
 
 	var i int
 	for _, name := range names {
+		fields := cfg.GetIndexFields(name)
 		for i = 1; i <= d.dbCount; i++ {
 			dbName := name + "_" + strconv.FormatUint(uint64(i), 10)
-			dbInstance, err := newMemStorage(id, path, dbName)
+			dbInstance, err := newMemStorage(id, path, dbName, fields, cfg.GetFilterIndexes(name))
 			if err != nil {
 				logger.Errorf("创建数据库实例[%s]失败:%s\n", dbName, err.Error())
 				continue
@@ -57,13 +62,28 @@ func (d *dbNodeHandler) getCount(db string) []int {
 	countList := make([]int, 0, len(d.dbs))
 	for i := 1; i <= d.dbCount; i++ {
 		dbName := db + "_" + strconv.FormatUint(uint64(i), 10)
-		store := d.dbs[dbName]
+		store, ok := d.dbs[dbName]
+		if !ok {
+			countList = append(countList, 0)
+			continue
+		}
 		countList = append(countList, store.GetKeyCount())
 	}
 	return countList
 
 }
-func (d *dbNodeHandler) find(db string, text string) ([]entities.Record, error) {
+func (d *dbNodeHandler) isIndexReady(db string) bool {
+	for i := 1; i <= d.dbCount; i++ {
+		dbName := db + "_" + strconv.FormatUint(uint64(i), 10)
+		store, ok := d.dbs[dbName]
+		if !ok || store.IndexStatus() != INDEX_STATUS_READY {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *dbNodeHandler) find(db string, text string, opts *entities.FindOptions) ([]entities.Record, error) {
 	if len(db) == 0 {
 		db = d.defaultDB
 	}
@@ -75,12 +95,20 @@ func (d *dbNodeHandler) find(db string, text string) ([]entities.Record, error)
 	p := &findParam{}
 	p.Text = text
 	p.DBName = db
+	p.Options = d.withStats(db, text, opts)
 	jobInfo.Source = p
 
 	context := &task.TaskContext{}
 	context.Context = make(map[string]interface{})
 	result, err := d.clbt.MapReduce(jobInfo, context)
-	return result.Content.([]entities.Record), err
+	if err != nil {
+		return nil, err
+	}
+	merged := result.Content.(*entities.FindResult)
+	if merged.Partial() {
+		return merged.Records, &entities.PartialError{Failures: merged.Failures}
+	}
+	return merged.Records, nil
 }
 
 func (d *dbNodeHandler) Process(ctx context.Context, m network.Message) error {
@@ -96,7 +124,8 @@ func (d *dbNodeHandler) Process(ctx context.Context, m network.Message) error {
 	result.ResultCode = config.MSG_KV_RESULT_SUCCESS
 	result.Index = m.Index
 	result.Key = m.Key
-	if !ok {
+	//用户词典、集群查找和统计信息针对整个节点,不需要分库
+	if !ok && m.Type != config.MSG_KV_DICT && m.Type != config.MSG_KV_FIND && m.Type != config.MSG_KV_STATS && m.Type != config.MSG_KV_TERMSTATS {
 		result.ResultCode = config.MSG_KV_RESULT_FAILURE
 		errMsg = fmt.Sprintf("数据库实例[%s]不存在", m.DBName)
 		result.Text = errMsg
@@ -110,7 +139,11 @@ func (d *dbNodeHandler) Process(ctx context.Context, m network.Message) error {
 		err = db.Set(key, m.Text)
 		logger.Infof("数据库[%s]更新数据:[key:%s,text:%s]\n", m.DBName, m.Key, m.Text)
 	case config.MSG_KV_TEXTSET:
-		err = db.SetWithIndex(key, m.Text, config.GJSON_FIELD_DESC)
+		if m.TTL > 0 {
+			err = db.SetWithTTL(key, m.Text, time.Duration(m.TTL)*time.Second)
+		} else {
+			err = db.SetWithIndex(key, m.Text)
+		}
 		logger.Infof("数据库[%s] 带前缀更新数据:[key:%s,text:%s]\n", m.DBName, m.Key, m.Text)
 
 	case config.MSG_KV_GET, config.MSG_KV_TEXTGET:
@@ -121,11 +154,28 @@ func (d *dbNodeHandler) Process(ctx context.Context, m network.Message) error {
 		err = db.Delete(key)
 	case config.MSG_KV_ClOSE:
 		err = db.Close()
+	case config.MSG_KV_DICT:
+		req := &entities.DictRequest{}
+		_, err = deserialize(m.Text, req)
+		if err == nil {
+			err = d.updateDictionary(req)
+		}
+	case config.MSG_KV_FIND:
+		val, err = d.processFind(m)
+	case config.MSG_KV_TERMSTATS:
+		val, err = d.processTermStats(m)
+	case config.MSG_KV_EXPORT:
+		val, err = d.processExport(db, m)
+	case config.MSG_KV_SCAN:
+		val, err = d.processScan(db, m)
+	case config.MSG_KV_STATS:
+		val, err = serialize(d.stats())
 	default:
 		err = errors.New(fmt.Sprintf("数据库[%s]不支持该操作[%d]", m.DBName, m.Type))
 	}
 	if err != nil {
 		result.Type = config.MSG_KV_RESULT_FAILURE
+		result.ResultCode = config.MSG_KV_RESULT_FAILURE
 		result.Text = err.Error()
 	} else {
 		result.Text = val
@@ -178,6 +228,9 @@ func (d *dbNodeClient) get(key string, index uint64, msgType uint32, item interf
 	if result == nil || len(result.Messages) == 0 {
 		return nil, errors.New(fmt.Sprintf("dbNodeClient Get操作失败[Key:%s]", key))
 	}
+	if result.Messages[0].ResultCode == config.MSG_KV_RESULT_FAILURE {
+		return nil, errors.New(result.Messages[0].Text)
+	}
 	//buff := util.StringToBytes(result.Messages[0].Text)
 	//err = json.Unmarshal(buff, item)
 	item, err = deserialize(result.Messages[0].Text, item)
@@ -187,7 +240,7 @@ func (d *dbNodeClient) get(key string, index uint64, msgType uint32, item interf
 	return item, nil
 }
 
-func (d *dbNodeClient) set(key string, index uint64, msgType uint32, value interface{}) (error, string) {
+func (d *dbNodeClient) set(key string, index uint64, msgType uint32, value interface{}, ttl time.Duration) (error, string) {
 	var result *network.BatchMessage
 	text, err := serialize(value)
 	if err != nil {
@@ -202,6 +255,7 @@ func (d *dbNodeClient) set(key string, index uint64, msgType uint32, value inter
 	m.Messages[0].From = config.GetCase().GetMaster().ID
 	m.Messages[0].To = d.Id
 	m.Messages[0].DBName = d.dbName + "_" + strconv.FormatUint(index, 10)
+	m.Messages[0].TTL = ttlSeconds(ttl)
 
 	result, err = d.client.Send(m)
 	if err != nil {
@@ -226,15 +280,41 @@ func (d *dbNodeClient) GetText(key string, index uint64) string {
 }
 
 func (d *dbNodeClient) SetText(key string, value string, index uint64) error {
-	err, _ := d.set(key, index, config.MSG_KV_TEXTSET, value)
+	err, _ := d.set(key, index, config.MSG_KV_TEXTSET, value, 0)
+	return err
+}
+
+/*
+保存文本并创建索引，ttl之后记录及其索引自动删除
+*/
+func (d *dbNodeClient) SetTextWithTTL(key string, value string, index uint64, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New(fmt.Sprintf("dbNodeClient TTL必须大于0[Key:%s]", key))
+	}
+	err, _ := d.set(key, index, config.MSG_KV_TEXTSET, value, ttl)
 	return err
 }
 
+//消息中的TTL以秒为单位,不足一秒按一秒计算
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
 func (d *dbNodeClient) Get(key string, index uint64, item interface{}) (interface{}, error) {
 	return d.get(key, index, config.MSG_KV_GET, item)
 }
+
+/*
+按Get的方式解析读取到的文本
+*/
+func (d *dbNodeClient) Decode(text string, item interface{}) (interface{}, error) {
+	return deserialize(text, item)
+}
 func (d *dbNodeClient) Set(key string, index uint64, value interface{}) (error, string) {
-	return d.set(key, index, config.MSG_KV_SET, value)
+	return d.set(key, index, config.MSG_KV_SET, value, 0)
 }
 
 func (d *dbNodeClient) Delete(key string, index uint64) error {
@@ -264,6 +344,31 @@ func (d *dbNodeClient) Delete(key string, index uint64) error {
 	return nil
 }
 
+/*
+把多条消息作为一个BatchMessage发送给节点,各消息使用相同的Term，节点逐条处理后返回结果。
+返回处理失败的消息及原因,键为消息的Key,没有返回结果的消息也作为失败
+*/
+func (d *dbNodeClient) sendBatch(messages []*network.Message) (map[string]string, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	replies, err := d.sendMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+	failures := make(map[string]string)
+	for _, m := range messages {
+		reply, ok := replies[batchKey{index: m.Index, key: m.Key}]
+		switch {
+		case !ok:
+			failures[m.Key] = fmt.Sprintf("节点[%d]没有返回结果", d.Id)
+		case reply.ResultCode == config.MSG_KV_RESULT_FAILURE:
+			failures[m.Key] = reply.Text
+		}
+	}
+	return failures, nil
+}
+
 func (d *dbNodeClient) Close() error {
 	return nil
 }
@@ -308,27 +413,62 @@ func newShards(dbName string) ([]shardedkv.Shard, error) {
 	return shards, err
 }
 
+/*
+按配置创建分片选择器
+*/
+func newChooser(cfg *config.Config) api.Chooser {
+	if cfg.GetChooser() == config.CHOOSER_HASH {
+		return shardedkv.NewHashChooser(uint32(cfg.KVDBMaxRange), cfg.KVDBHashReplicas, cfg.KVDBNodeWeights)
+	}
+	return shardedkv.NewRangeChooser(uint32(cfg.KVDBMaxRange), uint32(cfg.KVDBRowCount), uint32(cfg.KVDRowStart))
+}
+
 //创建KV数据访问客户端
-func newKVStore(dbName string, maxRange uint32, rowCount uint32, start uint32, sequenceServer string) (api.IKVStoreClient, error) {
+func newKVStore(dbName string, chooser api.Chooser, sequenceServer string) (api.IKVStoreClient, error) {
 	shards, err := newShards(dbName)
 	if err != nil {
 		return nil, err
 	}
-	chooser := shardedkv.NewRangeChooser(
-		maxRange,
-		rowCount,
-		start)
 	seq, err := filedb.NewSequenceProxy(sequenceServer)
 	if err != nil {
 		return nil, err
 	}
 	kv := shardedkv.New(dbName, chooser, seq, shards)
+	cfg := config.GetConfig()
+	kv.(*shardedkv.KVStore).SetReplication(cfg.GetReplicas(), writeQuorum(cfg.GetWriteQuorum()))
 	return kv, err
 }
 
+func writeQuorum(quorum string) int {
+	switch quorum {
+	case config.QUORUM_ONE:
+		return shardedkv.WRITE_QUORUM_ONE
+	case config.QUORUM_ALL:
+		return shardedkv.WRITE_QUORUM_ALL
+	}
+	return shardedkv.WRITE_QUORUM_MAJORITY
+}
+
 func NewKVStore(dbName string) (api.IKVStoreClient, error) {
-	return newKVStore(dbName,
-		uint32(config.GetConfig().KVDBMaxRange),
-		uint32(config.GetConfig().KVDBRowCount),
-		uint32(config.GetConfig().KVDRowStart), config.GetConfig().SequenceServer)
+	return newKVStore(dbName, newChooser(config.GetConfig()), config.GetConfig().SequenceServer)
+}
+
+/*
+增加或删除节点并更新Case.CardList之后,或者更换分片选择器之后,按新的节点列表和配置的分片选择器重新平衡数据库,
+迁移期间client仍可正常读写。
+client为按原节点列表创建的KVStore,完成后client切换到新的分区布局,返回迁移的记录数。
+新的分区布局只在client中生效:各节点DBNode的分片选择器以及其他进程中的KVStore、BulkLoader和Exporter
+仍按启动时加载的Case.CardList选择分片,迁移前需要把更新后的配置分发到所有节点并停止其他进程的写入,
+迁移完成后重启所有节点和客户端进程
+*/
+func Rebalance(client api.IKVStoreClient, dbName string, progress func(moved int)) (int, error) {
+	kv, ok := client.(*shardedkv.KVStore)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("数据库[%s]的客户端不支持重新平衡", dbName))
+	}
+	shards, err := newShards(dbName)
+	if err != nil {
+		return 0, err
+	}
+	return kv.Rebalance(shards, newChooser(config.GetConfig()), progress)
 }
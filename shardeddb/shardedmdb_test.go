@@ -5,14 +5,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/easymr/artifacts/task"
 	"github.com/xp/shorttext-db/entities"
 	"github.com/xp/shorttext-db/gjson"
+	"github.com/xp/shorttext-db/network"
+	"github.com/xp/shorttext-db/parse"
 	"github.com/xp/shorttext-db/shardedkv"
 	"github.com/xp/shorttext-db/utils"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 	"unicode/utf8"
 )
 
@@ -80,7 +89,7 @@ func TestKeywordIndex_Create(t *testing.T) {
 		keyWords = append(keyWords, config.Text(v))
 		kwLen = kwLen + l
 	}
-	found, _ := index.Find(keyWords, kwLen)
+	found, _ := index.Find(keyWords, kwLen, nil)
 	fmt.Println(found)
 
 }
@@ -115,7 +124,7 @@ func BenchmarkKeywordIndex_Find(b *testing.B) {
 	text := `电压变送器\DC0-99mV DC4-20mA DC220V FPD-1\国产`
 
 	for i := 0; i < b.N; i++ {
-		db.Find(text)
+		db.Find(text, nil)
 		//index.Find(kwWords,kwLen)
 	}
 	b.StopTimer()
@@ -226,7 +235,7 @@ func loadData(maxCount int) Index {
 
 func TestStart(t *testing.T) {
 	text := `水轮机\HL-LJ-105\225000kW\550000\225000\92`
-	records, err := db.Find(text)
+	records, err := db.Find(text, nil)
 	if err != nil {
 		fmt.Println("TestStart 发生错误:", err)
 	}
@@ -235,9 +244,1021 @@ func TestStart(t *testing.T) {
 
 func TestDBNode_Find(t *testing.T) {
 	text := `水轮机\HL-LJ-105\225000kW\550000\225000\92`
-	records, err := dbNode.Find("testdb", text)
+	records, err := dbNode.Find("testdb", text, nil)
 	if err != nil {
 		fmt.Println("TestStart 发生错误:", err)
 	}
 	fmt.Println(records)
 }
+
+func TestDBNode_FindTop(t *testing.T) {
+	text := `水轮机\HL-LJ-105\225000kW\550000\225000\92`
+	records, err := dbNode.FindTop("testdb", text, 20)
+	if err != nil {
+		t.Fatal("TestDBNode_FindTop 发生错误:", err)
+	}
+	if len(records) > 20 {
+		t.Errorf("返回记录数超过限制:%d\n", len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Score > records[i-1].Score {
+			t.Errorf("记录未按评分排序[%d:%f,%d:%f]\n", i-1, records[i-1].Score, i, records[i].Score)
+		}
+	}
+}
+
+func TestMergeRecords(t *testing.T) {
+	lists := [][]entities.Record{
+		{{Id: "1", Score: 9}, {Id: "2", Score: 5}, {Id: "3", Score: 1}},
+		{{Id: "4", Score: 7}, {Id: "5", Score: 6}},
+		{},
+	}
+	merged := mergeRecords(lists, 3)
+	expected := []string{"1", "4", "5"}
+	if len(merged) != len(expected) {
+		t.Fatalf("合并结果数量错误:%d\n", len(merged))
+	}
+	for i, id := range expected {
+		if merged[i].Id != id {
+			t.Errorf("合并结果顺序错误[%d]:%s,预期:%s\n", i, merged[i].Id, id)
+		}
+	}
+	if all := mergeRecords(lists, 0); len(all) != 5 {
+		t.Errorf("limit为0时应返回全部记录:%d\n", len(all))
+	}
+}
+
+func TestReducePages_Failures(t *testing.T) {
+	ok := task.NewTaskResult(&shardPage{DBName: "db_1", Records: []entities.Record{{Id: "1", Score: 9}, {Id: "2", Score: 5}}, Nexts: []pagePos{{Offset: 1}, {Offset: 2}}, Next: pagePos{Offset: -1}})
+	failed := task.NewTaskResult(&shardPage{DBName: "db_2", Start: pagePos{Offset: 3}, Next: pagePos{Offset: 3}})
+	failed.Success = false
+	failed.Message = "分库查找失败"
+	sources := map[int]*task.Task{
+		1: {Object: &findParam{DBName: "db_1"}, Result: task.Collection{ok}},
+		2: {Object: &findParam{DBName: "db_2"}, Result: task.Collection{failed}},
+	}
+	result := reducePages(sources, 10)
+	if len(result.Records) != 2 || result.Failures["db_2"] != "分库查找失败" || len(result.Failures) != 1 {
+		t.Fatalf("失败的分库未记录在结果中:%v\n", result.Failures)
+	}
+	//失败的分库下一页从原位置重新查找
+	if result.Offsets["db_2"].Offset != 3 || result.Offsets["db_1"].Offset != -1 {
+		t.Errorf("各分库下一页的位置错误:%v\n", result.Offsets)
+	}
+}
+
+func TestPagePos_Resume(t *testing.T) {
+	ranked := config.RankList{{Key: "a", Score: 9}, {Key: "b", Score: 7}, {Key: "c", Score: 7}, {Key: "d", Score: 5}}
+	pos := rankPos(ranked, 2)
+	if pos.Key != "b" || pos.resume(ranked) != 2 {
+		t.Fatalf("排序结果未变化时应从原位置继续:%v,%d\n", pos, pos.resume(ranked))
+	}
+	//翻页之间写入了评分更高的记录,从上一页最后一条记录之后继续
+	changed := config.RankList{{Key: "e", Score: 10}, {Key: "a", Score: 9}, {Key: "b", Score: 7}, {Key: "c", Score: 7}, {Key: "d", Score: 5}}
+	if i := pos.resume(changed); changed[i].Key != "c" {
+		t.Errorf("排序结果变化后继续读取的位置错误:%d\n", i)
+	}
+	//上一页最后一条记录已删除
+	removed := config.RankList{{Key: "a", Score: 9}, {Key: "c", Score: 7}, {Key: "d", Score: 5}}
+	if i := pos.resume(removed); removed[i].Key != "c" {
+		t.Errorf("记录删除后继续读取的位置错误:%d\n", i)
+	}
+	if i := rankPos(ranked, 4).resume(removed); i != len(removed) {
+		t.Errorf("最后一页之后不应再有记录:%d\n", i)
+	}
+}
+
+func TestDBNode_FindWithOptions(t *testing.T) {
+	text := `水轮机\HL-LJ-105`
+	modes := []int{entities.MATCH_RATIO, entities.MATCH_ALL, entities.MATCH_ANY, entities.MATCH_PHRASE}
+	for _, mode := range modes {
+		opts := &entities.FindOptions{Mode: mode, MinRatio: 0.8, Limit: 20}
+		records, err := dbNode.Find("testdb", text, opts)
+		if err != nil {
+			t.Fatalf("匹配模式[%d]查找发生错误:%s\n", mode, err.Error())
+		}
+		if mode == entities.MATCH_RATIO {
+			for _, r := range records {
+				if r.PrefixRatio < 0.8 {
+					t.Errorf("记录[%s]命中占比低于阈值:%f\n", r.Id, r.PrefixRatio)
+				}
+			}
+		}
+		if mode == entities.MATCH_PHRASE {
+			for _, r := range records {
+				if !containsPhrase(r.Desc, text) {
+					t.Errorf("记录[%s]不包含短语:%s\n", r.Id, r.Desc)
+				}
+			}
+		}
+	}
+}
+
+func TestMemStorage_Reopen(t *testing.T) {
+	text := `水轮机\HL-LJ-105\225000kW\550000\225000\92`
+	before, err := db.Find(text, nil)
+	if err != nil {
+		t.Fatal("重新打开之前查找发生错误:", err)
+	}
+	if err = db.Save(); err != nil {
+		t.Fatal("保存数据库发生错误:", err)
+	}
+	if err = db.Open(); err != nil {
+		t.Fatal("打开数据库发生错误:", err)
+	}
+	for i := 0; i < 100 && db.IndexStatus() != INDEX_STATUS_READY; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if db.IndexStatus() != INDEX_STATUS_READY {
+		t.Fatal("索引重建超时")
+	}
+	after, err := db.Find(text, nil)
+	if err != nil {
+		t.Fatal("重新打开之后查找发生错误:", err)
+	}
+	if len(before) != len(after) {
+		t.Errorf("重建索引后记录数不一致,之前:%d,之后:%d\n", len(before), len(after))
+	}
+}
+
+func TestKeywordIndex_CreateFields(t *testing.T) {
+	fields := []config.IndexField{{Path: "title", Weight: 2}, {Path: "alias", Weight: 1}}
+	index := NewFieldsIndex(fields)
+	index.CreateFields(map[string]string{"title": `金属套玻璃管温度计`, "alias": `WNY-11`}, "101")
+	index.CreateFields(map[string]string{"title": `弹簧`, "alias": `金属弹簧`}, "102")
+	keyWords, _ := index.Parse(`金属`)
+	ranked, err := index.Rank(keyWords, len(config.Text(`金属`)), &entities.FindOptions{Mode: entities.MATCH_ANY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range ranked {
+		if len(item.Fields) == 0 {
+			t.Errorf("记录[%s]没有命中字段\n", item.Key)
+		}
+		fmt.Println(item.Key, item.Score, item.Fields)
+	}
+}
+
+func TestKeywordIndex_FindFuzzy(t *testing.T) {
+	index := NewIndex()
+	index.Create(`金属套玻璃管温度计\WNY-11\150mm`, "101")
+	keyWords := []config.Text{config.Text(`WNX`)}
+	found, _ := index.Find(keyWords, len(keyWords[0]), &entities.FindOptions{Mode: entities.MATCH_ANY})
+	if len(found) != 0 {
+		t.Errorf("未开启模糊匹配时不应命中:%v\n", found)
+	}
+	found, _ = index.Find(keyWords, len(keyWords[0]), &entities.FindOptions{Mode: entities.MATCH_ANY, Fuzzy: 1})
+	ratio, ok := found["101"]
+	if !ok {
+		t.Fatal("开启模糊匹配后未命中")
+	}
+	if ratio >= 1 {
+		t.Errorf("模糊命中的占比应低于精确命中:%f\n", ratio)
+	}
+}
+
+func TestDBNode_UpdateDictionary(t *testing.T) {
+	node := GetDBNode()
+	req := &entities.DictRequest{Action: entities.DICT_ACTION_ADD, Words: []string{`水轮机`}}
+	if err := node.UpdateDictionary(req); err != nil {
+		t.Fatal(err)
+	}
+	defer node.UpdateDictionary(&entities.DictRequest{Action: entities.DICT_ACTION_REMOVE, Words: req.Words})
+	if count := db.Reindex(req.Words); count == 0 {
+		t.Error("没有重建包含自定义词的记录")
+	}
+	records, err := db.Find(`水轮机`, &entities.FindOptions{Mode: entities.MATCH_ALL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 {
+		t.Error("没有找到包含自定义词的记录")
+	}
+	words, _ := node.GetDictionary()
+	if len(words) == 0 || words[0] != `水轮机` {
+		t.Errorf("词典内容错误:%v\n", words)
+	}
+}
+
+func TestMemStorage_Delete(t *testing.T) {
+	key := "9999001"
+	text := `{"id":"9999001","desc":"测试删除记录\\QXSC-9999"}`
+	if err := db.SetWithIndex(key, text); err != nil {
+		t.Fatal(err)
+	}
+	opts := &entities.FindOptions{Mode: entities.MATCH_ANY}
+	records, err := db.Find(`QXSC-9999`, opts)
+	if err != nil || len(records) == 0 {
+		t.Fatal("删除之前没有找到记录:", err)
+	}
+	if err = db.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	records, err = db.Find(`QXSC-9999`, opts)
+	if err != nil {
+		t.Fatal("删除之后查找发生错误:", err)
+	}
+	for _, r := range records {
+		if r.Id == key {
+			t.Error("删除的记录仍然可以找到")
+		}
+	}
+}
+
+func TestMemStorage_SetConcurrent(t *testing.T) {
+	key := "9999002"
+	text := `{"id":"9999002","desc":"并发写入记录\\QXSC-9998"}`
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(indexed bool) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if indexed {
+					db.SetWithIndex(key, text)
+				} else {
+					db.Set(key, "plain")
+				}
+			}
+		}(i == 0)
+	}
+	wg.Wait()
+	//最后写入的是带索引的记录时必须可以找到,不带索引的记录不能被找到
+	value, err := db.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := db.Find(`QXSC-9998`, &entities.FindOptions{Mode: entities.MATCH_ANY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := len(records) > 0 && records[0].Id == key; found != (value == text) {
+		t.Errorf("并发写入后索引与记录不一致:%s,%v\n", value, records)
+	}
+	db.Delete(key)
+}
+
+func TestHighlight(t *testing.T) {
+	matches := highlight(`金属套玻璃管温度计\wny-11\金属`, []string{`金属`, `WNY`, `GUOBIAO`})
+	expected := []entities.Match{
+		{Term: `金属`, Start: 0, End: 2},
+		{Term: `WNY`, Start: 10, End: 13},
+		{Term: `金属`, Start: 17, End: 19},
+	}
+	if len(matches) != len(expected) {
+		t.Fatalf("命中位置数量错误:%v\n", matches)
+	}
+	for i := range expected {
+		if matches[i] != expected[i] {
+			t.Errorf("命中位置错误:%v,预期:%v\n", matches[i], expected[i])
+		}
+	}
+}
+
+func TestKeywordIndex_Stats(t *testing.T) {
+	//两个分库中命中记录相同,但命中关键字的记录比例不同,按各自的统计评分时评分不同
+	a, b := NewIndex(), NewIndex()
+	a.Create(`WNY-11`, "1")
+	a.Create(`ABC-22`, "2")
+	b.Create(`WNY-11`, "3")
+	for i := 4; i < 12; i++ {
+		b.Create(`ABC-22`, strconv.Itoa(i))
+	}
+	keyWords, _ := a.Parse(`WNY-11`)
+	opts := &entities.FindOptions{Mode: entities.MATCH_ANY}
+	score := func(index Index, opts *entities.FindOptions) float32 {
+		ranked, err := index.Rank(keyWords, len(config.Text(`WNY11`)), opts)
+		if err != nil || len(ranked) != 1 {
+			t.Fatalf("查找结果错误:%v,%v\n", ranked, err)
+		}
+		return ranked[0].Score
+	}
+	if score(a, opts) == score(b, opts) {
+		t.Errorf("按分库统计评分时评分相同")
+	}
+	stats := entities.NewTermStats()
+	a.Stats(keyWords, opts, stats)
+	b.Stats(keyWords, opts, stats)
+	if stats.Docs[config.GJSON_FIELD_DESC] != 11 {
+		t.Errorf("汇总的记录数错误:%v\n", stats.Docs)
+	}
+	global := &entities.FindOptions{Mode: entities.MATCH_ANY, Stats: stats}
+	if sa, sb := score(a, global), score(b, global); sa != sb {
+		t.Errorf("按汇总统计评分时评分不同:%f,%f\n", sa, sb)
+	}
+}
+
+func TestKeywordIndex_RankTerms(t *testing.T) {
+	index := NewIndex()
+	index.Create(`金属套玻璃管温度计\WNY-11`, "101")
+	keyWords, _ := index.Parse(`WNY-11`)
+	ranked, err := index.Rank(keyWords, len(config.Text(`WNY11`)), &entities.FindOptions{Mode: entities.MATCH_ANY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranked) != 1 || len(ranked[0].Terms) == 0 {
+		t.Fatalf("没有返回命中的关键字:%v\n", ranked)
+	}
+	for _, term := range ranked[0].Terms {
+		if !strings.Contains(`WNY-11`, term) {
+			t.Errorf("命中的关键字错误:%s\n", term)
+		}
+	}
+}
+
+func TestDBNode_FindPage(t *testing.T) {
+	text := `水轮机\HL-LJ-105\225000kW\550000\225000\92`
+	all, err := dbNode.Find("testdb", text, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paged := make([]entities.Record, 0, len(all))
+	cursor := ""
+	for i := 0; i < 1000; i++ {
+		page, err := dbNode.FindPage("testdb", text, nil, 7, cursor)
+		if err != nil {
+			t.Fatal("分页查找发生错误:", err)
+		}
+		if len(page.Records) > 7 {
+			t.Fatalf("每页记录数超过限制:%d\n", len(page.Records))
+		}
+		paged = append(paged, page.Records...)
+		cursor = page.Cursor
+		if len(cursor) == 0 {
+			break
+		}
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("分页记录总数错误:%d,预期:%d\n", len(paged), len(all))
+	}
+	for i := range all {
+		if paged[i].Id != all[i].Id {
+			t.Errorf("第%d条记录顺序错误:%s,预期:%s\n", i, paged[i].Id, all[i].Id)
+		}
+	}
+}
+
+func TestDBNode_FindPageCursor(t *testing.T) {
+	page, err := dbNode.FindPage("testdb", `水轮机`, nil, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Cursor) == 0 {
+		t.Skip("只有一页记录")
+	}
+	if _, err = dbNode.FindPage("testdb", `弹簧`, nil, 1, page.Cursor); err != ErrInvalidCursor {
+		t.Error("查询条件不一致时应返回ErrInvalidCursor:", err)
+	}
+	if _, err = dbNode.FindPage("testdb", `水轮机`, nil, 1, "@@"); err != ErrInvalidCursor {
+		t.Error("游标格式错误时应返回ErrInvalidCursor:", err)
+	}
+}
+
+func TestMatchFilters(t *testing.T) {
+	value := `{"id":"1","desc":"阀门","category":"Valve","price":12.5,"spec":{"size":40}}`
+	cases := []struct {
+		filters  []entities.Filter
+		expected bool
+	}{
+		{[]entities.Filter{{Path: "category", Op: entities.FILTER_EQ, Value: "valve"}}, true},
+		{[]entities.Filter{{Path: "category", Op: entities.FILTER_EQ, Value: "pump"}}, false},
+		{[]entities.Filter{{Path: "price", Op: entities.FILTER_GTE, Value: 12.5}, {Path: "price", Op: entities.FILTER_LT, Value: 20}}, true},
+		{[]entities.Filter{{Path: "price", Op: entities.FILTER_GT, Value: 12.5}}, false},
+		{[]entities.Filter{{Path: "price", Op: entities.FILTER_LTE, Value: "20"}}, false},
+		{[]entities.Filter{{Path: "spec.size", Op: entities.FILTER_IN, Values: []interface{}{25, 40}}}, true},
+		{[]entities.Filter{{Path: "spec.size", Op: entities.FILTER_IN, Values: []interface{}{25, 50}}}, false},
+		{[]entities.Filter{{Path: "brand", Op: entities.FILTER_EQ, Value: "国产"}}, false},
+	}
+	for i, c := range cases {
+		filters, err := compileFilters(c.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matchFilters(value, filters) != c.expected {
+			t.Errorf("第%d个过滤条件结果错误,预期:%v\n", i, c.expected)
+		}
+	}
+	if _, err := compileFilters([]entities.Filter{{Path: "price", Op: "like"}}); err == nil {
+		t.Error("不支持的过滤操作没有返回错误")
+	}
+}
+
+func TestMemStorage_FindWithFilters(t *testing.T) {
+	path, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	store, err := newMemStorage(1, path, "filterdb_1", config.GetConfig().GetIndexFields("filterdb"), []string{"category"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		category := "valve"
+		if i%2 == 1 {
+			category = "pump"
+		}
+		text := fmt.Sprintf(`{"id":"%d","desc":"过滤测试\\FT-%d","category":"%s","price":%d}`, i, i, category, i)
+		if err = store.SetWithIndex(strconv.Itoa(i), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := &entities.FindOptions{Mode: entities.MATCH_ANY, Limit: 3}
+	opts.Filters = []entities.Filter{
+		{Path: "category", Op: entities.FILTER_EQ, Value: "pump"},
+		{Path: "price", Op: entities.FILTER_GTE, Value: 10},
+	}
+	records, err := store.Find(`过滤测试`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("过滤之后应返回Limit条记录,实际:%d\n", len(records))
+	}
+	for _, r := range records {
+		id, _ := strconv.Atoi(r.Id)
+		if id%2 != 1 || id < 10 {
+			t.Errorf("记录[%s]不满足过滤条件\n", r.Id)
+		}
+	}
+	opts.Limit = 0
+	records, _ = store.Find(`过滤测试`, opts)
+	if len(records) != 5 {
+		t.Errorf("过滤之后的记录数错误:%d\n", len(records))
+	}
+}
+
+func TestDBNodeHandler_ProcessFind(t *testing.T) {
+	text := `水轮机\HL-LJ-105`
+	opts := &entities.FindOptions{Mode: entities.MATCH_ANY, Limit: 10}
+	expected, err := dbNode.Find("testdb", text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := serialize(&findRequest{Text: text, Options: opts})
+	val, err := dbNode.nodeHandler.processFind(network.Message{DBName: "testdb", Text: req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &findResponse{}
+	if _, err = deserialize(val, resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Failures) > 0 {
+		t.Errorf("查找结果不应包含失败的分库:%v\n", resp.Failures)
+	}
+	records := fromRankedRecords(resp.Records)
+	if len(records) != len(expected) {
+		t.Fatalf("记录数不一致:%d,预期:%d\n", len(records), len(expected))
+	}
+	for i := range expected {
+		if records[i].Id != expected[i].Id || records[i].Score != expected[i].Score {
+			t.Errorf("第%d条记录不一致:%v,预期:%v\n", i, records[i], expected[i])
+		}
+	}
+}
+
+func TestKeywordIndex_FindSynonyms(t *testing.T) {
+	synonyms := parse.GetSynonymDictionary()
+	synonyms.AddGroup(`不锈钢`, `SS`)
+	defer synonyms.RemoveWords(`不锈钢`, `SS`)
+	index := NewIndex()
+	index.Create(`SS304螺栓\M12`, "201")
+	index.Create(`不锈钢螺栓\M12`, "202")
+	index.Create(`碳钢螺栓\M12`, "203")
+	keyWords, _ := index.Parse(`不锈钢`)
+	ranked, err := index.Rank(keyWords, len(config.Text(`不锈钢`)), &entities.FindOptions{Mode: entities.MATCH_ANY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]config.RankItem)
+	for _, item := range ranked {
+		keys[item.Key] = item
+	}
+	if _, ok := keys["201"]; !ok {
+		t.Fatalf("没有通过同义词找到记录:%v\n", ranked)
+	}
+	if _, ok := keys["203"]; ok {
+		t.Error("不包含同义词的记录不应命中")
+	}
+	if keys["201"].Score >= keys["202"].Score {
+		t.Errorf("同义词命中的评分应低于原词命中:%f,%f\n", keys["201"].Score, keys["202"].Score)
+	}
+}
+
+func TestMinHash_Similarity(t *testing.T) {
+	a := []config.Text{config.Text("不锈钢"), config.Text("螺栓"), config.Text("M12"), config.Text("GB5782")}
+	b := []config.Text{config.Text("不锈钢"), config.Text("螺栓"), config.Text("M12"), config.Text("GB5783")}
+	c := []config.Text{config.Text("碳钢"), config.Text("螺母"), config.Text("M8")}
+	sa, sb, sc := newMinHash(a), newMinHash(b), newMinHash(c)
+	if s := sa.similarity(&sa); s != 1 {
+		t.Errorf("相同集合的相似度应为1:%f\n", s)
+	}
+	//a和b的Jaccard相似度为0.6
+	if s := sa.similarity(&sb); s < 0.4 || s > 0.8 {
+		t.Errorf("相似集合的相似度估算偏差过大:%f\n", s)
+	}
+	if s := sa.similarity(&sc); s > 0.2 {
+		t.Errorf("不相关集合的相似度过高:%f\n", s)
+	}
+}
+
+func TestMemStorage_FindSimilar(t *testing.T) {
+	path, err := ioutil.TempDir("", "similar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	store, err := newMemStorage(1, path, "similardb_1", config.GetConfig().GetIndexFields("similardb"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{
+		`{"id":"1","desc":"不锈钢六角螺栓\\M12*50\\GB5782"}`,
+		`{"id":"2","desc":"不锈钢六角螺栓\\M12*50\\GB5782\\304"}`,
+		`{"id":"3","desc":"压力变送器\\0-1.6MPa\\4-20mA"}`,
+	}
+	for i, text := range texts {
+		if err = store.SetWithIndex(strconv.Itoa(i+1), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := store.FindSimilar(`不锈钢六角螺栓\M12*50\GB5782`, 0.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Id != "1" || records[0].Similarity != 1 || records[1].Id != "2" {
+		t.Fatalf("近似重复的记录错误:%v\n", records)
+	}
+	if err = store.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = store.FindSimilar(`不锈钢六角螺栓\M12*50\GB5782`, 0.6)
+	if len(records) != 1 {
+		t.Errorf("删除之后仍然返回已删除的记录:%v\n", records)
+	}
+}
+
+type loadSequence struct {
+	next uint64
+}
+
+func (s *loadSequence) Next(name string) uint64 {
+	s.next++
+	return s.next
+}
+
+func (s *loadSequence) SetStart(name string, start uint64) error {
+	s.next = start
+	return nil
+}
+
+func (s *loadSequence) Reserve(name string, count uint64) (uint64, error) {
+	start := s.next + 1
+	s.next = s.next + count
+	return start, nil
+}
+
+type loadSender struct {
+	batches  int
+	messages []*network.Message
+}
+
+func (s *loadSender) sendBatch(messages []*network.Message) (map[string]string, error) {
+	s.batches++
+	s.messages = append(s.messages, messages...)
+	failures := make(map[string]string)
+	for _, m := range messages {
+		if m.Type == config.MSG_KV_TEXTSET && !strings.Contains(m.Text, `"desc"`) {
+			failures[m.Key] = "创建索引时字段为空"
+		}
+	}
+	return failures, nil
+}
+
+func TestBulkLoader_LoadJSONL(t *testing.T) {
+	senders := map[string]batchSender{"test2": &loadSender{}, "test3": &loadSender{}}
+	loader := newBulkLoader("testdb", shardedkv.NewRangeChooser(3, 4, 1), &loadSequence{}, senders)
+	loader.BatchSize = 3
+	progress := 0
+	loader.Progress = func(result *LoadResult) {
+		progress++
+	}
+	input := strings.Join([]string{
+		`{"desc":"阀门\\DN50"}`,
+		`{"id":"A-2","desc":"法兰\\DN50"}`,
+		``,
+		`not json`,
+		`{"name":"没有描述"}`,
+		`{"desc":"螺栓\\M12"}`,
+		`{"desc":"螺母\\M12"}`,
+	}, "\n")
+	result, err := loader.LoadJSONL(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Read != 6 || result.Loaded != 4 || result.Failed != 2 {
+		t.Fatalf("导入结果错误:%+v\n", result)
+	}
+	if result.Errors[0].Line != 4 || result.Errors[1].Line != 5 || result.Errors[1].Key == 0 {
+		t.Errorf("导入失败的记录错误:%+v\n", result.Errors)
+	}
+	if progress != 2 {
+		t.Errorf("进度回调次数错误:%d\n", progress)
+	}
+	//主键1-4属于test2,5-8属于test3
+	for name, sender := range senders {
+		for _, m := range sender.(*loadSender).messages {
+			key, _ := strconv.ParseUint(m.Key, 10, 64)
+			shardName, index := loader.chooser.Choose(key)
+			if shardName != name || m.DBName != "testdb_"+strconv.FormatUint(index, 10) {
+				t.Errorf("记录[%s]发送的分片错误:%s,%s\n", m.Key, name, m.DBName)
+			}
+			if !gjson.Get(m.Text, "id").Exists() {
+				t.Errorf("记录[%s]没有id字段:%s\n", m.Key, m.Text)
+			}
+		}
+	}
+	if senders["test2"].(*loadSender).batches != 2 || senders["test3"].(*loadSender).batches != 1 {
+		t.Errorf("每批记录应按分片各发送一次")
+	}
+}
+
+func TestBulkLoader_LoadCSV(t *testing.T) {
+	sender := &loadSender{}
+	loader := newBulkLoader("testdb", shardedkv.NewRangeChooser(3, 100, 1), &loadSequence{}, map[string]batchSender{"test2": sender})
+	input := "id,desc\n1,\"压力表,0-1.6MPa\"\n2\n"
+	result, err := loader.LoadCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Loaded != 1 || result.Failed != 1 || result.Errors[0].Line != 3 {
+		t.Fatalf("导入结果错误:%+v\n", result)
+	}
+	if gjson.Get(sender.messages[0].Text, "desc").String() != "压力表,0-1.6MPa" {
+		t.Errorf("CSV记录转换错误:%s\n", sender.messages[0].Text)
+	}
+}
+
+func TestWithRecordId(t *testing.T) {
+	cases := map[string]string{
+		`{"desc":"阀门"}`:          `{"id":"7","desc":"阀门"}`,
+		`{}`:                     `{"id":"7"}`,
+		`{"id":"A","desc":"阀门"}`: `{"id":"A","desc":"阀门"}`,
+	}
+	for text, expected := range cases {
+		if actual := withRecordId(text, "7"); actual != expected {
+			t.Errorf("增加id字段错误:%s,预期:%s\n", actual, expected)
+		}
+	}
+}
+
+func TestMemStorage_Export(t *testing.T) {
+	path, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	store, err := newMemStorage(1, path, "exportdb_1", config.GetConfig().GetIndexFields("exportdb"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		text := fmt.Sprintf(`{"id":"%d","desc":"导出测试\\EX-%d"}`, i, i)
+		if err = store.SetWithIndex(strconv.Itoa(i), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Set("6", "plain text"); err != nil {
+		t.Fatal(err)
+	}
+	var buff strings.Builder
+	count, err := writeExport(&buff, 4, func(after string, limit int) (*entities.ExportPage, error) {
+		return store.Export(after, limit, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Fatalf("导出的记录数错误:%d\n", count)
+	}
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	for i, line := range lines {
+		record := &entities.ExportRecord{}
+		if err = json.Unmarshal([]byte(line), record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Key != strconv.Itoa(i+1) {
+			t.Errorf("导出的主键顺序错误:%s\n", record.Key)
+		}
+		if i < 5 && len(record.Terms) == 0 {
+			t.Errorf("记录[%s]没有导出索引关键字\n", record.Key)
+		}
+	}
+	page, _ := store.Export("4", 10, false)
+	if len(page.Records) != 2 || page.Records[0].Key != "5" || len(page.Next) != 0 {
+		t.Errorf("分页导出错误:%+v\n", page)
+	}
+	if page.Records[0].TTL != 0 {
+		t.Errorf("没有过期时间的记录不应导出生存时间:%d\n", page.Records[0].TTL)
+	}
+	if err = store.SetWithTTL("7", `{"id":"7","desc":"导出测试"}`, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ = store.Export("6", 10, false); len(page.Records) != 1 || page.Records[0].TTL <= 0 || page.Records[0].TTL > int64(time.Hour/time.Millisecond) {
+		t.Errorf("导出的剩余生存时间错误:%+v\n", page.Records)
+	}
+	if importValue(exportValue("plain text")) != "plain text" || importValue(exportValue(`{"a":1}`)) != `{"a":1}` {
+		t.Errorf("导出的文本无法还原")
+	}
+
+	sender := &loadSender{}
+	seq := &loadSequence{next: 2}
+	loader := newBulkLoader("testdb", shardedkv.NewRangeChooser(3, 100, 1), seq, map[string]batchSender{"test2": sender})
+	result, err := loader.Import(strings.NewReader(buff.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Loaded != 6 || result.Failed != 0 {
+		t.Fatalf("导入结果错误:%+v\n", result)
+	}
+	for i, m := range sender.messages {
+		if m.Key != strconv.Itoa(i+1) {
+			t.Errorf("导入时主键改变:%s\n", m.Key)
+		}
+	}
+	if last := sender.messages[5]; last.Type != config.MSG_KV_SET || last.Text != "plain text" {
+		t.Errorf("没有索引的记录导入错误:%+v\n", last)
+	}
+	if seq.next < 6 {
+		t.Errorf("导入后序列没有更新:%d\n", seq.next)
+	}
+}
+
+func TestMemStorage_RebuildIndex(t *testing.T) {
+	path, err := ioutil.TempDir("", "rebuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	fields := config.GetConfig().GetIndexFields("rebuilddb")
+	store, err := newMemStorage(1, path, "rebuilddb_1", fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := rebuildBatchSize + 5
+	for i := 1; i <= count; i++ {
+		if err = store.SetWithIndex(strconv.Itoa(i), fmt.Sprintf(`{"id":"%d","desc":"重建测试\\RB-%d"}`, i, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	store, err = newMemStorage(1, path, "rebuilddb_1", fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < 100 && store.IndexStatus() != INDEX_STATUS_READY; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	opts := &entities.FindOptions{Mode: entities.MATCH_ANY}
+	records, err := store.Find("重建测试", opts)
+	if err != nil || len(records) != count {
+		t.Fatalf("分批重建索引后记录数错误:%d,预期:%d,%v\n", len(records), count, err)
+	}
+	//重建过程中返回已命中的记录而不是失败
+	atomic.StoreInt32(&store.indexStatus, INDEX_STATUS_BUILDING)
+	records, err = store.Find("重建测试", opts)
+	if err != ErrIndexPartial || len(records) != count {
+		t.Errorf("重建过程中查找结果错误:%d,%v\n", len(records), err)
+	}
+	page, _, _, err := store.FindPage("重建测试", opts, pagePos{}, 10)
+	if err != ErrIndexPartial || len(page) != 10 {
+		t.Errorf("重建过程中分页查找结果错误:%d,%v\n", len(page), err)
+	}
+	atomic.StoreInt32(&store.indexStatus, INDEX_STATUS_READY)
+	//按词重建索引同样分批处理所有记录
+	if n := store.Reindex([]string{"rb-"}); n != count {
+		t.Errorf("按词重建索引的记录数错误:%d,预期:%d\n", n, count)
+	}
+}
+
+func TestMemStorage_ReplayLog(t *testing.T) {
+	path, err := ioutil.TempDir("", "aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	fields := config.GetConfig().GetIndexFields("aofdb")
+	store, err := newMemStorage(1, path, "aofdb_1", fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err = store.SetWithIndex(strconv.Itoa(i), fmt.Sprintf(`{"id":"%d","desc":"日志测试\\LOG-%d"}`, i, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//模拟写入中断,日志末尾只有半条命令
+	file, err := os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("*3\r\n$3\r\nset\r\n$1\r\n4")
+	file.Close()
+
+	store, err = newMemStorage(1, path, "aofdb_1", fields, nil)
+	if err != nil {
+		t.Fatal("重放日志失败:", err)
+	}
+	for i := 0; i < 100 && store.IndexStatus() != INDEX_STATUS_READY; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = store.Get("2"); err == nil {
+		t.Errorf("已删除的记录重放后仍然存在")
+	}
+	if _, err = store.Get("4"); err == nil {
+		t.Errorf("不完整的命令不应被重放")
+	}
+	records, err := store.Find("日志测试", &entities.FindOptions{Mode: entities.MATCH_ANY})
+	if err != nil || len(records) != 2 {
+		t.Errorf("重放后重建索引错误:%d,%v\n", len(records), err)
+	}
+	if err = store.SetWithIndex("5", `{"id":"5","desc":"日志测试\\LOG-5"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(); err != nil {
+		t.Fatal("生成快照失败:", err)
+	}
+	store.Close()
+	store, err = newMemStorage(1, path, "aofdb_1", fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if text, err := store.Get("5"); err != nil || gjson.Get(text, "id").String() != "5" {
+		t.Errorf("快照之后的记录丢失:%s,%v\n", text, err)
+	}
+}
+
+func TestMemStorage_Stats(t *testing.T) {
+	path, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	store, err := newMemStorage(1, path, "statsdb_1", config.GetConfig().GetIndexFields("statsdb"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 1; i <= 4; i++ {
+		if err = store.SetWithIndex(strconv.Itoa(i), fmt.Sprintf(`{"id":"%d","desc":"统计测试\\ST-%d"}`, i, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//覆盖已有记录、保存不建索引的记录和删除记录
+	store.SetWithIndex("1", `{"id":"1","desc":"统计测试\\ST-1"}`)
+	store.Set("5", "plain")
+	store.Delete("2")
+	store.Delete("6")
+	//墓碑不计入记录数量,保留一段时间后自动删除
+	store.Set("7", shardedkv.Tombstone)
+	store.Set("8", shardedkv.Tombstone)
+	store.Delete("8")
+	stats := store.Stats()
+	if stats.KeyCount != 4 || store.GetKeyCount() != 4 || stats.TombstoneCount != 1 {
+		t.Errorf("记录数量错误:%d,墓碑数量:%d\n", stats.KeyCount, stats.TombstoneCount)
+	}
+	if page, _ := store.Export("6", 10, false); len(page.Records) != 1 || page.Records[0].TTL <= 0 {
+		t.Errorf("墓碑没有设置保留时间:%+v\n", page.Records)
+	}
+	if stats.TermCount == 0 || stats.MemoryBytes == 0 || stats.FileBytes == 0 || !stats.LastSave.IsZero() {
+		t.Errorf("统计信息错误:%+v\n", stats)
+	}
+	if err = store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Stats().LastSave.IsZero() {
+		t.Errorf("生成快照后没有记录快照时间")
+	}
+	//旧版本保存在数据库中的记录数量在打开时删除
+	store.Set(keyCountKey, "100")
+	if err = store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(keyCountKey); err == nil || store.GetKeyCount() != 4 {
+		t.Errorf("打开数据库时没有删除旧的记录数量:%d\n", store.GetKeyCount())
+	}
+	if store.Stats().TombstoneCount != 1 {
+		t.Errorf("打开数据库时墓碑数量错误:%d\n", store.Stats().TombstoneCount)
+	}
+}
+
+func TestMemStorage_SetWithTTL(t *testing.T) {
+	path, err := ioutil.TempDir("", "ttl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	store, err := newMemStorage(1, path, "ttldb_1", config.GetConfig().GetIndexFields("ttldb"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.SetWithTTL("1", `{"id":"1","desc":"会话文本\\TTL-1"}`, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetWithIndex("2", `{"id":"2","desc":"会话文本\\TTL-2"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetWithTTL("3", `{"id":"3","desc":"会话文本"}`, 0); err == nil {
+		t.Errorf("TTL为0时应返回错误")
+	}
+	opts := &entities.FindOptions{Mode: entities.MATCH_ANY}
+	if records, _ := store.Find("会话文本", opts); len(records) != 2 {
+		t.Fatalf("过期之前的记录数错误:%d\n", len(records))
+	}
+	for i := 0; i < 30 && store.GetKeyCount() > 1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err = store.Get("1"); err == nil {
+		t.Errorf("记录过期后仍然存在")
+	}
+	records, _ := store.Find("会话文本", opts)
+	if len(records) != 1 || records[0].Id != "2" {
+		t.Errorf("记录过期后没有删除索引:%v\n", records)
+	}
+	if similar, _ := store.FindSimilar(`会话文本\TTL-1`, 0.5); len(similar) != 1 {
+		t.Errorf("记录过期后没有删除签名:%v\n", similar)
+	}
+	if ttlSeconds(1500*time.Millisecond) != 2 || ttlSeconds(0) != 0 {
+		t.Errorf("TTL换算为秒错误")
+	}
+}
+
+func TestMemStorage_Scan(t *testing.T) {
+	path, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	store, err := newMemStorage(1, path, "scandb_1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]uint64, 0)
+	for i := uint64(1); i <= 120; i++ {
+		keys = append(keys, i)
+	}
+	for i := uint64(1000); i <= 1010; i++ {
+		keys = append(keys, i)
+	}
+	keys = append(keys, 123456)
+	for _, key := range keys {
+		if err = store.Set(strconv.FormatUint(key, 10), fmt.Sprintf("text-%d", key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanAll := func(start uint64, end uint64) []uint64 {
+		result := make([]uint64, 0)
+		for {
+			page, err := store.Scan(start, end, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range page.Records {
+				if r.Value != fmt.Sprintf("text-%d", r.Key) {
+					t.Errorf("记录[%d]的文本错误:%s\n", r.Key, r.Value)
+				}
+				result = append(result, r.Key)
+			}
+			if page.Next == 0 {
+				return result
+			}
+			start = page.Next
+		}
+	}
+	result := scanAll(0, math.MaxUint64)
+	if len(result) != len(keys) {
+		t.Fatalf("扫描的记录数错误:%d\n", len(result))
+	}
+	for i := range keys {
+		if result[i] != keys[i] {
+			t.Fatalf("扫描结果没有按主键数值排序:%v\n", result)
+		}
+	}
+	result = scanAll(95, 1003)
+	if len(result) != 30 || result[0] != 95 || result[25] != 120 || result[29] != 1003 {
+		t.Errorf("范围扫描结果错误:%v\n", result)
+	}
+	if result = scanAll(121, 999); len(result) != 0 {
+		t.Errorf("范围内没有记录时扫描结果错误:%v\n", result)
+	}
+}
@@ -0,0 +1,220 @@
+package shardeddb
+
+import (
+	"encoding/binary"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/*
+MinHash签名参数,签名由minHashSize个哈希值组成,分为minHashBands段建立局部敏感哈希(LSH)桶,
+两条记录任意一段完全相同即成为候选记录。每段minHashSize/minHashBands个值,
+Jaccard相似度为s的两条记录成为候选的概率为1-(1-s^4)^16,s为0.5时约为0.65,s为0.8时接近1
+*/
+const (
+	minHashSize  = 64
+	minHashBands = 16
+	minHashRows  = minHashSize / minHashBands
+)
+
+//缺省的相似度阈值
+const DEFAULT_SIMILAR_THRESHOLD float32 = 0.8
+
+type minHashSignature [minHashSize]uint32
+
+/*
+根据关键字集合计算MinHash签名,使用双重哈希 h1 + i*h2 再经过混合模拟minHashSize个哈希函数
+*/
+func newMinHash(words []config.Text) minHashSignature {
+	var sig minHashSignature
+	for i := range sig {
+		sig[i] = math.MaxUint32
+	}
+	for _, w := range words {
+		h := fnv.New64a()
+		h.Write([]byte(string(w)))
+		sum := h.Sum64()
+		h1 := uint32(sum)
+		h2 := uint32(sum>>32) | 1
+		for i := range sig {
+			v := mixHash(h1 + uint32(i)*h2)
+			if v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+//murmur3的32位混合函数,消除线性组合的哈希值之间的相关性
+func mixHash(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+/*
+根据签名估算两个关键字集合的Jaccard相似度
+*/
+func (s *minHashSignature) similarity(other *minHashSignature) float32 {
+	equal := 0
+	for i := range s {
+		if s[i] == other[i] {
+			equal++
+		}
+	}
+	return float32(equal) / minHashSize
+}
+
+func (s *minHashSignature) band(i int) uint64 {
+	h := fnv.New64a()
+	buff := make([]byte, 4)
+	for _, v := range s[i*minHashRows : (i+1)*minHashRows] {
+		binary.LittleEndian.PutUint32(buff, v)
+		h.Write(buff)
+	}
+	return h.Sum64()
+}
+
+/*
+分库记录的MinHash签名和LSH桶,用于查找近似重复的记录
+*/
+type similarIndex struct {
+	signatures map[string]*minHashSignature
+	bands      [minHashBands]map[uint64]map[string]bool
+	mu         sync.RWMutex
+}
+
+func newSimilarIndex() *similarIndex {
+	s := &similarIndex{}
+	s.clear()
+	return s
+}
+
+func (s *similarIndex) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signatures = make(map[string]*minHashSignature)
+	for i := range s.bands {
+		s.bands[i] = make(map[uint64]map[string]bool)
+	}
+}
+
+/*
+保存记录的签名,记录已存在时替换原有签名
+*/
+func (s *similarIndex) add(key string, words []config.Text) {
+	if len(words) == 0 {
+		s.remove(key)
+		return
+	}
+	sig := newMinHash(words)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+	s.signatures[key] = &sig
+	for i := range s.bands {
+		b := sig.band(i)
+		keys, ok := s.bands[i][b]
+		if !ok {
+			keys = make(map[string]bool)
+			s.bands[i][b] = keys
+		}
+		keys[key] = true
+	}
+}
+
+func (s *similarIndex) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+}
+
+func (s *similarIndex) removeLocked(key string) {
+	sig, ok := s.signatures[key]
+	if !ok {
+		return
+	}
+	for i := range s.bands {
+		b := sig.band(i)
+		delete(s.bands[i][b], key)
+		if len(s.bands[i][b]) == 0 {
+			delete(s.bands[i], b)
+		}
+	}
+	delete(s.signatures, key)
+}
+
+/*
+查找与关键字集合相似度不低于threshold的记录,返回记录ID和估算的相似度
+*/
+func (s *similarIndex) find(words []config.Text, threshold float32) map[string]float32 {
+	result := make(map[string]float32)
+	if len(words) == 0 {
+		return result
+	}
+	sig := newMinHash(words)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	checked := make(map[string]bool)
+	for i := range s.bands {
+		for key := range s.bands[i][sig.band(i)] {
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+			if similarity := sig.similarity(s.signatures[key]); similarity >= threshold {
+				result[key] = similarity
+			}
+		}
+	}
+	return result
+}
+
+/*
+在数据库的所有分库中查找与文本近似重复的记录,按相似度从高到低排序
+*/
+func (d *dbNodeHandler) findSimilar(db string, text string, threshold float32) ([]entities.SimilarRecord, error) {
+	if len(db) == 0 {
+		db = d.defaultDB
+	}
+	if threshold <= 0 || threshold > 1 {
+		threshold = DEFAULT_SIMILAR_THRESHOLD
+	}
+	result := make([]entities.SimilarRecord, 0)
+	for i := 1; i <= d.dbCount; i++ {
+		dbName := db + "_" + strconv.Itoa(i)
+		store, ok := d.dbs[dbName]
+		if !ok {
+			continue
+		}
+		records, err := store.FindSimilar(text, threshold)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Similarity != result[j].Similarity {
+			return result[i].Similarity > result[j].Similarity
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result, nil
+}
+
+/*
+查找与文本近似重复的记录,相似度为关键字集合的Jaccard相似度估算值,threshold取值(0,1],
+超出范围时使用DEFAULT_SIMILAR_THRESHOLD
+*/
+func (d *DBNode) FindSimilar(db string, text string, threshold float32) ([]entities.SimilarRecord, error) {
+	return d.nodeHandler.findSimilar(db, text, threshold)
+}
@@ -0,0 +1,113 @@
+package shardeddb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/config"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/memdb"
+	"github.com/xp/shorttext-db/network"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+获得分库的统计信息,内存占用需要遍历所有记录
+*/
+func (m *memStorage) Stats() entities.DBStats {
+	stats := entities.DBStats{Name: m.name}
+	stats.KeyCount = m.GetKeyCount()
+	stats.TombstoneCount = int(atomic.LoadInt64(&m.tombstones))
+	stats.TermCount = m.index.TermCount()
+	stats.IndexStatus = m.IndexStatus()
+	m.db.View(func(tx *memdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			stats.MemoryBytes = stats.MemoryBytes + int64(len(key)+len(value))
+			return true
+		})
+	})
+	if info, err := os.Stat(m.path); err == nil {
+		stats.FileBytes = info.Size()
+	}
+	if lastSave := atomic.LoadInt64(&m.lastSave); lastSave > 0 {
+		stats.LastSave = time.Unix(0, lastSave)
+	}
+	return stats
+}
+
+/*
+获得本节点所有分库的统计信息,按分库名字排序
+*/
+func (d *dbNodeHandler) stats() []entities.DBStats {
+	result := make([]entities.DBStats, 0, len(d.dbs))
+	for _, store := range d.dbs {
+		result = append(result, store.Stats())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+/*
+获得本节点所有分库的统计信息
+*/
+func (d *DBNode) Stats() []entities.DBStats {
+	return d.nodeHandler.stats()
+}
+
+func (d *dbNodeClient) stats() ([]entities.DBStats, error) {
+	term, err := d.generateId()
+	if err != nil {
+		return nil, err
+	}
+	m := network.NewOnlyOneMsg(term, "", "", config.MSG_KV_STATS)
+	m.Messages[0].From = config.GetCase().GetMaster().ID
+	m.Messages[0].To = d.Id
+	result, err := d.client.Send(m)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || len(result.Messages) == 0 {
+		return nil, errors.New(fmt.Sprintf("dbNodeClient 获取统计信息失败[Node:%d]", d.Id))
+	}
+	resultMsg := result.Messages[0]
+	if resultMsg.ResultCode == config.MSG_KV_RESULT_FAILURE {
+		return nil, errors.New(resultMsg.Text)
+	}
+	stats := make([]entities.DBStats, 0)
+	if _, err = deserialize(resultMsg.Text, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+/*
+通过代理服务器获取集群中每个节点所有分库的统计信息,单个节点失败时在该节点的Error中返回错误信息
+*/
+func GetClusterStats() ([]entities.NodeStats, error) {
+	shards, err := newShards("")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]entities.NodeStats, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard string, client *dbNodeClient) {
+			defer wg.Done()
+			result[i].Node = shard
+			stats, err := client.stats()
+			if err != nil {
+				result[i].Error = err.Error()
+				return
+			}
+			result[i].Databases = stats
+		}(i, shard.Name, shard.Backend.(*dbNodeClient))
+	}
+	wg.Wait()
+	return result, nil
+}
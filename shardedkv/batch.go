@@ -0,0 +1,421 @@
+package shardedkv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/entities"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+//批量操作中主键在一个存储位置上的操作
+type batchOp struct {
+	nKey     uint64
+	location *location
+	item     *entities.BatchItem
+}
+
+type batchOps []*batchOp
+
+func (ops *batchOps) add(nKey uint64, l *location, value string) *batchOp {
+	op := &batchOp{nKey: nKey, location: l}
+	op.item = &entities.BatchItem{Key: strconv.FormatUint(nKey, 10), Index: l.index, Value: value}
+	*ops = append(*ops, op)
+	return op
+}
+
+/*
+按分片分组后并发发送,每个分片一个批量请求,整批失败时该批的所有记录都保存失败原因
+*/
+func (ops batchOps) send(send func(storage api.Storage, items []*entities.BatchItem) error) {
+	groups := make(map[string][]*entities.BatchItem)
+	storages := make(map[string]api.Storage)
+	for _, op := range ops {
+		groups[op.location.name] = append(groups[op.location.name], op.item)
+		storages[op.location.name] = op.location.storage
+	}
+	var wg sync.WaitGroup
+	for name, items := range groups {
+		wg.Add(1)
+		go func(storage api.Storage, items []*entities.BatchItem) {
+			defer wg.Done()
+			if err := send(storage, items); err != nil {
+				for _, item := range items {
+					item.Err = err
+				}
+			}
+		}(storages[name], items)
+	}
+	wg.Wait()
+}
+
+func errorsOf(ops []*batchOp) []error {
+	errs := make([]error, 0, len(ops))
+	for _, op := range ops {
+		errs = append(errs, op.item.Err)
+	}
+	return errs
+}
+
+func locationsOf(ops []*batchOp) []*location {
+	locations := make([]*location, 0, len(ops))
+	for _, op := range ops {
+		locations = append(locations, op.location)
+	}
+	return locations
+}
+
+/*
+按分段顺序锁定多个主键,避免批量写入之间互相等待造成死锁,返回解锁函数
+*/
+func (kv *KVStore) lockKeys(nKeys []uint64) func() {
+	stripes := make([]int, 0, len(nKeys))
+	checker := make(map[int]bool, len(nKeys))
+	for _, nKey := range nKeys {
+		stripe := int(nKey % keyLockCount)
+		if !checker[stripe] {
+			checker[stripe] = true
+			stripes = append(stripes, stripe)
+		}
+	}
+	sort.Ints(stripes)
+	for _, stripe := range stripes {
+		kv.keyLocks[stripe].Lock()
+	}
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			kv.keyLocks[stripes[i]].Unlock()
+		}
+	}
+}
+
+func uniqueKeys(nKeys []uint64) []uint64 {
+	result := make([]uint64, 0, len(nKeys))
+	checker := make(map[uint64]bool, len(nKeys))
+	for _, nKey := range nKeys {
+		if !checker[nKey] {
+			checker[nKey] = true
+			result = append(result, nKey)
+		}
+	}
+	return result
+}
+
+//批量操作中一个主键在新旧位置上的操作
+type keyOps struct {
+	target []*batchOp
+	old    []*batchOp
+}
+
+/*
+批量读取主键在新旧位置所有副本的文本,按分片分组后每个节点发送一个批量请求
+*/
+func (kv *KVStore) readKeys(nKeys []uint64) map[uint64]*keyOps {
+	reads := make(map[uint64]*keyOps, len(nKeys))
+	var ops batchOps
+	for _, nKey := range nKeys {
+		target, old := kv.locate(nKey)
+		r := &keyOps{}
+		for _, l := range old {
+			r.old = append(r.old, ops.add(nKey, l, ""))
+		}
+		for _, l := range target {
+			r.target = append(r.target, ops.add(nKey, l, ""))
+		}
+		reads[nKey] = r
+	}
+	ops.send(func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiGetText(items)
+	})
+	return reads
+}
+
+/*
+批量读取文本,按分片分组后每个节点发送一个批量请求。有多个副本或正在重新平衡时与GetText相同,
+先读原位置再读新位置,按多数副本选择文本,不一致的副本按节点批量修复,已删除的主键不在结果中。
+所有位置都读取失败的主键在errs中
+*/
+func (kv *KVStore) MultiGet(nKeys []uint64) (map[uint64]string, map[uint64]error) {
+	nKeys = uniqueKeys(nKeys)
+	reads := kv.readKeys(nKeys)
+	values := make(map[uint64]string, len(nKeys))
+	errs := make(map[uint64]error)
+	texts := make(map[uint64]string, len(nKeys))
+	var stale []uint64
+	for _, nKey := range nKeys {
+		r := reads[nKey]
+		if len(r.old) > 0 {
+			if text, _ := voteOps(r.old); len(text) > 0 {
+				texts[nKey] = text
+				continue
+			}
+		}
+		text, staleOps := voteOps(r.target)
+		if len(staleOps) > 0 {
+			stale = append(stale, nKey)
+		}
+		texts[nKey] = text
+	}
+	for nKey, text := range kv.multiRepair(stale) {
+		texts[nKey] = text
+	}
+	for _, nKey := range nKeys {
+		text := texts[nKey]
+		if len(text) > 0 {
+			if !IsTombstone(text) {
+				values[nKey] = text
+			}
+			continue
+		}
+		r := reads[nKey]
+		if err := readError(append(r.old, r.target...)); err != nil {
+			errs[nKey] = err
+		}
+	}
+	return values, errs
+}
+
+/*
+批量读修复:加锁后按节点批量重新读取主键的所有副本,把多数副本的文本或墓碑按节点批量写入
+不一致或没有记录的副本,返回各主键多数副本的文本
+*/
+func (kv *KVStore) multiRepair(nKeys []uint64) map[uint64]string {
+	texts := make(map[uint64]string, len(nKeys))
+	if len(nKeys) == 0 {
+		return texts
+	}
+	unlock := kv.lockKeys(nKeys)
+	defer unlock()
+	reads := kv.readKeys(nKeys)
+	var writes, plain batchOps
+	for _, nKey := range nKeys {
+		ops := reads[nKey].target
+		text, stale := voteOps(ops)
+		texts[nKey] = text
+		for _, i := range stale {
+			if IsTombstone(text) {
+				plain.add(nKey, ops[i].location, text)
+			} else {
+				writes.add(nKey, ops[i].location, text)
+			}
+		}
+	}
+	writes.send(func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiSetText(items)
+	})
+	//没有索引字段的记录无法按文本保存,按普通记录保存
+	for _, op := range writes {
+		if op.item.Err != nil {
+			op.item.Err = nil
+			plain = append(plain, op)
+		}
+	}
+	plain.send(func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiSet(items)
+	})
+	for _, op := range plain {
+		if op.item.Err != nil {
+			logger.Errorf("分片[%s]分库[%d]修复记录[%d]失败:%s\n", op.location.name, op.location.index, op.nKey, op.item.Err.Error())
+		}
+	}
+	return texts
+}
+
+/*
+按多数副本选择读取到的文本,读取失败的副本不参与计票,也不修复
+*/
+func voteOps(ops []*batchOp) (string, []int) {
+	texts := make([]string, 0, len(ops))
+	errs := make([]error, 0, len(ops))
+	for _, op := range ops {
+		texts = append(texts, op.item.Value)
+		errs = append(errs, op.item.Err)
+	}
+	return vote(texts, errs)
+}
+
+/*
+所有位置都读取失败时返回最后一个错误
+*/
+func readError(ops []*batchOp) error {
+	var err error
+	for _, op := range ops {
+		if op.item.Err == nil {
+			return nil
+		}
+		err = op.item.Err
+	}
+	return err
+}
+
+/*
+批量保存文本并创建索引,按分片分组后每个节点发送一个批量请求。每个主键与SetText相同,
+写入成功的副本数量达到要求时成功,重新平衡期间再删除原位置中不再使用的副本
+*/
+func (kv *KVStore) MultiSet(values map[uint64]string) map[uint64]error {
+	nKeys := make([]uint64, 0, len(values))
+	for nKey := range values {
+		nKeys = append(nKeys, nKey)
+	}
+	unlock := kv.lockKeys(nKeys)
+	defer unlock()
+	var writes, removes batchOps
+	for _, nKey := range nKeys {
+		target, old := kv.locate(nKey)
+		for _, l := range target {
+			writes.add(nKey, l, values[nKey])
+		}
+		for _, l := range old {
+			if !containsLocation(target, l) {
+				removes.add(nKey, l, "")
+			}
+		}
+	}
+	return kv.sendWrites(writes, removes, func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiSetText(items)
+	})
+}
+
+/*
+发送批量写入,主键写入成功的副本数量达到要求时再删除原位置中不再使用的副本,返回写入失败的主键
+*/
+func (kv *KVStore) sendWrites(writes batchOps, removes batchOps, send func(storage api.Storage, items []*entities.BatchItem) error) map[uint64]error {
+	writes.send(send)
+	targets := make(map[uint64][]*batchOp)
+	for _, op := range writes {
+		targets[op.nKey] = append(targets[op.nKey], op)
+	}
+	errs := make(map[uint64]error)
+	for nKey, ops := range targets {
+		if err := kv.quorumError(locationsOf(ops), errorsOf(ops)); err != nil {
+			errs[nKey] = err
+		}
+	}
+	//写入失败的主键保留原位置的记录
+	var succeeded batchOps
+	for _, op := range removes {
+		if errs[op.nKey] == nil {
+			succeeded = append(succeeded, op)
+		}
+	}
+	succeeded.send(func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiDelete(items)
+	})
+	return errs
+}
+
+/*
+批量删除,按分片分组后每个节点发送一个批量请求。每个主键与Delete相同,
+删除成功的副本数量达到要求时成功,所有位置都没有记录时返回错误,有多个副本时在所有副本写入墓碑
+*/
+func (kv *KVStore) MultiDelete(nKeys []uint64) map[uint64]error {
+	nKeys = uniqueKeys(nKeys)
+	unlock := kv.lockKeys(nKeys)
+	defer unlock()
+	var single, replicated []uint64
+	for _, nKey := range nKeys {
+		if target, _ := kv.locate(nKey); len(target) > 1 {
+			replicated = append(replicated, nKey)
+		} else {
+			single = append(single, nKey)
+		}
+	}
+	errs := kv.multiTombstone(replicated)
+	for nKey, err := range kv.multiDelete(single) {
+		errs[nKey] = err
+	}
+	return errs
+}
+
+/*
+批量删除只有一个副本的主键
+*/
+func (kv *KVStore) multiDelete(nKeys []uint64) map[uint64]error {
+	deletes := make(map[uint64]*keyOps, len(nKeys))
+	var ops batchOps
+	for _, nKey := range nKeys {
+		target, old := kv.locate(nKey)
+		d := &keyOps{}
+		for _, l := range target {
+			d.target = append(d.target, ops.add(nKey, l, ""))
+		}
+		for _, l := range old {
+			if !containsLocation(target, l) {
+				d.old = append(d.old, ops.add(nKey, l, ""))
+			}
+		}
+		deletes[nKey] = d
+	}
+	ops.send(func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiDelete(items)
+	})
+	errs := make(map[uint64]error)
+	for _, nKey := range nKeys {
+		d := deletes[nKey]
+		notFound := 0
+		targetErrs := errorsOf(d.target)
+		for i, err := range targetErrs {
+			if err != nil && isNotFound(err) {
+				notFound++
+				targetErrs[i] = nil
+			}
+		}
+		if err := kv.quorumError(locationsOf(d.target), targetErrs); err != nil {
+			errs[nKey] = err
+			continue
+		}
+		found := notFound < len(d.target)
+		for _, op := range d.old {
+			if op.item.Err == nil {
+				found = true
+			}
+		}
+		if !found {
+			errs[nKey] = errors.New(fmt.Sprintf("not found[Key:%d]", nKey))
+		}
+	}
+	return errs
+}
+
+/*
+批量删除有多个副本的主键:先批量读取各副本,新旧位置的多数副本都没有记录或已删除的主键返回错误,
+其余主键在所有副本写入墓碑后删除原位置中不再使用的副本
+*/
+func (kv *KVStore) multiTombstone(nKeys []uint64) map[uint64]error {
+	errs := make(map[uint64]error)
+	if len(nKeys) == 0 {
+		return errs
+	}
+	reads := kv.readKeys(nKeys)
+	var writes, removes batchOps
+	for _, nKey := range nKeys {
+		r := reads[nKey]
+		text, _ := voteOps(r.target)
+		oldText, _ := voteOps(r.old)
+		if !isLive(text) && !isLive(oldText) {
+			if err := readError(append(r.old, r.target...)); err != nil {
+				errs[nKey] = err
+			} else {
+				errs[nKey] = errors.New(fmt.Sprintf("not found[Key:%d]", nKey))
+			}
+			continue
+		}
+		target := locationsOf(r.target)
+		for _, l := range target {
+			writes.add(nKey, l, Tombstone)
+		}
+		for _, op := range r.old {
+			if !containsLocation(target, op.location) {
+				removes.add(nKey, op.location, "")
+			}
+		}
+	}
+	for nKey, err := range kv.sendWrites(writes, removes, func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiSet(items)
+	}) {
+		errs[nKey] = err
+	}
+	return errs
+}
@@ -0,0 +1,337 @@
+package shardedkv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/entities"
+	"github.com/xp/shorttext-db/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//主键写锁的分段数量
+const keyLockCount = 256
+
+//重新平衡时每次从分库读取的记录数
+const rebalancePageSize = 1000
+
+var ErrRebalancing = errors.New("正在重新平衡分片")
+
+//主键的存储位置
+type location struct {
+	name    string
+	index   uint64
+	storage api.Storage
+}
+
+/*
+获得主键所有副本的存储位置,主副本在前。重新平衡期间target为新分区布局中的位置,old为原分区布局中的位置,
+两者相同或不在重新平衡时old为nil
+*/
+func (kv *KVStore) locate(nKey uint64) (target []*location, old []*location) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	current := kv.replicaLocations(kv.continuum, nKey)
+	if kv.next == nil {
+		return current, nil
+	}
+	next := kv.replicaLocations(kv.next, nKey)
+	if sameLocations(current, next) {
+		return current, nil
+	}
+	return next, current
+}
+
+func (kv *KVStore) keyLock(nKey uint64) *sync.Mutex {
+	return &kv.keyLocks[nKey%keyLockCount]
+}
+
+/*
+写入记录的所有副本。重新平衡期间写入新位置后删除原位置中不再使用的副本,迁移时不会再用旧记录覆盖新记录
+*/
+func (kv *KVStore) write(nKey uint64, set func(key string, target *location) error) error {
+	lock := kv.keyLock(nKey)
+	lock.Lock()
+	defer lock.Unlock()
+	target, old := kv.locate(nKey)
+	key := strconv.FormatUint(nKey, 10)
+	err := kv.replicate(target, func(l *location) error {
+		return set(key, l)
+	})
+	if err != nil {
+		return err
+	}
+	for _, l := range old {
+		if !containsLocation(target, l) {
+			l.storage.Delete(key, l.index)
+		}
+	}
+	return nil
+}
+
+/*
+是否正在重新平衡
+*/
+func (kv *KVStore) Rebalancing() bool {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return kv.next != nil
+}
+
+/*
+按新的分片列表重新平衡:chooser为新的分区选择器,已有的分片保留原有存储。
+迁移期间读取时先读原位置再读新位置,写入新位置,查找覆盖新旧所有分片;
+所有受影响的记录迁移完成后切换到新的分区布局,并移除不再使用的分片。
+迁移只保证经过本KVStore的读写与迁移之间的一致性,迁移后的记录不再保留TTL。
+新的分区布局只在本KVStore中生效,不会通知其他进程:其他进程中的KVStore、DBNode、BulkLoader和Exporter
+仍按启动时的配置选择分片,迁移期间应停止这些进程的写入,完成后使用更新后的Case.CardList和分片选择器配置重启。
+progress在每批记录迁移后调用,参数为已迁移的记录数,返回迁移的记录数
+*/
+func (kv *KVStore) Rebalance(shards []Shard, chooser api.Chooser, progress func(moved int)) (int, error) {
+	names := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		names = append(names, shard.Name)
+	}
+	if len(names) == 0 {
+		return 0, errors.New(fmt.Sprintf("数据库[%s]重新平衡时分片列表为空", kv.name))
+	}
+	if err := chooser.SetBuckets(names); err != nil {
+		return 0, err
+	}
+	kv.mu.Lock()
+	if kv.next != nil {
+		kv.mu.Unlock()
+		return 0, ErrRebalancing
+	}
+	for _, shard := range shards {
+		if _, ok := kv.storages[shard.Name]; !ok {
+			kv.storages[shard.Name] = shard.Backend
+		}
+	}
+	kv.next = chooser
+	kv.mu.Unlock()
+	return kv.ResumeRebalance(progress)
+}
+
+/*
+继续进行中断的重新平衡,中断期间仍按新旧两个分区布局读写。
+有分库或记录迁移失败时不切换分区布局,返回错误,可再次调用继续迁移
+*/
+func (kv *KVStore) ResumeRebalance(progress func(moved int)) (int, error) {
+	t := utils.NewTimer()
+	kv.mu.RLock()
+	current, next := kv.continuum, kv.next
+	kv.mu.RUnlock()
+	if next == nil {
+		return 0, errors.New(fmt.Sprintf("数据库[%s]没有进行中的重新平衡", kv.name))
+	}
+	moved := 0
+	failed := 0
+	failures := make([]string, 0)
+	for _, name := range current.Buckets() {
+		kv.mu.RLock()
+		storage := kv.storages[name]
+		kv.mu.RUnlock()
+		for index := uint64(1); index <= current.DBCount(); index++ {
+			count, failedCount, err := kv.moveRecords(name, index, storage, func(count int) {
+				if progress != nil {
+					progress(moved + count)
+				}
+			})
+			moved = moved + count
+			failed = failed + failedCount
+			if err != nil {
+				logger.Errorf("数据库[%s]分片[%s]分库[%d]迁移失败:%s\n", kv.name, name, index, err.Error())
+				failures = append(failures, fmt.Sprintf("分片[%s]分库[%d]:%s", name, index, err.Error()))
+			}
+		}
+	}
+	if failed > 0 || len(failures) > 0 {
+		return moved, errors.New(fmt.Sprintf("数据库[%s]重新平衡未完成,迁移失败的记录数:%d,分库错误:%s",
+			kv.name, failed, strings.Join(failures, ";")))
+	}
+	kv.mu.Lock()
+	kv.continuum = next
+	kv.next = nil
+	buckets := make(map[string]bool)
+	for _, name := range next.Buckets() {
+		buckets[name] = true
+	}
+	for name := range kv.storages {
+		if !buckets[name] {
+			delete(kv.storages, name)
+		}
+	}
+	kv.mu.Unlock()
+	logger.Infof("数据库[%s]重新平衡完成,迁移记录数:%d,Time:%.2f\n", kv.name, moved, t.Stop())
+	return moved, nil
+}
+
+/*
+逐页读取原分区布局中一个分库的记录,迁移新分区布局中属于其他位置的记录,
+返回迁移的记录数和迁移失败的记录数,读取分库失败时返回错误
+*/
+func (kv *KVStore) moveRecords(name string, index uint64, storage api.Storage, progress func(count int)) (int, int, error) {
+	moved := 0
+	failed := 0
+	after := ""
+	for {
+		page, err := storage.Export(index, after, rebalancePageSize)
+		if err != nil {
+			return moved, failed, err
+		}
+		ttls := make(map[uint64]time.Duration)
+		for _, record := range page.Records {
+			if nKey, err := strconv.ParseUint(record.Key, 10, 64); err == nil && record.TTL > 0 {
+				ttls[nKey] = time.Duration(record.TTL) * time.Millisecond
+			}
+		}
+		for _, nKey := range kv.ownedKeys(name, index, page.Records) {
+			ok, err := kv.moveRecord(nKey, name, index, ttls[nKey])
+			if err != nil {
+				failed++
+				logger.Errorf("数据库[%s]迁移记录[%d]失败:%s\n", kv.name, nKey, err.Error())
+				continue
+			}
+			if ok {
+				moved++
+			}
+		}
+		progress(moved)
+		if len(page.Next) == 0 {
+			return moved, failed, nil
+		}
+		after = page.Next
+	}
+}
+
+/*
+选出需要由该分库迁移的记录:原位置有多个副本时只迁移主副本在该分库的记录,避免每条记录迁移多次;
+主副本在其他位置时按节点批量读取主副本,主副本没有记录或读取失败时仍由该分库迁移
+*/
+func (kv *KVStore) ownedKeys(name string, index uint64, records []entities.ExportRecord) []uint64 {
+	nKeys := make([]uint64, 0, len(records))
+	var ops batchOps
+	for _, record := range records {
+		nKey, err := strconv.ParseUint(record.Key, 10, 64)
+		if err != nil {
+			continue
+		}
+		_, old := kv.locate(nKey)
+		if len(old) == 0 {
+			continue
+		}
+		if primary := old[0]; primary.name == name && primary.index == index {
+			nKeys = append(nKeys, nKey)
+			continue
+		}
+		ops.add(nKey, old[0], "")
+	}
+	ops.send(func(storage api.Storage, items []*entities.BatchItem) error {
+		return storage.MultiGetText(items)
+	})
+	for _, op := range ops {
+		if op.item.Err != nil || len(op.item.Value) == 0 {
+			nKeys = append(nKeys, op.nKey)
+		}
+	}
+	return nKeys
+}
+
+/*
+把记录或墓碑从原位置复制到新位置中没有记录的副本后删除原位置中不再使用的副本,
+新位置已有记录时说明迁移期间已写入新值,不再覆盖。
+加锁后重新读取原位置,导出之后已删除或已迁移的记录不再复制,任意副本读取失败时返回错误。
+ttl为导出时记录剩余的生存时间,大于0时新位置的记录保留该生存时间
+*/
+func (kv *KVStore) moveRecord(nKey uint64, name string, index uint64, ttl time.Duration) (bool, error) {
+	lock := kv.keyLock(nKey)
+	lock.Lock()
+	defer lock.Unlock()
+	target, old := kv.locate(nKey)
+	source := &location{name: name, index: index}
+	if !containsLocation(old, source) {
+		return false, nil
+	}
+	key := strconv.FormatUint(nKey, 10)
+	texts, err := readLocations(key, old)
+	if err != nil {
+		return false, err
+	}
+	text, _ := vote(texts, nil)
+	if len(text) == 0 {
+		return false, nil
+	}
+	existing, err := readLocations(key, target)
+	if err != nil {
+		return false, err
+	}
+	moved := false
+	for i, l := range target {
+		if len(existing[i]) > 0 {
+			continue
+		}
+		if ttl > 0 && !IsTombstone(text) {
+			err = l.storage.SetTextWithTTL(key, text, l.index, ttl)
+		} else {
+			err = setText(key, text, l)
+		}
+		if err != nil {
+			return moved, err
+		}
+		moved = true
+	}
+	for _, l := range old {
+		if containsLocation(target, l) {
+			continue
+		}
+		err := l.storage.Delete(key, l.index)
+		if err != nil && !isNotFound(err) {
+			return moved, err
+		}
+		moved = moved || err == nil
+	}
+	return moved, nil
+}
+
+/*
+读取记录在各位置的文本,记录不存在时为空文本,任意位置读取失败时返回错误
+*/
+func readLocations(key string, locations []*location) ([]string, error) {
+	texts := make([]string, len(locations))
+	errs := make([]error, len(locations))
+	var wg sync.WaitGroup
+	for i, l := range locations {
+		wg.Add(1)
+		go func(i int, l *location) {
+			defer wg.Done()
+			texts[i], errs[i] = readReplica(key, l)
+		}(i, l)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("分片[%s]分库[%d]读取记录[%s]失败:%s", locations[i].name, locations[i].index, key, err.Error()))
+		}
+	}
+	return texts, nil
+}
+
+/*
+去掉重复ID的记录,保留排在前面的记录
+*/
+func uniqueRecords(records []entities.Record) []entities.Record {
+	result := records[:0]
+	checker := make(map[string]bool, len(records))
+	for _, r := range records {
+		if checker[r.Id] {
+			continue
+		}
+		checker[r.Id] = true
+		result = append(result, r)
+	}
+	return result
+}
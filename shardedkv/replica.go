@@ -0,0 +1,286 @@
+package shardedkv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/entities"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//写入成功需要的副本数量
+const (
+	//任意一个副本写入成功
+	WRITE_QUORUM_ONE = iota + 1
+	//多数副本写入成功
+	WRITE_QUORUM_MAJORITY
+	//所有副本写入成功
+	WRITE_QUORUM_ALL
+)
+
+/*
+设置每条记录的副本数量和写入成功需要的副本数量,replicas为1时不复制。
+副本分片由实现了api.ReplicaChooser的分片选择器选择,分片数量不足时副本数量为分片数量。
+记录没有版本,读取时按多数副本的文本修复其他副本,写入使用WRITE_QUORUM_ONE时可能被修复为旧值。
+有多个副本时删除在各副本写入墓碑而不删除记录,墓碑参与计票,读取时按记录不存在处理,
+存储节点保留墓碑config.KVDBTombstoneTTL时间后自动删除,墓碑不计入记录数量
+*/
+func (kv *KVStore) SetReplication(replicas int, quorum int) {
+	if replicas < 1 {
+		replicas = 1
+	}
+	if quorum < WRITE_QUORUM_ONE || quorum > WRITE_QUORUM_ALL {
+		quorum = WRITE_QUORUM_MAJORITY
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.replicas = replicas
+	kv.quorum = quorum
+}
+
+/*
+有多个副本时删除记录写入的墓碑,避免删除时没有写入成功的副本在读修复时恢复已删除的记录
+*/
+const Tombstone = "\x00deleted"
+
+/*
+文本是否为删除记录写入的墓碑
+*/
+func IsTombstone(text string) bool {
+	return text == Tombstone
+}
+
+//记录存在且没有被删除
+func isLive(text string) bool {
+	return len(text) > 0 && !IsTombstone(text)
+}
+
+/*
+获得分区选择器中主键所有副本的存储位置
+*/
+func (kv *KVStore) replicaLocations(chooser api.Chooser, nKey uint64) []*location {
+	shard, index := chooser.Choose(nKey)
+	names := []string{shard}
+	if rc, ok := chooser.(api.ReplicaChooser); ok && kv.replicas > 1 {
+		names = rc.ChooseReplicas(nKey, kv.replicas)
+	}
+	locations := make([]*location, 0, len(names))
+	for _, name := range names {
+		locations = append(locations, &location{name: name, index: index, storage: kv.storages[name]})
+	}
+	return locations
+}
+
+func containsLocation(locations []*location, l *location) bool {
+	for _, item := range locations {
+		if item.name == l.name && item.index == l.index {
+			return true
+		}
+	}
+	return false
+}
+
+func sameLocations(a []*location, b []*location) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || a[i].index != b[i].index {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+写入成功需要的副本数量
+*/
+func (kv *KVStore) writeQuorum(n int) int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return QuorumSize(kv.quorum, n)
+}
+
+/*
+n个副本时写入成功需要的副本数量,quorum为WRITE_QUORUM_*
+*/
+func QuorumSize(quorum int, n int) int {
+	switch quorum {
+	case WRITE_QUORUM_ONE:
+		return 1
+	case WRITE_QUORUM_ALL:
+		return n
+	default:
+		return n/2 + 1
+	}
+}
+
+/*
+并发地对所有副本执行操作,成功的副本数量达到写入成功需要的数量时返回nil
+*/
+func (kv *KVStore) replicate(locations []*location, op func(l *location) error) error {
+	errs := make([]error, len(locations))
+	if len(locations) == 1 {
+		errs[0] = op(locations[0])
+		return kv.quorumError(locations, errs)
+	}
+	var wg sync.WaitGroup
+	for i, l := range locations {
+		wg.Add(1)
+		go func(i int, l *location) {
+			defer wg.Done()
+			errs[i] = op(l)
+		}(i, l)
+	}
+	wg.Wait()
+	return kv.quorumError(locations, errs)
+}
+
+/*
+根据各副本的操作结果判断是否写入成功,只有一个副本时返回该副本的错误
+*/
+func (kv *KVStore) quorumError(locations []*location, errs []error) error {
+	if len(locations) == 1 {
+		return errs[0]
+	}
+	acks := 0
+	failures := make([]string, 0)
+	for i, err := range errs {
+		if err == nil {
+			acks++
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("%s:%s", locations[i].name, err.Error()))
+	}
+	if need := kv.writeQuorum(len(locations)); acks < need {
+		return errors.New(fmt.Sprintf("写入成功的副本数量不足[%d/%d]:%s", acks, need, strings.Join(failures, ";")))
+	}
+	if len(failures) > 0 {
+		logger.Errorf("部分副本写入失败:%s\n", strings.Join(failures, ";"))
+	}
+	return nil
+}
+
+/*
+读取一个副本的文本,记录不存在时返回空文本,节点不可用或读取失败时返回错误
+*/
+func readReplica(key string, l *location) (string, error) {
+	item := &entities.BatchItem{Key: key, Index: l.index}
+	if err := l.storage.MultiGetText([]*entities.BatchItem{item}); err != nil {
+		return "", err
+	}
+	return item.Value, item.Err
+}
+
+/*
+并发地读取所有副本,返回多数副本的文本和与之不一致的副本,读取失败的副本不参与计票,也不作为不一致的副本。
+所有副本都读取失败时返回错误
+*/
+func readReplicas(key string, locations []*location) (string, []*location, error) {
+	texts := make([]string, len(locations))
+	errs := make([]error, len(locations))
+	if len(locations) == 1 {
+		texts[0], errs[0] = readReplica(key, locations[0])
+	} else {
+		var wg sync.WaitGroup
+		for i, l := range locations {
+			wg.Add(1)
+			go func(i int, l *location) {
+				defer wg.Done()
+				texts[i], errs[i] = readReplica(key, l)
+			}(i, l)
+		}
+		wg.Wait()
+	}
+	text, stale := vote(texts, errs)
+	staleLocations := make([]*location, 0, len(stale))
+	for _, i := range stale {
+		staleLocations = append(staleLocations, locations[i])
+	}
+	var err error
+	answered := len(locations) == 0
+	for i := range errs {
+		if errs[i] == nil {
+			answered = true
+			continue
+		}
+		err = errs[i]
+		logger.Errorf("分片[%s]分库[%d]读取记录[%s]失败:%s\n", locations[i].name, locations[i].index, key, err.Error())
+	}
+	if !answered {
+		return text, staleLocations, errors.New(fmt.Sprintf("读取记录[%s]的所有副本失败:%s", key, err.Error()))
+	}
+	return text, staleLocations, nil
+}
+
+/*
+按多数副本选择文本,返回选择的文本和与之不一致的副本序号,票数相同时墓碑优先,否则取排在前面的副本。
+没有记录的副本不参与计票,避免启用复制前的记录或未写入成功的副本删除已有记录;
+errs中读取失败的副本既不参与计票也不作为不一致的副本,避免对不可用的节点修复,errs可以为nil
+*/
+func vote(texts []string, errs []error) (string, []int) {
+	failed := func(i int) bool {
+		return i < len(errs) && errs[i] != nil
+	}
+	votes := make(map[string]int, len(texts))
+	text := ""
+	for i, t := range texts {
+		if len(t) == 0 || failed(i) {
+			continue
+		}
+		votes[t]++
+		if votes[t] > votes[text] || (votes[t] == votes[text] && IsTombstone(t)) {
+			text = t
+		}
+	}
+	if len(text) == 0 {
+		return "", nil
+	}
+	var stale []int
+	for i, t := range texts {
+		if t != text && !failed(i) {
+			stale = append(stale, i)
+		}
+	}
+	return text, stale
+}
+
+/*
+读修复:加锁后重新读取所有副本,把多数副本的文本或墓碑写入不一致或没有记录的副本,读取失败的副本不修复
+*/
+func (kv *KVStore) repair(nKey uint64) string {
+	lock := kv.keyLock(nKey)
+	lock.Lock()
+	defer lock.Unlock()
+	target, _ := kv.locate(nKey)
+	key := strconv.FormatUint(nKey, 10)
+	text, stale, _ := readReplicas(key, target)
+	for _, l := range stale {
+		if err := setText(key, text, l); err != nil {
+			logger.Errorf("分片[%s]分库[%d]修复记录[%s]失败:%s\n", l.name, l.index, key, err.Error())
+		}
+	}
+	return text
+}
+
+/*
+按文本保存记录并创建索引,墓碑和没有索引字段的记录无法按文本保存,按普通记录保存
+*/
+func setText(key string, text string, l *location) error {
+	if !IsTombstone(text) {
+		if err := l.storage.SetText(key, text, l.index); err == nil {
+			return nil
+		}
+	}
+	err, _ := l.storage.Set(key, l.index, text)
+	return err
+}
+
+/*
+存储节点返回的记录不存在错误
+*/
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "not found")
+}
@@ -0,0 +1,225 @@
+package shardedkv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/entities"
+	"sort"
+	"sync"
+)
+
+//范围扫描的缺省每页记录数
+const scanPageSize = 1000
+
+//范围扫描中的一段主键
+type scanSegment struct {
+	start uint64
+	end   uint64
+	//主键所在的分片
+	shards []string
+	//为true时合并所有分片的记录,否则依次尝试各副本分片,前一个分片失败时扫描下一个
+	merge bool
+}
+
+/*
+按主键顺序扫描[start,end]范围内的最多limit条记录。按范围分区时按顺序扫描各分区所在的分片,
+主分片失败时扫描副本;其他分片选择器或重新平衡期间合并所有分片的记录。
+每段主键并发地扫描分片上的所有分库后按主键合并,返回的Next为下一页的起始主键,为0时没有更多记录
+*/
+func (kv *KVStore) Scan(start uint64, end uint64, limit int) (*entities.ScanPage, error) {
+	if limit <= 0 {
+		limit = scanPageSize
+	}
+	page := &entities.ScanPage{Records: make([]entities.KeyValue, 0)}
+	segments := kv.scanSegments(start, end)
+	for i, seg := range segments {
+		records, next, err := kv.scanSegment(seg, limit-len(page.Records))
+		if err != nil {
+			return page, err
+		}
+		page.Records = append(page.Records, records...)
+		if next > 0 {
+			page.Next = next
+			return page, nil
+		}
+		if len(page.Records) == limit && i < len(segments)-1 {
+			page.Next = segments[i+1].start
+			return page, nil
+		}
+	}
+	return page, nil
+}
+
+/*
+把[start,end]按分片分为多段
+*/
+func (kv *KVStore) scanSegments(start uint64, end uint64) []scanSegment {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if start > end {
+		return nil
+	}
+	oc, ok := kv.continuum.(api.OrderedChooser)
+	if !ok || kv.next != nil {
+		names := make([]string, 0, len(kv.storages))
+		for name := range kv.storages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return []scanSegment{{start: start, end: end, shards: names, merge: true}}
+	}
+	ranges := oc.ChooseRange(start, end)
+	segments := make([]scanSegment, 0, len(ranges))
+	for _, r := range ranges {
+		shards := []string{r.Shard}
+		if rc, ok := kv.continuum.(api.ReplicaChooser); ok && kv.replicas > 1 {
+			shards = rc.ChooseReplicas(r.Start, kv.replicas)
+		}
+		segments = append(segments, scanSegment{start: r.Start, end: r.End, shards: shards})
+	}
+	return segments
+}
+
+func (kv *KVStore) scanSegment(seg scanSegment, limit int) ([]entities.KeyValue, uint64, error) {
+	if seg.merge {
+		return kv.scanShards(seg.shards, seg.start, seg.end, limit)
+	}
+	var err error
+	for _, name := range seg.shards {
+		records, next, e := kv.scanShards([]string{name}, seg.start, seg.end, limit)
+		if e == nil {
+			return records, next, nil
+		}
+		err = e
+		logger.Errorf("分片[%s]扫描失败:%s\n", name, e.Error())
+	}
+	return nil, 0, err
+}
+
+/*
+并发地扫描分片上的所有分库,按主键合并各副本的记录并去掉已删除的主键,返回前limit条记录和下一条记录的主键
+*/
+func (kv *KVStore) scanShards(names []string, start uint64, end uint64, limit int) ([]entities.KeyValue, uint64, error) {
+	type dbPage struct {
+		name    string
+		index   uint64
+		storage api.Storage
+		page    *entities.ScanPage
+		err     error
+	}
+	kv.mu.RLock()
+	dbCount := kv.continuum.DBCount()
+	if kv.next != nil && kv.next.DBCount() > dbCount {
+		dbCount = kv.next.DBCount()
+	}
+	pages := make([]dbPage, 0, len(names)*int(dbCount))
+	for _, name := range names {
+		for index := uint64(1); index <= dbCount; index++ {
+			pages = append(pages, dbPage{name: name, index: index, storage: kv.storages[name]})
+		}
+	}
+	kv.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Add(1)
+		go func(p *dbPage) {
+			defer wg.Done()
+			p.page, p.err = p.storage.Scan(p.index, start, end, limit)
+		}(&pages[i])
+	}
+	wg.Wait()
+
+	var next uint64
+	records := make([]entities.KeyValue, 0)
+	for _, p := range pages {
+		if p.err != nil {
+			return nil, 0, errors.New(fmt.Sprintf("分片[%s]分库[%d]扫描失败:%s", p.name, p.index, p.err.Error()))
+		}
+		records = append(records, p.page.Records...)
+		//分库还有更多记录时,合并结果只能包含该分库下一页之前的主键
+		if p.page.Next > 0 && (next == 0 || p.page.Next < next) {
+			next = p.page.Next
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	//与GetText相同,同一主键按多数副本的文本返回,多数副本已删除的主键不返回
+	result := make([]entities.KeyValue, 0, limit)
+	for i := 0; i < len(records); {
+		r := records[i]
+		texts := make([]string, 0, 1)
+		for ; i < len(records) && records[i].Key == r.Key; i++ {
+			texts = append(texts, records[i].Value)
+		}
+		text, _ := vote(texts, nil)
+		if !isLive(text) {
+			continue
+		}
+		if len(result) == limit {
+			if next == 0 || r.Key < next {
+				next = r.Key
+			}
+			break
+		}
+		r.Value = text
+		result = append(result, r)
+	}
+	return result, next, nil
+}
+
+/*
+按主键顺序遍历范围内记录的迭代器,每页记录读取完后扫描下一页
+*/
+type ScanIterator struct {
+	kv       *KVStore
+	next     uint64
+	end      uint64
+	pageSize int
+	records  []entities.KeyValue
+	pos      int
+	current  entities.KeyValue
+	done     bool
+	err      error
+}
+
+func (kv *KVStore) Iterator(start uint64, end uint64, pageSize int) api.KVIterator {
+	return &ScanIterator{kv: kv, next: start, end: end, pageSize: pageSize, done: start > end}
+}
+
+func (it *ScanIterator) Next() bool {
+	for it.pos >= len(it.records) {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.kv.Scan(it.next, it.end, it.pageSize)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.records = page.Records
+		it.pos = 0
+		if page.Next == 0 {
+			it.done = true
+		} else {
+			it.next = page.Next
+		}
+	}
+	it.current = it.records[it.pos]
+	it.pos++
+	return true
+}
+
+func (it *ScanIterator) Key() uint64 {
+	return it.current.Key
+}
+
+func (it *ScanIterator) Value() string {
+	return it.current.Value
+}
+
+func (it *ScanIterator) Err() error {
+	return it.err
+}
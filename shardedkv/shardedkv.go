@@ -1,11 +1,20 @@
 package shardedkv
 
 import (
+	"errors"
+	"fmt"
 	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/chash"
+	"github.com/xp/shorttext-db/entities"
 	"github.com/xp/shorttext-db/filedb"
 	"github.com/xp/shorttext-db/glogger"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var logger = glogger.MustGetLogger("shardedkv")
@@ -14,10 +23,18 @@ type Object interface{}
 
 type KVStore struct {
 	continuum api.Chooser
-	storages  map[string]api.Storage
-	seq       filedb.SequenceSvc
-	mu        sync.RWMutex
-	name      string
+	//重新平衡的目标分区布局,不在重新平衡时为nil
+	next     api.Chooser
+	storages map[string]api.Storage
+	seq      filedb.SequenceSvc
+	mu       sync.RWMutex
+	name     string
+	//按主键分段的写锁,保证同一主键的写入与迁移互斥
+	keyLocks [keyLockCount]sync.Mutex
+	//每条记录的副本数量,包含主副本
+	replicas int
+	//写入成功需要的副本数量,见WRITE_QUORUM_*
+	quorum int
 }
 
 /*
@@ -87,6 +104,119 @@ func (r *RangeChooser) Buckets() []string {
 	return r.buckets
 }
 
+/*
+获得主键所在分片及其后按顺序的n-1个分片
+*/
+func (r *RangeChooser) ChooseReplicas(key uint64, n int) []string {
+	shardName, _ := r.Choose(key)
+	if n > len(r.buckets) {
+		n = len(r.buckets)
+	}
+	start := 0
+	for i, name := range r.buckets {
+		if name == shardName {
+			start = i
+			break
+		}
+	}
+	result := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		result = append(result, r.buckets[(start+i)%len(r.buckets)])
+	}
+	return result
+}
+
+/*
+按主键顺序获得[start,end]范围内各段主键所在的分片,不在任何分区内的主键与Choose相同,在最后一个分片
+*/
+func (r *RangeChooser) ChooseRange(start uint64, end uint64) []api.KeyRange {
+	result := make([]api.KeyRange, 0, len(r.partitions)+1)
+	if len(r.partitions) == 0 || start > end {
+		return result
+	}
+	lastName := r.partitions[len(r.partitions)-1].name
+	add := func(name string, begin uint64, stop uint64) {
+		if begin < start {
+			begin = start
+		}
+		if stop > end {
+			stop = end
+		}
+		if begin > stop {
+			return
+		}
+		if n := len(result); n > 0 && result[n-1].Shard == name && result[n-1].End+1 == begin {
+			result[n-1].End = stop
+			return
+		}
+		result = append(result, api.KeyRange{Shard: name, Start: begin, End: stop})
+	}
+	var cursor uint64
+	for _, p := range r.partitions {
+		if p.begin > cursor {
+			add(lastName, cursor, p.begin-1)
+		}
+		add(p.name, p.begin, p.end)
+		if p.end == math.MaxUint64 {
+			return result
+		}
+		cursor = p.end + 1
+	}
+	add(lastName, cursor, math.MaxUint64)
+	return result
+}
+
+func (r *RangeChooser) DBCount() uint64 {
+	return uint64(r.maxRange)
+}
+
+/*
+一致性哈希分片选择器，主键按哈希值分布在所有分片上，每个分片有多个虚拟节点，
+weights为分片的权重，权重为w的分片虚拟节点数量为replicas的w倍。
+增加或删除分片时只有相邻虚拟节点上的主键改变分片
+*/
+type HashChooser struct {
+	maxRange uint32
+	hash     *chash.CHash
+}
+
+func NewHashChooser(maxRange uint32, replicas int, weights map[string]int) *HashChooser {
+	h := &HashChooser{}
+	if maxRange == 0 {
+		maxRange = maxRangeValue
+	}
+	h.maxRange = maxRange
+	h.hash = chash.NewWeighted(replicas, weights)
+	return h
+}
+
+func (h *HashChooser) SetBuckets(names []string) error {
+	return h.hash.SetBuckets(names)
+}
+
+/*
+分库序号与RangeChooser相同,由主键对分库数量取模得到
+*/
+func (h *HashChooser) Choose(key uint64) (string, uint64) {
+	shardName := h.hash.Choose(strconv.FormatUint(key, 10))
+	return shardName, key%uint64(h.maxRange) + 1
+}
+
+/*
+获得主键所在分片及其后的n-1个不同分片
+*/
+func (h *HashChooser) ChooseReplicas(key uint64, n int) []string {
+	return h.hash.ChooseReplicas(strconv.FormatUint(key, 10), n)
+}
+
+func (h *HashChooser) Buckets() []string {
+	return h.hash.Buckets()
+}
+
+func (h *HashChooser) DBCount() uint64 {
+	return uint64(h.maxRange)
+}
+
 // 命名的分片存储
 type Shard struct {
 	Name    string
@@ -98,6 +228,8 @@ func New(name string, chooser api.Chooser, seq filedb.SequenceSvc, shards []Shar
 	kv := &KVStore{
 		continuum: chooser,
 		storages:  make(map[string]api.Storage),
+		replicas:  1,
+		quorum:    WRITE_QUORUM_MAJORITY,
 	}
 	for _, shard := range shards {
 		buckets = append(buckets, shard.Name)
@@ -109,15 +241,38 @@ func New(name string, chooser api.Chooser, seq filedb.SequenceSvc, shards []Shar
 	return kv
 }
 
+/*
+读取记录,重新平衡期间先读原位置再读新位置。有多个副本时按GetText的方式读取多数副本的文本,
+存储实现了api.Decoder时直接解析该文本,否则文本存在时再依次读取各副本直到成功
+*/
 func (kv *KVStore) Get(nKey uint64, item interface{}) (interface{}, error) {
-
-	var storage api.Storage
-	kv.mu.Lock()
-	shard, index := kv.continuum.Choose(nKey)
-	storage = kv.storages[shard]
-	kv.mu.Unlock()
+	target, old := kv.locate(nKey)
 	key := strconv.FormatUint(nKey, 10)
-	return storage.Get(key, index, item)
+	notFound := errors.New(fmt.Sprintf("not found[Key:%s]", key))
+	if len(target) > 1 || len(old) > 1 {
+		text, err := kv.readText(nKey)
+		if err != nil {
+			return nil, err
+		}
+		if !isLive(text) {
+			return nil, notFound
+		}
+		if decoder, ok := target[0].storage.(api.Decoder); ok {
+			return decoder.Decode(text, item)
+		}
+	}
+	var err error
+	for _, l := range append(old, target...) {
+		var result interface{}
+		if result, err = l.storage.Get(key, l.index, item); err == nil {
+			if text, ok := result.(string); ok && IsTombstone(text) {
+				err = notFound
+				continue
+			}
+			return result, nil
+		}
+	}
+	return nil, err
 }
 
 func (kv *KVStore) Next() uint64 {
@@ -129,52 +284,236 @@ func (kv *KVStore) IniSeq(val uint64) {
 }
 
 func (kv *KVStore) Set(nKey uint64, val interface{}) (error, uint64) {
-	var storage api.Storage
-
-	kv.mu.Lock()
 	if nKey == 0 {
 		nKey = kv.seq.Next(kv.name)
 	}
-	key := strconv.FormatUint(nKey, 10)
-	shard, index := kv.continuum.Choose(nKey)
-	storage = kv.storages[shard]
-	kv.mu.Unlock()
-	err, _ := storage.Set(key, index, val)
+	err := kv.write(nKey, func(key string, target *location) error {
+		err, _ := target.storage.Set(key, target.index, val)
+		return err
+	})
 	return err, nKey
 }
 func (kv *KVStore) SetText(nKey uint64, val string) error {
-	var storage api.Storage
+	return kv.write(nKey, func(key string, target *location) error {
+		return target.storage.SetText(key, val, target.index)
+	})
+}
 
-	kv.mu.Lock()
-	key := strconv.FormatUint(nKey, 10)
-	shard, index := kv.continuum.Choose(nKey)
-	storage = kv.storages[shard]
-	kv.mu.Unlock()
-	return storage.SetText(key, val, index)
+func (kv *KVStore) SetTextWithTTL(nKey uint64, val string, ttl time.Duration) error {
+	return kv.write(nKey, func(key string, target *location) error {
+		return target.storage.SetTextWithTTL(key, val, target.index, ttl)
+	})
 }
 
+/*
+读取文本。有多个副本时读取所有副本,按多数副本的文本返回,并修复与之不一致的副本;
+重新平衡期间先读原位置,未找到时再读新位置。记录已删除或所有副本都读取失败时返回空文本
+*/
 func (kv *KVStore) GetText(nKey uint64) string {
-	var storage api.Storage
-	kv.mu.Lock()
-	shard, index := kv.continuum.Choose(nKey)
-	storage = kv.storages[shard]
-	kv.mu.Unlock()
+	text, _ := kv.readText(nKey)
+	return liveText(text)
+}
+
+/*
+按GetText的方式读取多数副本的文本,记录已删除时返回墓碑,新位置的所有副本都读取失败时返回错误
+*/
+func (kv *KVStore) readText(nKey uint64) (string, error) {
+	target, old := kv.locate(nKey)
 	key := strconv.FormatUint(nKey, 10)
-	return storage.GetText(key, index)
+	if len(old) > 0 {
+		if text, _, _ := readReplicas(key, old); len(text) > 0 {
+			return text, nil
+		}
+	}
+	text, stale, err := readReplicas(key, target)
+	if len(stale) > 0 {
+		text = kv.repair(nKey)
+	}
+	return text, err
+}
+
+//墓碑按记录不存在处理
+func liveText(text string) string {
+	if IsTombstone(text) {
+		return ""
+	}
+	return text
 }
 
+/*
+删除所有副本,达到写入副本数量即成功,所有位置都没有记录时返回错误。
+有多个副本时在所有副本写入墓碑
+*/
 func (kv *KVStore) Delete(key string) error {
-	var storage api.Storage
-	kv.mu.Lock()
 	nKey, err := strconv.ParseUint(key, 10, 64)
 	if err != nil {
 		return err
 	}
-	shard, index := kv.continuum.Choose(nKey)
-	storage = kv.storages[shard]
-	kv.mu.Unlock()
-	err = storage.Delete(key, index)
-	return err
+	lock := kv.keyLock(nKey)
+	lock.Lock()
+	defer lock.Unlock()
+	target, old := kv.locate(nKey)
+	if len(target) > 1 {
+		return kv.deleteReplicas(key, target, old)
+	}
+	var notFound int32
+	err = kv.replicate(target, func(l *location) error {
+		err := l.storage.Delete(key, l.index)
+		if err != nil && isNotFound(err) {
+			atomic.AddInt32(&notFound, 1)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	found := int(notFound) < len(target)
+	for _, l := range old {
+		//记录只在原位置和新位置之一时,任意一处删除成功即可
+		if !containsLocation(target, l) && l.storage.Delete(key, l.index) == nil {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("not found[Key:%s]", key))
+	}
+	return nil
+}
+
+/*
+在所有副本写入墓碑,达到写入副本数量即成功,再删除原位置中不再使用的副本。
+新旧位置的多数副本都没有记录或已删除时返回错误,新位置的所有副本都读取失败时返回读取的错误
+*/
+func (kv *KVStore) deleteReplicas(key string, target []*location, old []*location) error {
+	text, _, err := readReplicas(key, target)
+	if !isLive(text) {
+		if oldText, _, _ := readReplicas(key, old); !isLive(oldText) {
+			if err != nil {
+				return err
+			}
+			return errors.New(fmt.Sprintf("not found[Key:%s]", key))
+		}
+	}
+	err = kv.replicate(target, func(l *location) error {
+		err, _ := l.storage.Set(key, l.index, Tombstone)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, l := range old {
+		if !containsLocation(target, l) {
+			l.storage.Delete(key, l.index)
+		}
+	}
+	return nil
+}
+
+/*
+有多个分片且查询选项中没有统计信息时,先并发地获得各分片的BM25统计信息并汇总,
+使各分片按相同的IDF和平均长度评分,合并时评分可以直接比较。有多个副本时各项统计按副本数量同比放大,基本不影响评分。
+统计失败的分片不计入汇总
+*/
+func withStats(storages map[string]api.Storage, text string, opts *entities.FindOptions) *entities.FindOptions {
+	if len(storages) <= 1 || opts.GetStats() != nil {
+		return opts
+	}
+	type shardStats struct {
+		name  string
+		stats *entities.TermStats
+		err   error
+	}
+	results := make(chan shardStats, len(storages))
+	for name, storage := range storages {
+		go func(name string, storage api.Storage) {
+			stats, err := storage.TermStats(text, opts)
+			results <- shardStats{name: name, stats: stats, err: err}
+		}(name, storage)
+	}
+	stats := entities.NewTermStats()
+	for i := 0; i < len(storages); i++ {
+		r := <-results
+		if r.err != nil {
+			logger.Errorf("分片[%s]统计关键字失败:%s\n", r.name, r.err.Error())
+			continue
+		}
+		stats.Add(r.stats)
+	}
+	result := entities.FindOptions{}
+	if opts != nil {
+		result = *opts
+	}
+	result.Stats = stats
+	return &result
+}
+
+/*
+并发地在所有分片上查找文本命中的记录,各分片按汇总的统计信息评分,合并后按评分从高到低排序,返回前opts.Limit条。
+部分分片查找失败时返回其余分片的记录,失败或结果不完整的分片记录在FindResult.Failures中,全部失败时返回错误
+*/
+func (kv *KVStore) Find(text string, opts *entities.FindOptions) (*entities.FindResult, error) {
+	type shardResult struct {
+		name    string
+		records []entities.Record
+		err     error
+	}
+	kv.mu.RLock()
+	storages := make(map[string]api.Storage, len(kv.storages))
+	for name, storage := range kv.storages {
+		storages[name] = storage
+	}
+	replicated := kv.replicas > 1
+	kv.mu.RUnlock()
+	if len(storages) == 0 {
+		return nil, errors.New(fmt.Sprintf("数据库[%s]没有可用的分片", kv.name))
+	}
+	opts = withStats(storages, text, opts)
+
+	results := make(chan shardResult, len(storages))
+	for name, storage := range storages {
+		go func(name string, storage api.Storage) {
+			records, err := storage.Find(text, opts)
+			results <- shardResult{name: name, records: records, err: err}
+		}(name, storage)
+	}
+	result := &entities.FindResult{Records: make([]entities.Record, 0)}
+	failed := 0
+	for i := 0; i < len(storages); i++ {
+		r := <-results
+		if r.err != nil {
+			logger.Errorf("分片[%s]查找失败:%s\n", r.name, r.err.Error())
+			if result.Failures == nil {
+				result.Failures = make(map[string]string)
+			}
+			result.Failures[r.name] = r.err.Error()
+			//部分分库结果不完整时仍使用其余分库的记录
+			if _, ok := r.err.(*entities.PartialError); !ok {
+				failed++
+				continue
+			}
+		}
+		result.Records = append(result.Records, r.records...)
+	}
+	if failed == len(storages) {
+		failures := make([]string, 0, len(result.Failures))
+		for name, msg := range result.Failures {
+			failures = append(failures, name+":"+msg)
+		}
+		sort.Strings(failures)
+		return result, errors.New(fmt.Sprintf("所有分片查找失败:%s", strings.Join(failures, ";")))
+	}
+	sort.SliceStable(result.Records, func(i, j int) bool {
+		return result.Records[i].Before(&result.Records[j])
+	})
+	if replicated || kv.Rebalancing() {
+		//记录可能存在于多个副本,重新平衡期间还可能同时存在于原位置和新位置
+		result.Records = uniqueRecords(result.Records)
+	}
+	if limit := opts.GetLimit(); limit > 0 && len(result.Records) > limit {
+		result.Records = result.Records[:limit]
+	}
+	return result, nil
 }
 
 //重新连接
@@ -1,6 +1,17 @@
 package shardedkv
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"github.com/xp/shorttext-db/api"
+	"github.com/xp/shorttext-db/entities"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestChooser(t *testing.T) {
 	var maxRange uint32 = 3
@@ -13,3 +24,674 @@ func TestChooser(t *testing.T) {
 		//logger.Infof("shard:%s  index:%d\n", shard, index)
 	}
 }
+
+type findStorage struct {
+	api.Storage
+	records []entities.Record
+	err     error
+	stats   *entities.TermStats
+	//查找时收到的汇总统计信息
+	received *entities.TermStats
+}
+
+func (s *findStorage) Find(text string, opts *entities.FindOptions) ([]entities.Record, error) {
+	s.received = opts.GetStats()
+	return s.records, s.err
+}
+
+func (s *findStorage) TermStats(text string, opts *entities.FindOptions) (*entities.TermStats, error) {
+	return s.stats, s.err
+}
+
+func TestKVStore_Find(t *testing.T) {
+	newStats := func(docs int, df int) *entities.TermStats {
+		stats := entities.NewTermStats()
+		stats.Docs["desc"] = docs
+		stats.AddDF("desc", "测试", df)
+		return stats
+	}
+	test1 := &findStorage{records: []entities.Record{{Id: "1", Score: 0.9}, {Id: "4", Score: 0.3}}, stats: newStats(10, 1)}
+	test2 := &findStorage{records: []entities.Record{{Id: "2", Score: 0.8}, {Id: "3", Score: 0.5}}, stats: newStats(20, 4)}
+	shards := []Shard{
+		{Name: "test1", Backend: test1},
+		{Name: "test2", Backend: test2},
+		{Name: "test3", Backend: &findStorage{err: errors.New("节点不可用")}},
+	}
+	kv := New("testdb", NewRangeChooser(3, 15, 1), nil, shards)
+	result, err := kv.Find("测试", &entities.FindOptions{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(result.Records))
+	for _, r := range result.Records {
+		ids = append(ids, r.Id)
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("合并后的记录顺序错误:%v\n", ids)
+	}
+	if !result.Partial() || len(result.Failures["test3"]) == 0 {
+		t.Errorf("没有返回查找失败的节点:%v\n", result.Failures)
+	}
+	//各分片按汇总的统计信息评分
+	for _, f := range []*findStorage{test1, test2} {
+		if f.received == nil || f.received.Docs["desc"] != 30 || f.received.DF["desc"]["测试"] != 5 {
+			t.Errorf("分片没有收到汇总的统计信息:%v\n", f.received)
+		}
+	}
+
+	failed := []Shard{{Name: "test1", Backend: &findStorage{err: errors.New("节点不可用")}}}
+	kv = New("testdb", NewRangeChooser(3, 15, 1), nil, failed)
+	if _, err = kv.Find("测试", nil); err == nil {
+		t.Error("所有节点查找失败时没有返回错误")
+	}
+}
+
+//按分库保存记录的内存存储
+type memoryStorage struct {
+	api.Storage
+	dbs map[uint64]map[string]string
+	mu  sync.Mutex
+	//模拟节点不可用
+	down bool
+	//收到的批量请求数量
+	batches int
+	//收到的Get请求数量
+	gets int
+	//设置了生存时间的记录
+	ttls map[string]time.Duration
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{dbs: make(map[uint64]map[string]string), ttls: make(map[string]time.Duration)}
+}
+
+func (s *memoryStorage) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *memoryStorage) Get(key string, index uint64, item interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	if s.down {
+		return nil, errors.New("节点不可用")
+	}
+	value, ok := s.dbs[index][key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return value, nil
+}
+
+func (s *memoryStorage) Decode(text string, item interface{}) (interface{}, error) {
+	return text, nil
+}
+
+func (s *memoryStorage) GetText(key string, index uint64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return ""
+	}
+	return s.dbs[index][key]
+}
+
+func (s *memoryStorage) SetText(key string, value string, index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("节点不可用")
+	}
+	if s.dbs[index] == nil {
+		s.dbs[index] = make(map[string]string)
+	}
+	s.dbs[index][key] = value
+	delete(s.ttls, key)
+	return nil
+}
+
+func (s *memoryStorage) Set(key string, index uint64, value interface{}) (error, string) {
+	return s.SetText(key, fmt.Sprint(value), index), key
+}
+
+func (s *memoryStorage) SetTextWithTTL(key string, value string, index uint64, ttl time.Duration) error {
+	if err := s.SetText(key, value, index); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttls[key] = ttl
+	return nil
+}
+
+func (s *memoryStorage) Delete(key string, index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("节点不可用")
+	}
+	if _, ok := s.dbs[index][key]; !ok {
+		return errors.New("not found")
+	}
+	delete(s.dbs[index], key)
+	delete(s.ttls, key)
+	return nil
+}
+
+func (s *memoryStorage) Export(index uint64, after string, limit int) (*entities.ExportPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0)
+	for key := range s.dbs[index] {
+		if key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	page := &entities.ExportPage{}
+	for _, key := range keys {
+		if len(page.Records) == limit {
+			page.Next = page.Records[limit-1].Key
+			break
+		}
+		page.Records = append(page.Records, entities.ExportRecord{Key: key, TTL: int64(s.ttls[key] / time.Millisecond)})
+	}
+	return page, nil
+}
+
+func (s *memoryStorage) batch(items []*entities.BatchItem, op func(item *entities.BatchItem) error) error {
+	s.mu.Lock()
+	s.batches++
+	down := s.down
+	s.mu.Unlock()
+	if down {
+		return errors.New("节点不可用")
+	}
+	for _, item := range items {
+		item.Err = op(item)
+	}
+	return nil
+}
+
+func (s *memoryStorage) MultiGetText(items []*entities.BatchItem) error {
+	return s.batch(items, func(item *entities.BatchItem) error {
+		item.Value = s.GetText(item.Key, item.Index)
+		return nil
+	})
+}
+
+func (s *memoryStorage) MultiSetText(items []*entities.BatchItem) error {
+	return s.batch(items, func(item *entities.BatchItem) error {
+		return s.SetText(item.Key, item.Value, item.Index)
+	})
+}
+
+func (s *memoryStorage) MultiSet(items []*entities.BatchItem) error {
+	return s.batch(items, func(item *entities.BatchItem) error {
+		return s.SetText(item.Key, item.Value, item.Index)
+	})
+}
+
+func (s *memoryStorage) MultiDelete(items []*entities.BatchItem) error {
+	return s.batch(items, func(item *entities.BatchItem) error {
+		return s.Delete(item.Key, item.Index)
+	})
+}
+
+func (s *memoryStorage) Scan(index uint64, start uint64, end uint64, limit int) (*entities.ScanPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, errors.New("节点不可用")
+	}
+	records := make([]entities.KeyValue, 0)
+	for key, value := range s.dbs[index] {
+		nKey, _ := strconv.ParseUint(key, 10, 64)
+		if nKey >= start && nKey <= end {
+			records = append(records, entities.KeyValue{Key: nKey, Value: value})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	page := &entities.ScanPage{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.Next = records[limit].Key
+	}
+	return page, nil
+}
+
+func (s *memoryStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, db := range s.dbs {
+		count = count + len(db)
+	}
+	return count
+}
+
+func TestKVStore_Rebalance(t *testing.T) {
+	a, b, c := newMemoryStorage(), newMemoryStorage(), newMemoryStorage()
+	kv := New("testdb", NewRangeChooser(3, 10, 1), nil, []Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}}).(*KVStore)
+	for i := uint64(1); i <= 40; i++ {
+		kv.SetText(i, fmt.Sprintf("text-%d", i))
+	}
+	kv.SetTextWithTTL(30, "text-30", time.Hour)
+	//增加分片c,主键21-40迁移到c
+	checked := false
+	moved, err := kv.Rebalance([]Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}, {Name: "c", Backend: c}}, NewRangeChooser(3, 10, 1), func(moved int) {
+		if checked {
+			return
+		}
+		checked = true
+		if !kv.Rebalancing() {
+			t.Errorf("迁移期间没有处于重新平衡状态")
+		}
+		for i := uint64(1); i <= 40; i++ {
+			if text := kv.GetText(i); text != fmt.Sprintf("text-%d", i) {
+				t.Errorf("迁移期间读取记录[%d]错误:%s\n", i, text)
+			}
+		}
+		kv.SetText(35, "updated")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved > 20 || kv.Rebalancing() {
+		t.Errorf("迁移的记录数错误:%d\n", moved)
+	}
+	if a.count() != 10 || b.count() != 10 || c.count() != 20 {
+		t.Errorf("迁移后的记录分布错误:%d,%d,%d\n", a.count(), b.count(), c.count())
+	}
+	if text := kv.GetText(35); text != "updated" {
+		t.Errorf("迁移期间写入的记录被覆盖:%s\n", text)
+	}
+	if c.ttls["30"] != time.Hour {
+		t.Errorf("迁移的记录没有保留生存时间:%v\n", c.ttls["30"])
+	}
+	//删除分片a
+	if _, err = kv.Rebalance([]Shard{{Name: "b", Backend: b}, {Name: "c", Backend: c}}, NewRangeChooser(3, 10, 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	if a.count() != 0 || b.count() != 10 || c.count() != 30 {
+		t.Errorf("删除分片后的记录分布错误:%d,%d,%d\n", a.count(), b.count(), c.count())
+	}
+	for i := uint64(1); i <= 40; i++ {
+		if text := kv.GetText(i); len(text) == 0 {
+			t.Errorf("删除分片后记录[%d]丢失\n", i)
+		}
+	}
+	if _, ok := kv.storages["a"]; ok {
+		t.Errorf("删除的分片仍然存在")
+	}
+	if err = kv.Delete(strconv.Itoa(5)); err != nil || len(kv.GetText(5)) > 0 {
+		t.Errorf("删除记录失败:%v\n", err)
+	}
+}
+
+func TestKVStore_RebalanceFailure(t *testing.T) {
+	a, b, c := newMemoryStorage(), newMemoryStorage(), newMemoryStorage()
+	kv := New("testdb", NewRangeChooser(3, 10, 1), nil, []Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}}).(*KVStore)
+	for i := uint64(1); i <= 40; i++ {
+		kv.SetText(i, fmt.Sprintf("text-%d", i))
+	}
+	//新分片不可用时记录迁移失败,不切换分区布局
+	c.setDown(true)
+	_, err := kv.Rebalance([]Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}, {Name: "c", Backend: c}}, NewRangeChooser(3, 10, 1), nil)
+	if err == nil || !kv.Rebalancing() {
+		t.Fatalf("迁移失败时不应切换分区布局:%v\n", err)
+	}
+	if a.count() != 10 || b.count() != 30 {
+		t.Errorf("迁移失败时删除了原位置的记录:%d,%d\n", a.count(), b.count())
+	}
+	c.setDown(false)
+	if _, err = kv.ResumeRebalance(nil); err != nil || kv.Rebalancing() {
+		t.Fatalf("继续重新平衡失败:%v\n", err)
+	}
+	if a.count() != 10 || b.count() != 10 || c.count() != 20 {
+		t.Errorf("迁移后的记录分布错误:%d,%d,%d\n", a.count(), b.count(), c.count())
+	}
+	for i := uint64(1); i <= 40; i++ {
+		if text := kv.GetText(i); text != fmt.Sprintf("text-%d", i) {
+			t.Errorf("迁移后记录[%d]错误:%s\n", i, text)
+		}
+	}
+}
+
+func TestKVStore_RebalanceReplicas(t *testing.T) {
+	a, b, c := newMemoryStorage(), newMemoryStorage(), newMemoryStorage()
+	chooser := NewRangeChooser(3, 10, 1)
+	kv := New("testdb", chooser, nil, []Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}}).(*KVStore)
+	kv.SetReplication(2, WRITE_QUORUM_MAJORITY)
+	for i := uint64(1); i <= 40; i++ {
+		kv.SetText(i, fmt.Sprintf("text-%d", i))
+	}
+	//主副本没有的记录由其他副本迁移
+	_, index := chooser.Choose(15)
+	b.Delete("15", index)
+	//增加分片c,主键11-20的副本从b,a迁移到b,c,主键21-40迁移到c,a,每条记录只迁移一次
+	moved, err := kv.Rebalance([]Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}, {Name: "c", Backend: c}}, NewRangeChooser(3, 10, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 30 {
+		t.Errorf("迁移的记录数错误:%d\n", moved)
+	}
+	if a.count() != 30 || b.count() != 20 || c.count() != 30 {
+		t.Errorf("迁移后的记录分布错误:%d,%d,%d\n", a.count(), b.count(), c.count())
+	}
+	for i := uint64(1); i <= 40; i++ {
+		if text := kv.GetText(i); text != fmt.Sprintf("text-%d", i) {
+			t.Errorf("迁移后记录[%d]错误:%s\n", i, text)
+		}
+	}
+}
+
+func TestKVStore_Replication(t *testing.T) {
+	a, b, c := newMemoryStorage(), newMemoryStorage(), newMemoryStorage()
+	chooser := NewRangeChooser(3, 10, 1)
+	kv := New("testdb", chooser, nil, []Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}, {Name: "c", Backend: c}}).(*KVStore)
+	kv.SetReplication(3, WRITE_QUORUM_MAJORITY)
+	if names := chooser.ChooseReplicas(25, 3); strings.Join(names, ",") != "c,a,b" {
+		t.Errorf("副本分片错误:%v\n", names)
+	}
+	_, index := chooser.Choose(5)
+	if err := kv.SetText(5, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if a.count() != 1 || b.count() != 1 || c.count() != 1 {
+		t.Errorf("没有写入所有副本:%d,%d,%d\n", a.count(), b.count(), c.count())
+	}
+	//主副本不可用时从其他副本读取,多数副本写入成功即可
+	a.setDown(true)
+	reads := b.batches
+	if text := kv.GetText(5); text != "v1" {
+		t.Errorf("主副本不可用时读取错误:%s\n", text)
+	}
+	//读取失败的副本不作为不一致的副本,不会触发读修复
+	if b.batches-reads != 1 {
+		t.Errorf("不可用的副本触发了读修复:%d\n", b.batches-reads)
+	}
+	if item, err := kv.Get(5, nil); err != nil || item != "v1" {
+		t.Errorf("主副本不可用时读取错误:%v,%v\n", item, err)
+	}
+	//有多个副本时直接解析多数副本的文本,不再重新读取
+	if a.gets+b.gets+c.gets > 0 {
+		t.Errorf("读取记录时重复读取了副本:%d\n", a.gets+b.gets+c.gets)
+	}
+	if err := kv.SetText(5, "v2"); err != nil {
+		t.Errorf("多数副本写入成功时返回错误:%s\n", err.Error())
+	}
+	b.setDown(true)
+	if err := kv.SetText(6, "v1"); err == nil {
+		t.Errorf("写入成功的副本数量不足时没有返回错误")
+	}
+	a.setDown(false)
+	b.setDown(false)
+	//主副本恢复后读取时修复为多数副本的值
+	if text := kv.GetText(5); text != "v2" {
+		t.Errorf("副本不一致时读取错误:%s\n", text)
+	}
+	if text := a.GetText("5", index); text != "v2" {
+		t.Errorf("没有修复不一致的副本:%s\n", text)
+	}
+	kv.SetReplication(3, WRITE_QUORUM_ALL)
+	c.setDown(true)
+	if err := kv.SetText(7, "v1"); err == nil {
+		t.Errorf("要求所有副本写入成功时没有返回错误")
+	}
+	c.setDown(false)
+	//删除时没有写入墓碑的副本不会在读修复时恢复记录
+	kv.SetReplication(3, WRITE_QUORUM_MAJORITY)
+	c.setDown(true)
+	if err := kv.Delete("5"); err != nil {
+		t.Fatal(err)
+	}
+	c.setDown(false)
+	if text := c.GetText("5", index); text != "v2" {
+		t.Errorf("不可用的副本被删除:%s\n", text)
+	}
+	if text := kv.GetText(5); len(text) > 0 {
+		t.Errorf("读修复恢复了已删除的记录:%s\n", text)
+	}
+	if _, err := kv.Get(5, nil); err == nil {
+		t.Errorf("读取已删除的记录时没有返回错误")
+	}
+	if !IsTombstone(a.GetText("5", index)) || !IsTombstone(b.GetText("5", index)) || !IsTombstone(c.GetText("5", index)) {
+		t.Errorf("没有在所有副本写入墓碑")
+	}
+	if err := kv.Delete("5"); err == nil {
+		t.Errorf("删除不存在的记录时没有返回错误")
+	}
+	if err := kv.SetText(5, "v3"); err != nil || kv.GetText(5) != "v3" {
+		t.Errorf("删除后重新写入错误:%v,%s\n", err, kv.GetText(5))
+	}
+
+	records := []entities.Record{{Id: "1", Score: 0.9}, {Id: "2", Score: 0.5}}
+	replicated := New("testdb", NewRangeChooser(3, 10, 1), nil, []Shard{
+		{Name: "a", Backend: &findStorage{records: records}},
+		{Name: "b", Backend: &findStorage{records: records}},
+	}).(*KVStore)
+	replicated.SetReplication(2, WRITE_QUORUM_MAJORITY)
+	result, err := replicated.Find("测试", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Records) != 2 {
+		t.Errorf("查找结果中有重复的副本记录:%v\n", result.Records)
+	}
+}
+
+func TestKVStore_Multi(t *testing.T) {
+	a, b := newMemoryStorage(), newMemoryStorage()
+	kv := New("testdb", NewRangeChooser(3, 10, 1), nil, []Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}}).(*KVStore)
+	values := make(map[uint64]string)
+	for i := uint64(1); i <= 20; i++ {
+		values[i] = fmt.Sprintf("text-%d", i)
+	}
+	if errs := kv.MultiSet(values); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if a.batches != 1 || b.batches != 1 || a.count() != 10 || b.count() != 10 {
+		t.Errorf("批量写入没有按节点分组:%d,%d\n", a.batches, b.batches)
+	}
+	got, errs := kv.MultiGet([]uint64{1, 15, 15, 30})
+	if len(errs) > 0 || len(got) != 2 || got[1] != "text-1" || got[15] != "text-15" {
+		t.Errorf("批量读取错误:%v,%v\n", got, errs)
+	}
+	if a.batches != 2 || b.batches != 2 {
+		t.Errorf("批量读取没有按节点分组:%d,%d\n", a.batches, b.batches)
+	}
+	errs = kv.MultiDelete([]uint64{2, 12, 30})
+	if len(errs) != 1 || errs[30] == nil {
+		t.Errorf("批量删除结果错误:%v\n", errs)
+	}
+	if a.count() != 9 || b.count() != 9 {
+		t.Errorf("批量删除后的记录数错误:%d,%d\n", a.count(), b.count())
+	}
+	//节点不可用时只有该节点上的主键失败
+	b.setDown(true)
+	got, errs = kv.MultiGet([]uint64{1, 15})
+	if got[1] != "text-1" || errs[15] == nil || len(errs) != 1 {
+		t.Errorf("节点不可用时批量读取结果错误:%v,%v\n", got, errs)
+	}
+	errs = kv.MultiSet(map[uint64]string{3: "updated", 13: "updated"})
+	if errs[3] != nil || errs[13] == nil {
+		t.Errorf("节点不可用时批量写入结果错误:%v\n", errs)
+	}
+	b.setDown(false)
+
+	//有多个副本时批量读取修复不一致的副本
+	kv.SetReplication(2, WRITE_QUORUM_ONE)
+	_, index := kv.continuum.Choose(4)
+	b.setDown(true)
+	if errs = kv.MultiSet(map[uint64]string{4: "v2", 5: "v2", 6: "v2"}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	b.setDown(false)
+	batches := b.batches
+	got, _ = kv.MultiGet([]uint64{4, 5, 6})
+	for _, nKey := range []uint64{4, 5, 6} {
+		_, i := kv.continuum.Choose(nKey)
+		if text := b.GetText(strconv.FormatUint(nKey, 10), i); got[nKey] != "v2" || text != "v2" {
+			t.Errorf("批量读取没有修复副本[%d]:%v,%s\n", nKey, got, text)
+		}
+	}
+	//读取、加锁后重新读取和修复各一个批量请求
+	if b.batches-batches != 3 {
+		t.Errorf("批量读修复没有按节点批量发送:%d\n", b.batches-batches)
+	}
+	//有多个副本时批量删除写入墓碑,没有写入墓碑的副本不会恢复记录
+	b.setDown(true)
+	if errs = kv.MultiDelete([]uint64{4}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	b.setDown(false)
+	if got, errs = kv.MultiGet([]uint64{4}); len(got) > 0 || len(errs) > 0 || !IsTombstone(b.GetText("4", index)) {
+		t.Errorf("批量读取恢复了已删除的记录:%v,%v,%s\n", got, errs, b.GetText("4", index))
+	}
+	if errs = kv.MultiDelete([]uint64{4}); errs[4] == nil {
+		t.Errorf("批量删除已删除的记录时没有返回错误")
+	}
+	page, err := kv.Scan(1, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range page.Records {
+		if r.Key == 4 || IsTombstone(r.Value) {
+			t.Errorf("扫描结果包含已删除的记录:%v\n", r)
+		}
+	}
+}
+
+func TestKVStore_Scan(t *testing.T) {
+	scanAll := func(kv api.IKVStoreClient, start uint64, end uint64) []uint64 {
+		result := make([]uint64, 0)
+		it := kv.Iterator(start, end, 7)
+		for it.Next() {
+			if it.Value() != fmt.Sprintf("text-%d", it.Key()) {
+				t.Errorf("记录[%d]的文本错误:%s\n", it.Key(), it.Value())
+			}
+			result = append(result, it.Key())
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		return result
+	}
+	checkRange := func(name string, result []uint64, start uint64, end uint64) {
+		if uint64(len(result)) != end-start+1 {
+			t.Fatalf("%s扫描的记录数错误:%d\n", name, len(result))
+		}
+		for i, key := range result {
+			if key != start+uint64(i) {
+				t.Fatalf("%s扫描结果没有按主键排序:%v\n", name, result)
+			}
+		}
+	}
+	a, b, c := newMemoryStorage(), newMemoryStorage(), newMemoryStorage()
+	shards := []Shard{{Name: "a", Backend: a}, {Name: "b", Backend: b}, {Name: "c", Backend: c}}
+	kv := New("testdb", NewRangeChooser(3, 10, 1), nil, shards).(*KVStore)
+	kv.SetReplication(2, WRITE_QUORUM_ALL)
+	for i := uint64(1); i <= 50; i++ {
+		kv.SetText(i, fmt.Sprintf("text-%d", i))
+	}
+	checkRange("按范围分区", scanAll(kv, 1, 50), 1, 50)
+	checkRange("按范围分区", scanAll(kv, 8, 33), 8, 33)
+	//主分片不可用时扫描副本
+	a.setDown(true)
+	checkRange("主分片不可用时", scanAll(kv, 3, 12), 3, 12)
+	a.setDown(false)
+	page, err := kv.Scan(5, 50, 10)
+	if err != nil || len(page.Records) != 10 || page.Next != 15 {
+		t.Errorf("分页扫描结果错误:%v,%v\n", page, err)
+	}
+
+	h := New("testdb", NewHashChooser(3, 0, nil), nil, []Shard{{Name: "a", Backend: newMemoryStorage()}, {Name: "b", Backend: newMemoryStorage()}})
+	for i := uint64(1); i <= 50; i++ {
+		h.SetText(i, fmt.Sprintf("text-%d", i))
+	}
+	checkRange("一致性哈希", scanAll(h, 1, 50), 1, 50)
+	checkRange("一致性哈希", scanAll(h, 20, 29), 20, 29)
+
+	//合并各副本的记录时与GetText相同按多数副本选择
+	ra, rb, rc := newMemoryStorage(), newMemoryStorage(), newMemoryStorage()
+	r := New("testdb", NewHashChooser(3, 0, nil), nil, []Shard{{Name: "a", Backend: ra}, {Name: "b", Backend: rb}, {Name: "c", Backend: rc}}).(*KVStore)
+	r.SetReplication(3, WRITE_QUORUM_MAJORITY)
+	for i := uint64(1); i <= 10; i++ {
+		r.SetText(i, fmt.Sprintf("text-%d", i))
+	}
+	//重新写入时没有写入成功的副本仍为墓碑
+	_, index := r.continuum.Choose(7)
+	ra.SetText("7", Tombstone, index)
+	//删除时没有写入墓碑的副本仍有记录
+	ra.setDown(true)
+	if err = r.Delete("8"); err != nil {
+		t.Fatal(err)
+	}
+	ra.setDown(false)
+	keys := scanAll(r, 1, 10)
+	if len(keys) != 9 || keys[6] != 7 || keys[7] != 9 {
+		t.Errorf("合并副本的扫描结果错误:%v\n", keys)
+	}
+}
+
+func TestRangeChooser_ChooseRange(t *testing.T) {
+	c := NewRangeChooser(3, 10, 1)
+	c.SetBuckets([]string{"a", "b", "c"})
+	ranges := c.ChooseRange(0, 100)
+	for _, r := range ranges {
+		for key := r.Start; key <= r.End; key++ {
+			if name, _ := c.Choose(key); name != r.Shard {
+				t.Fatalf("主键[%d]的分片错误:%s,%s\n", key, name, r.Shard)
+			}
+		}
+	}
+	if ranges[0].Start != 0 || ranges[len(ranges)-1].End != 100 {
+		t.Errorf("分片范围没有覆盖扫描范围:%v\n", ranges)
+	}
+}
+
+func TestHashChooser(t *testing.T) {
+	names := []string{"test1", "test2", "test3"}
+	c := NewHashChooser(3, 0, map[string]int{"test3": 2})
+	c.SetBuckets(names)
+	counts := make(map[string]int)
+	owners := make(map[uint64]string)
+	for i := uint64(1); i <= 30000; i++ {
+		shard, index := c.Choose(i)
+		if index != i%3+1 {
+			t.Fatalf("分库序号错误:%d,%d\n", i, index)
+		}
+		counts[shard]++
+		owners[i] = shard
+	}
+	//test3的权重为2,约占一半
+	if counts["test3"] < 12000 || counts["test1"] < 5000 || counts["test2"] < 5000 {
+		t.Errorf("主键分布不均匀:%v\n", counts)
+	}
+	if replicas := c.ChooseReplicas(1, 5); len(replicas) != 3 || replicas[0] != owners[1] {
+		t.Errorf("副本分片错误:%v\n", replicas)
+	}
+
+	c = NewHashChooser(3, 0, map[string]int{"test3": 2})
+	c.SetBuckets(append(names, "test4"))
+	moved := 0
+	for i := uint64(1); i <= 30000; i++ {
+		shard, _ := c.Choose(i)
+		if shard == owners[i] {
+			continue
+		}
+		moved++
+		if shard != "test4" {
+			t.Fatalf("增加分片后主键[%d]从%s移到了%s\n", i, owners[i], shard)
+		}
+	}
+	if moved == 0 || moved > 12000 {
+		t.Errorf("增加分片后迁移的主键数量错误:%d\n", moved)
+	}
+}
@@ -34,6 +34,13 @@ type DataItem struct {
 	ID    string
 }
 
+//模糊查找的结果,Distance为关键字与Key的编辑距离
+type FuzzyItem struct {
+	Key      Prefix
+	Item     Item
+	Distance int
+}
+
 type Trie struct {
 	prefix                   Prefix
 	item                     Item
@@ -86,30 +93,108 @@ func (trie *Trie) Set(key Prefix, item Item) {
 //}
 
 /*
-删除与item有关的关键字
+将item与关键字的关联标记为无效，关联仍保留在关键字上，由CompactItems回收
 */
 func (trie *Trie) DelItem(item string) error {
-	var (
-		found    bool
-		leftover Prefix
-		node     *Trie
-	)
-	_, node, found, leftover = trie.self.findSubtree(Prefix(item))
-
-	if !found || len(leftover) != 0 {
+	keys := trie.selfKeys(item)
+	if keys == nil {
 		return nil
 	}
-	if node.item == nil {
+	for key := range keys {
+		dataItem, found := trie.Find(Prefix(key))
+		if !found {
+			continue
+		}
+		itemMap := dataItem.(map[string]bool)
+		if _, ok := itemMap[item]; ok {
+			itemMap[item] = false
+		}
+	}
+	trie.self.Delete(Prefix(item))
+	return nil
+}
+
+/*
+删除item与所有关键字的关联，关键字不再关联任何item时从树中删除该关键字
+*/
+func (trie *Trie) RemoveItem(item string) error {
+	keys := trie.selfKeys(item)
+	if keys == nil {
 		return nil
 	}
-	foundItems := node.item.([]*Trie)
-	for _, dataNode := range foundItems {
-		itemMap := dataNode.item.(map[string]bool)
-		itemMap[item] = false
+	for key := range keys {
+		trie.removeItem(Prefix(key), item)
 	}
+	trie.self.Delete(Prefix(item))
 	return nil
 }
 
+/*
+回收被DelItem标记为无效的关联，返回回收的关联数量
+*/
+func (trie *Trie) CompactItems() int {
+	count := 0
+	emptyKeys := make([]Prefix, 0)
+	trie.walk(nil, func(prefix Prefix, item Item) error {
+		itemMap, ok := item.(map[string]bool)
+		if !ok {
+			return nil
+		}
+		for k, flag := range itemMap {
+			if !flag {
+				delete(itemMap, k)
+				count++
+			}
+		}
+		if len(itemMap) == 0 {
+			emptyKeys = append(emptyKeys, append(Prefix(nil), prefix...))
+		}
+		return nil
+	})
+	for _, key := range emptyKeys {
+		trie.Delete(key)
+	}
+	return count
+}
+
+func (trie *Trie) removeItem(key Prefix, item string) {
+	dataItem, found := trie.Find(key)
+	if !found {
+		return
+	}
+	itemMap, ok := dataItem.(map[string]bool)
+	if !ok {
+		return
+	}
+	delete(itemMap, item)
+	if len(itemMap) == 0 {
+		trie.Delete(key)
+	}
+}
+
+/*
+记录ID关联的关键字，反向索引保存关键字而不是节点，节点拆分和合并后仍然有效
+*/
+func (trie *Trie) selfKeys(item string) map[string]bool {
+	if trie.self == nil {
+		return nil
+	}
+	selfItem, found := trie.self.Find(Prefix(item))
+	if !found {
+		return nil
+	}
+	return selfItem.(map[string]bool)
+}
+
+func (trie *Trie) addSelf(item string, key Prefix) {
+	keys := trie.selfKeys(item)
+	if keys == nil {
+		keys = make(map[string]bool)
+		trie.self.Set(Prefix(item), keys)
+	}
+	keys[string(key)] = true
+}
+
 func (trie *Trie) Find(key Prefix) (item Item, result bool) {
 	var (
 		found    bool
@@ -140,6 +225,74 @@ func (trie *Trie) Find(key Prefix) (item Item, result bool) {
 	return node.item, true
 }
 
+/*
+查找与key的编辑距离(Levenshtein)不超过maxDistance的所有关键字
+*/
+func (trie *Trie) FindFuzzy(key Prefix, maxDistance int) []FuzzyItem {
+	if key == nil {
+		panic(ErrNilPrefix)
+	}
+	result := make([]FuzzyItem, 0)
+	row := make([]int, len(key)+1)
+	for i := range row {
+		row[i] = i
+	}
+	trie.fuzzyWalk(key, maxDistance, make(Prefix, 0, 32), row, &result)
+	return result
+}
+
+/*
+深度遍历前缀树，逐个字符计算编辑距离矩阵的下一行，当前行的最小值超过maxDistance时剪枝
+*/
+func (trie *Trie) fuzzyWalk(key Prefix, maxDistance int, prefix Prefix, row []int, result *[]FuzzyItem) {
+	for _, r := range trie.prefix {
+		row = nextDistanceRow(key, row, r)
+		prefix = append(prefix, r)
+		if minDistance(row) > maxDistance {
+			return
+		}
+	}
+	if trie.item != nil && row[len(key)] <= maxDistance {
+		found := FuzzyItem{Key: append(Prefix(nil), prefix...), Item: trie.item, Distance: row[len(key)]}
+		*result = append(*result, found)
+	}
+	for _, child := range trie.children.all() {
+		if child == nil {
+			continue
+		}
+		child.fuzzyWalk(key, maxDistance, prefix, row, result)
+	}
+}
+
+func nextDistanceRow(key Prefix, prev []int, r rune) []int {
+	row := make([]int, len(prev))
+	row[0] = prev[0] + 1
+	for i := 1; i < len(prev); i++ {
+		cost := 1
+		if key[i-1] == r {
+			cost = 0
+		}
+		row[i] = row[i-1] + 1
+		if prev[i]+1 < row[i] {
+			row[i] = prev[i] + 1
+		}
+		if prev[i-1]+cost < row[i] {
+			row[i] = prev[i-1] + cost
+		}
+	}
+	return row
+}
+
+func minDistance(row []int) int {
+	min := row[0]
+	for _, v := range row[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
 func (trie *Trie) MatchSubtree(key Prefix) (matched bool) {
 	_, _, matched, _ = trie.findSubtree(key)
 	return
@@ -245,10 +398,15 @@ func (trie *Trie) Delete(key Prefix) (deleted bool) {
 		return false
 	}
 
-	path, found, _ := trie.findSubtreePath(key)
-	if !found {
+	path, found, leftover := trie.findSubtreePath(key)
+	if !found || len(leftover) != 0 {
 		return false
 	}
+	//根节点合并时保留记录ID的反向索引
+	self := trie.self
+	defer func() {
+		trie.self = self
+	}()
 
 	node := path[len(path)-1]
 	var parent *Trie
@@ -382,6 +540,9 @@ SplitPrefix:
 	child = new(Trie)
 	*child = *node
 	*node = *innerNewTrie()
+	//根节点拆分时保留记录ID的反向索引
+	node.self = child.self
+	child.self = nil
 	node.prefix = child.prefix[:common]
 	child.prefix = child.prefix[common:]
 	child = child.compact()
@@ -419,9 +580,10 @@ func (trie *Trie) Append(key Prefix, item Item, isDataItem bool) (inserted bool)
 	}
 
 	var (
-		common int
-		node   *Trie = trie
-		child  *Trie
+		common  int
+		node    *Trie = trie
+		child   *Trie
+		fullKey Prefix = key
 	)
 
 	if node.prefix == nil {
@@ -457,6 +619,9 @@ SplitPrefix:
 	child = new(Trie)
 	*child = *node
 	*node = *innerNewTrie()
+	//根节点拆分时保留记录ID的反向索引
+	node.self = child.self
+	child.self = nil
 	node.prefix = child.prefix[:common]
 	child.prefix = child.prefix[common:]
 	child = child.compact()
@@ -487,7 +652,7 @@ InsertItem:
 		itemMap := node.item.(map[string]bool)
 		itemMap[strKey] = true
 		node.item = itemMap
-		trie.self.Append(Prefix(strKey), node, false)
+		trie.addSelf(strKey, fullKey)
 	} else {
 		if node.item == nil {
 			node.item = make([]*Trie, 0, 4)
@@ -866,7 +1031,8 @@ func (list *denseChildList) add(child *Trie) childList {
 	}
 
 	list.numChildren++
-	if i < list.headIndex {
+	//子节点全部删除后headIndex指向空位置,需要重新设置
+	if i < list.headIndex || list.children[list.headIndex] == nil {
 		list.headIndex = i
 	}
 	return list
@@ -107,3 +107,103 @@ func TestTrie_Append(t *testing.T) {
 	fmt.Println("删除之后:", result)
 
 }
+
+func TestTrie_FindFuzzy(t *testing.T) {
+	trie := NewTrie()
+	trie.Append(Prefix("WNY-11"), "1001", true)
+	trie.Append(Prefix("WNY-12"), "1002", true)
+	trie.Append(Prefix("HGY-2018"), "1003", true)
+	trie.Append(Prefix("温度计"), "1004", true)
+
+	found := trie.FindFuzzy(Prefix("WNY-13"), 1)
+	if len(found) != 2 {
+		t.Fatalf("编辑距离为1的关键字数量错误:%d\n", len(found))
+	}
+	for _, item := range found {
+		if item.Distance != 1 {
+			t.Errorf("关键字[%s]编辑距离错误:%d\n", string(item.Key), item.Distance)
+		}
+	}
+
+	found = trie.FindFuzzy(Prefix("HGY2018"), 1)
+	if len(found) != 1 || string(found[0].Key) != "HGY-2018" {
+		t.Errorf("缺少字符的关键字未找到:%v\n", found)
+	}
+
+	found = trie.FindFuzzy(Prefix("湿度计"), 1)
+	if len(found) != 1 || string(found[0].Key) != "温度计" {
+		t.Errorf("中文关键字未找到:%v\n", found)
+	}
+
+	if found = trie.FindFuzzy(Prefix("ABC"), 2); len(found) != 0 {
+		t.Errorf("不应找到关键字:%v\n", found)
+	}
+
+	found = trie.FindFuzzy(Prefix("WNY-11"), 0)
+	if len(found) != 1 || found[0].Distance != 0 {
+		t.Errorf("编辑距离为0时应精确匹配:%v\n", found)
+	}
+}
+
+func TestTrie_SplitRoot(t *testing.T) {
+	trie := NewTrie()
+	trie.Append(Prefix("ABC"), "1", true)
+	//与根节点前缀没有公共前缀的关键字使根节点拆分
+	trie.Append(Prefix("XYZ"), "2", true)
+	trie.Append(Prefix("ABD"), "3", true)
+	if err := trie.DelItem("1"); err != nil {
+		t.Fatal(err)
+	}
+	item, found := trie.Find(Prefix("ABC"))
+	if !found {
+		t.Fatal("拆分后关键字丢失")
+	}
+	if flag, ok := item.(map[string]bool)["1"]; ok && flag {
+		t.Errorf("根节点拆分后删除记录失败:%v\n", item)
+	}
+	if item, found = trie.Find(Prefix("XYZ")); !found || !item.(map[string]bool)["2"] {
+		t.Errorf("删除记录影响了其他关键字:%v\n", item)
+	}
+}
+
+func TestTrie_RemoveItem(t *testing.T) {
+	trie := NewTrie()
+	trie.Append(Prefix("ABC"), "1001", true)
+	trie.Append(Prefix("ABD"), "1001", true)
+	trie.Append(Prefix("ABD"), "1002", true)
+	trie.Append(Prefix("AB"), "1002", true)
+	trie.RemoveItem("1001")
+	if _, found := trie.Find(Prefix("ABC")); found {
+		t.Error("不再关联记录的关键字应被删除")
+	}
+	result, found := trie.Find(Prefix("ABD"))
+	if !found || len(result.(map[string]bool)) != 1 {
+		t.Errorf("关键字ABD关联的记录错误:%v\n", result)
+	}
+	trie.RemoveItem("1002")
+	if size := trie.Size(); size != 0 {
+		t.Errorf("删除全部记录后关键字数量错误:%d\n", size)
+	}
+	trie.Append(Prefix("ABC"), "1001", true)
+	if _, found = trie.Find(Prefix("ABC")); !found {
+		t.Error("删除后重新增加的关键字未找到")
+	}
+}
+
+func TestTrie_CompactItems(t *testing.T) {
+	trie := NewTrie()
+	trie.Append(Prefix("ABC"), "1001", true)
+	trie.Append(Prefix("ABC"), "1002", true)
+	trie.Append(Prefix("温度计"), "1002", true)
+	trie.DelItem("1002")
+	if count := trie.CompactItems(); count != 2 {
+		t.Errorf("回收的关联数量错误:%d\n", count)
+	}
+	if _, found := trie.Find(Prefix("温度计")); found {
+		t.Error("回收后没有关联记录的关键字应被删除")
+	}
+	result, _ := trie.Find(Prefix("ABC"))
+	if len(result.(map[string]bool)) != 1 {
+		t.Errorf("关键字ABC关联的记录错误:%v\n", result)
+	}
+}